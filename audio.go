@@ -0,0 +1,228 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+// typedef unsigned char Uint8;
+// void audioCallback(void *userdata, Uint8 *stream, int len);
+import "C"
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+	"unsafe"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Waveform is the shape of the tone emitted while the sound timer is set.
+type Waveform int
+
+// Waveforms the buzzer can generate.
+const (
+	WAVE_SQUARE Waveform = iota
+	WAVE_TRIANGLE
+)
+
+var (
+	// Wave is the waveform of the buzzer tone.
+	Wave = WAVE_SQUARE
+
+	// Tone is the pitch (in Hz) of the buzzer.
+	Tone = 440.0
+
+	// Volume is the amplitude (0..1) of the buzzer.
+	Volume = 0.25
+
+	// Attack is how long the buzzer takes to reach full volume.
+	Attack = 4 * time.Millisecond
+
+	// Release is how long the buzzer takes to fall silent.
+	Release = 8 * time.Millisecond
+
+	// phase is the position (0..1) within the current wave period.
+	phase float64
+
+	// envelope is the current volume level (0..1) of the buzzer.
+	envelope float64
+
+	// sound is the buzzer state played by the audio callback.
+	sound buzzer
+)
+
+// buzzer is the state of the VM's buzzer, copied each frame so the audio
+// callback can play it on its own thread.
+type buzzer struct {
+	sync.Mutex
+
+	// on is true while the sound timer is set and not muted.
+	on bool
+
+	// patterned is true if an XO-CHIP audio pattern is played.
+	patterned bool
+
+	// pattern is the 128, 1-bit samples of the audio pattern.
+	pattern [16]byte
+
+	// rate is the sample rate (in Hz) of the audio pattern.
+	rate float64
+}
+
+// ParseWaveform returns the waveform with a given name.
+func ParseWaveform(name string) (Waveform, error) {
+	switch name {
+	case "square", "":
+		return WAVE_SQUARE, nil
+	case "triangle":
+		return WAVE_TRIANGLE, nil
+	}
+
+	return WAVE_SQUARE, fmt.Errorf("unknown waveform: %s", name)
+}
+
+// initAudio initializes an audio device for the CHIP-8 virtual machine.
+func initAudio() {
+	var err error
+
+	// the desired audio specification
+	desiredSpec := &sdl.AudioSpec{
+		Freq:     44100,
+		Format:   sdl.AUDIO_F32LSB,
+		Channels: 1,
+		Samples:  512,
+		Callback: sdl.AudioCallback(C.audioCallback),
+	}
+
+	ObtainedSpec = &sdl.AudioSpec{}
+
+	// open the device and start playing it
+	if sdl.GetNumAudioDevices(false) > 0 {
+		if AudioDevice, err = sdl.OpenAudioDevice("", false, desiredSpec, ObtainedSpec, sdl.AUDIO_ALLOW_FREQUENCY_CHANGE); err != nil {
+			panic(err)
+		}
+
+		sdl.PauseAudioDevice(AudioDevice, false)
+	}
+}
+
+// toggleMute silences or restores the buzzer.
+func toggleMute() {
+	Muted = !Muted
+
+	if Muted {
+		Debug.Logln("Buzzer muted")
+	} else {
+		Debug.Logln("Buzzer unmuted")
+	}
+}
+
+// oscillate returns the next sample (-1..1) of the buzzer waveform and
+// advances the phase. If the VM has loaded an XO-CHIP audio pattern, the
+// phase steps through the 128 bits of the pattern instead.
+func oscillate(b *buzzer, rate float64) float64 {
+	var s float64
+
+	// play the audio pattern at its own sample rate
+	if b.patterned {
+		bit := int(phase * 128)
+
+		if s = -1; b.pattern[bit>>3]&(0x80>>uint(bit&7)) != 0 {
+			s = 1
+		}
+
+		// advance to the next sample
+		phase = math.Mod(phase+b.rate/(rate*128), 1)
+
+		return s
+	}
+
+	switch Wave {
+	case WAVE_SQUARE:
+		if s = 1; phase >= 0.5 {
+			s = -1
+		}
+	case WAVE_TRIANGLE:
+		s = 4*math.Abs(phase-0.5) - 1
+	}
+
+	// advance to the next sample
+	phase = math.Mod(phase+Tone/rate, 1)
+
+	return s
+}
+
+// updateSound copies the buzzer state of the VM for the audio callback.
+func updateSound() {
+	sound.Lock()
+	defer sound.Unlock()
+
+	// the buzzer sounds while the sound timer is set
+	sound.on = VM.Buzzing() && !Muted
+	sound.patterned = VM.Patterned
+	sound.pattern = VM.Pattern
+	sound.rate = VM.PatternRate()
+}
+
+// audioCallback is called by SDL on the audio thread to fill the stream
+// with the next buzzer samples.
+//
+//export audioCallback
+func audioCallback(userdata unsafe.Pointer, stream *C.Uint8, length C.int) {
+	n := int(length)
+	data := (*[1 << 30]byte)(unsafe.Pointer(stream))[:n:n]
+
+	// copy the buzzer state so the main loop isn't blocked
+	sound.Lock()
+	b := buzzer{on: sound.on, patterned: sound.patterned, pattern: sound.pattern, rate: sound.rate}
+	sound.Unlock()
+
+	// sample rate and bytes per sample frame (4 bytes per channel)
+	rate := float64(ObtainedSpec.Freq)
+	size := int(ObtainedSpec.Channels) * 4
+
+	// envelope change per sample while attacking and releasing
+	attack := 1 / (Attack.Seconds() * rate)
+	release := 1 / (Release.Seconds() * rate)
+
+	// generate each sample frame
+	for i := 0; i+size <= len(data); i += size {
+		if b.on {
+			envelope = math.Min(envelope+attack, 1)
+		} else {
+			envelope = math.Max(envelope-release, 0)
+		}
+
+		// don't advance the phase while silent so tones start cleanly
+		s := 0.0
+		if envelope > 0 {
+			s = oscillate(&b, rate) * Volume * envelope
+		} else {
+			phase = 0
+		}
+
+		// write the sample to every channel
+		for c := 0; c < size; c += 4 {
+			binary.LittleEndian.PutUint32(data[i+c:], math.Float32bits(float32(s)))
+		}
+	}
+}
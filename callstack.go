@@ -0,0 +1,131 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	// ShowCallStack is true if the call stack is shown in place of the
+	// registers.
+	ShowCallStack bool
+
+	// SelectedFrame is the call stack frame shown in the disassembly, or
+	// 0 to follow the PC.
+	SelectedFrame int
+)
+
+// toggleCallStack shows or hides the call stack panel.
+func toggleCallStack() {
+	ShowCallStack = !ShowCallStack
+	SelectedFrame = 0
+}
+
+// selectFrame picks the call stack frame to show in the disassembly,
+// clamped to the frames on the stack.
+func selectFrame(n int) {
+	if !ShowCallStack {
+		return
+	}
+
+	if n > int(VM.SP) {
+		n = int(VM.SP)
+	}
+
+	if n < 0 {
+		n = 0
+	}
+
+	SelectedFrame = n
+}
+
+// clickCallStack selects the frame clicked on in the call stack panel.
+func clickCallStack(x, y int32) {
+	if !ShowCallStack || x < 402 || x > 606 || y < 222 || y > 372 {
+		return
+	}
+
+	selectFrame(int(y-222) / 10)
+}
+
+// framePC returns the address to show in the disassembly: the PC, or
+// the CALL instruction of the selected frame.
+func framePC() uint {
+	frames := VM.CallStack()
+
+	// the stack may have unwound since it was selected
+	if SelectedFrame > len(frames) {
+		SelectedFrame = len(frames)
+	}
+
+	if SelectedFrame == 0 {
+		return VM.PC
+	}
+
+	return frames[SelectedFrame-1].Site
+}
+
+// drawCallStack shows the current PC followed by every return address
+// on the stack, and the subroutine that was called, innermost first.
+func drawCallStack() {
+	x, y := 406, 212
+
+	drawText("RETURN           CALLEE", x, y)
+
+	// the first row is the current instruction
+	rows := []string{fmt.Sprintf("%04X %-11s", VM.PC, clip(VM.Location(VM.PC), 11))}
+
+	for _, f := range VM.CallStack() {
+		rows = append(rows, fmt.Sprintf("%04X %-11s %s", f.Return, clip(VM.Location(f.Return), 11), clip(VM.Symbol(f.Callee), 11)))
+	}
+
+	for i, row := range rows {
+		if i >= 15 {
+			break
+		}
+
+		// highlight the frame shown in the disassembly
+		if i == SelectedFrame {
+			Renderer.SetDrawColor(57, 102, 176, 255)
+			Renderer.FillRect(&sdl.Rect{
+				X: int32(x - 2),
+				Y: int32(y+10+i*10) - 1,
+				W: 202,
+				H: 10,
+			})
+		}
+
+		drawText(row, x, y+10+i*10)
+	}
+}
+
+// clip truncates a string to at most n characters.
+func clip(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+
+	return s
+}
@@ -42,6 +42,10 @@ type Assembly struct {
 	// Addresses with unresolved labels.
 	Unresolved map[int]string
 
+	// Lines maps the address of each instruction (or data) assembled to
+	// the line of source it was assembled from.
+	Lines map[int]int
+
 	// Base address the ROM begins at (0x200 or 0x600 for ETI).
 	Base int
 
@@ -75,6 +79,7 @@ func Assemble(program []byte, eti bool) (out *Assembly, err error) {
 		Breakpoints: make([]Breakpoint, 0, 10),
 		Labels:      make(map[string]token),
 		Unresolved:  make(map[int]string),
+		Lines:       make(map[int]int),
 		Base:        base,
 	}
 
@@ -101,12 +106,39 @@ func Assemble(program []byte, eti bool) (out *Assembly, err error) {
 
 	// parse and assemble
 	for line = 1; scanner.Scan(); line++ {
+		address := len(out.ROM)
+
 		out.assemble(&tokenScanner{bytes: scanner.Bytes()})
+
+		// remember where the line was assembled to
+		if len(out.ROM) > address {
+			out.Lines[address] = line
+		}
 	}
 
 	// resolve all label addresses
-	for address, label := range out.Unresolved {
-		if t, ok := out.Labels[label]; ok {
+	out.resolve()
+
+	// clear the line number as we're done assembling
+	line = 0
+
+	// if there are any unresolved addresses, panic
+	for _, label := range out.Unresolved {
+		panic(fmt.Errorf("unresolved label: %s", label))
+	}
+
+	// drop the first 512 bytes from the rom
+	out.ROM = out.ROM[base:]
+
+	// done
+	return
+}
+
+// Resolve the addresses of labels that were referenced before they were
+// defined. Any labels still undefined are left unresolved.
+func (a *Assembly) resolve() {
+	for address, label := range a.Unresolved {
+		if t, ok := a.Labels[label]; ok {
 			if t.typ != TOKEN_LIT {
 				panic("label does not resolve to address!")
 			}
@@ -125,27 +157,95 @@ func Assemble(program []byte, eti bool) (out *Assembly, err error) {
 			//       The only other use case is the WORD instruction to write
 			//       16-bit values, and since the unresolved label defaulted
 			//       to 0x0200, overwriting it works just fine.
-			out.ROM[address] = msb | (out.ROM[address] & 0xF0)
-			out.ROM[address+1] = lsb
+			a.ROM[address] = msb | (a.ROM[address] & 0xF0)
+			a.ROM[address+1] = lsb
 
 			// delete the unresolved Address
-			delete(out.Unresolved, address)
+			delete(a.Unresolved, address)
 		}
 	}
+}
 
-	// clear the line number as we're done assembling
-	line = 0
+// Symbols returns the labels that name addresses in the ROM, by address.
+// If more than one label names the same address, the first in sorted
+// order is used. EQU constants within the ROM are indistinguishable from
+// addresses.
+func (a *Assembly) Symbols() map[uint]string {
+	symbols := make(map[uint]string)
 
-	// if there are any unresolved addresses, panic
-	for _, label := range out.Unresolved {
-		panic(fmt.Errorf("unresolved label: %s", label))
+	for label, t := range a.Labels {
+		if t.typ != TOKEN_LIT {
+			continue
+		}
+
+		address := t.val.(int)
+
+		// only labels within the program
+		if address < a.Base || address > a.Base+len(a.ROM) {
+			continue
+		}
+
+		if s, ok := symbols[uint(address)]; !ok || label < s {
+			symbols[uint(address)] = label
+		}
 	}
 
-	// drop the first 512 bytes from the rom
-	out.ROM = out.ROM[base:]
+	return symbols
+}
 
-	// done
-	return
+// Aliases returns the labels declared with VAR, by V register. If more
+// than one label names the same register, the first in sorted order is
+// used.
+func (a *Assembly) Aliases() map[uint]string {
+	aliases := make(map[uint]string)
+
+	for label, t := range a.Labels {
+		if t.typ != TOKEN_V {
+			continue
+		}
+
+		r := uint(t.val.(int))
+
+		if s, ok := aliases[r]; !ok || label < s {
+			aliases[r] = label
+		}
+	}
+
+	return aliases
+}
+
+// Line returns the line of source that the instruction (or data) at an
+// address was assembled from, or 0 if it wasn't assembled from source.
+func (a *Assembly) Line(address int) int {
+	if address < a.Base || address >= len(a.ROM)+a.Base {
+		return 0
+	}
+
+	best, line := -1, 0
+
+	// find the nearest line assembled at or before the address
+	for at, n := range a.Lines {
+		if at <= address && at > best {
+			best, line = at, n
+		}
+	}
+
+	return line
+}
+
+// Address returns the address of the first instruction (or data)
+// assembled from a line of source, or from the nearest line after it.
+// Returns false if nothing was assembled from any of those lines.
+func (a *Assembly) Address(line int) (int, bool) {
+	address, best := 0, 0
+
+	for at, n := range a.Lines {
+		if n >= line && (best == 0 || n < best) {
+			address, best = at, n
+		}
+	}
+
+	return address, best != 0
 }
 
 // Compile a single line into the assembly.
@@ -0,0 +1,281 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"fmt"
+)
+
+// Predecoded instruction. The PC has already been advanced past it.
+type op func(vm *CHIP_8) error
+
+// A straight-line run of predecoded instructions. Only the last can
+// change the flow of execution.
+type block struct {
+	ops []op
+}
+
+// Predecoded instruction blocks, keyed by the address they start at.
+type decodeCache struct {
+	blocks [0x1000]*block
+
+	// code is true for every byte of memory that was predecoded.
+	code [0x1000]bool
+}
+
+// The longest block predecoded.
+const maxBlock = 64
+
+// Poke writes a byte to memory, wrapping around. Anything other than the
+// VM itself that modifies memory should use Poke so predecoded
+// instructions are thrown away. It isn't tracked as an access.
+func (vm *CHIP_8) Poke(address uint, b byte) {
+	address &= 0xFFF
+
+	vm.Memory[address] = b
+
+	if vm.cache != nil && vm.cache.code[address] {
+		vm.cache = nil
+	}
+}
+
+// Write a byte to memory, wrapping around, and track the access.
+func (vm *CHIP_8) write(address uint, b byte) {
+	vm.access(address&0xFFF, ACCESS_WRITE)
+	vm.Poke(address, b)
+}
+
+// Find - or predecode - the block of instructions at an address.
+func (vm *CHIP_8) block(address uint) *block {
+	if vm.cache == nil {
+		vm.cache = &decodeCache{}
+	} else if b := vm.cache.blocks[address]; b != nil {
+		return b
+	}
+
+	b := &block{ops: make([]op, 0, 8)}
+
+	// decode until a branch
+	for a := address; a < 0x1000 && len(b.ops) < maxBlock; a += 2 {
+		inst := uint(vm.Memory[a])<<8 | uint(vm.Memory[(a+1)&0xFFF])
+
+		// mark the instruction as code
+		vm.cache.code[a] = true
+		vm.cache.code[(a+1)&0xFFF] = true
+
+		o, branch := decode(inst)
+		b.ops = append(b.ops, o)
+
+		if branch {
+			break
+		}
+	}
+
+	vm.cache.blocks[address] = b
+
+	return b
+}
+
+// Execute the next instruction using the predecoded cache.
+func (vm *CHIP_8) executeCached() error {
+	pc := vm.PC & 0xFFF
+
+	// advance the program counter
+	vm.PC = pc + 2
+
+	return vm.block(pc).ops[0](vm)
+}
+
+// Execute predecoded blocks of instructions starting at the PC, up to a
+// limit of instructions. Stops early on an error, breakpoint, or when
+// waiting for a key.
+func (vm *CHIP_8) runBlock(limit int64) error {
+	for n := int64(0); n < limit && vm.W == nil; {
+		pc := vm.PC & 0xFFF
+		ops := vm.block(pc).ops
+		cache := vm.cache
+
+		for _, o := range ops {
+			if n >= limit {
+				break
+			}
+
+			at := vm.PC
+
+			// advance the program counter
+			vm.PC = pc + 2
+
+			// stay on a failed instruction, forgetting any memory it watched
+			if err := o(vm); err != nil {
+				vm.PC = at
+				vm.watched = nil
+				return err
+			}
+
+			if err := vm.retire(at); err != nil {
+				return err
+			}
+
+			n++
+
+			// self-modifying code invalidated the block
+			if vm.cache != cache {
+				break
+			}
+
+			pc += 2
+		}
+	}
+
+	return nil
+}
+
+// Decode an instruction into a function that executes it. Also returns
+// true if the instruction can change the flow of execution.
+func decode(inst uint) (op, bool) {
+	// 12-bit address operand
+	a := inst & 0xFFF
+
+	// byte and nibble operands
+	b := byte(inst & 0xFF)
+	n := byte(inst & 0xF)
+
+	// x and y register operands
+	x := inst >> 8 & 0xF
+	y := inst >> 4 & 0xF
+
+	// instruction decoding
+	if inst == 0x00E0 {
+		return func(vm *CHIP_8) error { vm.cls(); return nil }, false
+	} else if inst == 0x00EE {
+		return func(vm *CHIP_8) error { return vm.ret() }, true
+	} else if inst == 0x00FB {
+		return func(vm *CHIP_8) error { vm.scrollRight(); return nil }, false
+	} else if inst == 0x00FC {
+		return func(vm *CHIP_8) error { vm.scrollLeft(); return nil }, false
+	} else if inst == 0x00FD {
+		return func(vm *CHIP_8) error { vm.exit(); return nil }, true
+	} else if inst == 0x00FE {
+		return func(vm *CHIP_8) error { vm.low(); return nil }, false
+	} else if inst == 0x00FF {
+		return func(vm *CHIP_8) error { vm.high(); return nil }, false
+	} else if inst&0xFFF0 == 0x00B0 {
+		return func(vm *CHIP_8) error { vm.scrollUp(n); return nil }, false
+	} else if inst&0xFFF0 == 0x00C0 {
+		return func(vm *CHIP_8) error { vm.scrollDown(n); return nil }, false
+	} else if inst&0xF000 == 0x0000 {
+		return func(vm *CHIP_8) error { return vm.sys(a) }, true
+	} else if inst&0xF000 == 0x1000 {
+		return func(vm *CHIP_8) error { vm.jump(a); return nil }, true
+	} else if inst&0xF000 == 0x2000 {
+		return func(vm *CHIP_8) error { return vm.call(a) }, true
+	} else if inst&0xF000 == 0x3000 {
+		return func(vm *CHIP_8) error { vm.skipIf(x, b); return nil }, true
+	} else if inst&0xF000 == 0x4000 {
+		return func(vm *CHIP_8) error { vm.skipIfNot(x, b); return nil }, true
+	} else if inst&0xF00F == 0x5000 {
+		return func(vm *CHIP_8) error { vm.skipIfXY(x, y); return nil }, true
+	} else if inst&0xF00F == 0x5001 {
+		return func(vm *CHIP_8) error { vm.skipIfGreater(x, y); return nil }, true
+	} else if inst&0xF00F == 0x5002 {
+		return func(vm *CHIP_8) error { vm.skipIfLess(x, y); return nil }, true
+	} else if inst&0xF000 == 0x6000 {
+		return func(vm *CHIP_8) error { vm.loadX(x, b); return nil }, false
+	} else if inst&0xF000 == 0x7000 {
+		return func(vm *CHIP_8) error { vm.addX(x, b); return nil }, false
+	} else if inst&0xF00F == 0x8000 {
+		return func(vm *CHIP_8) error { vm.loadXY(x, y); return nil }, false
+	} else if inst&0xF00F == 0x8001 {
+		return func(vm *CHIP_8) error { vm.or(x, y); return nil }, false
+	} else if inst&0xF00F == 0x8002 {
+		return func(vm *CHIP_8) error { vm.and(x, y); return nil }, false
+	} else if inst&0xF00F == 0x8003 {
+		return func(vm *CHIP_8) error { vm.xor(x, y); return nil }, false
+	} else if inst&0xF00F == 0x8004 {
+		return func(vm *CHIP_8) error { vm.addXY(x, y); return nil }, false
+	} else if inst&0xF00F == 0x8005 {
+		return func(vm *CHIP_8) error { vm.subXY(x, y); return nil }, false
+	} else if inst&0xF00F == 0x8006 {
+		return func(vm *CHIP_8) error { vm.shr(x, y); return nil }, false
+	} else if inst&0xF00F == 0x8007 {
+		return func(vm *CHIP_8) error { vm.subYX(x, y); return nil }, false
+	} else if inst&0xF00F == 0x800E {
+		return func(vm *CHIP_8) error { vm.shl(x, y); return nil }, false
+	} else if inst&0xF00F == 0x9000 {
+		return func(vm *CHIP_8) error { vm.skipIfNotXY(x, y); return nil }, true
+	} else if inst&0xF00F == 0x9001 {
+		return func(vm *CHIP_8) error { vm.mulXY(x, y); return nil }, false
+	} else if inst&0xF00F == 0x9002 {
+		return func(vm *CHIP_8) error { return vm.divXY(x, y) }, false
+	} else if inst&0xF0FF == 0xF033 {
+		return func(vm *CHIP_8) error { vm.bcd(x); return nil }, false
+	} else if inst&0xF00F == 0x9003 {
+		return func(vm *CHIP_8) error { vm.bcd16(x, y); return nil }, false
+	} else if inst&0xF000 == 0xA000 {
+		return func(vm *CHIP_8) error { vm.loadI(a); return nil }, false
+	} else if inst&0xF000 == 0xB000 {
+		return func(vm *CHIP_8) error { vm.jumpV0(a); return nil }, true
+	} else if inst&0xF000 == 0xC000 {
+		return func(vm *CHIP_8) error { vm.loadRandom(x, b); return nil }, false
+	} else if inst&0xF00F == 0xD000 {
+		return func(vm *CHIP_8) error { vm.drawSpriteEx(x, y); return nil }, false
+	} else if inst&0xF000 == 0xD000 {
+		return func(vm *CHIP_8) error { vm.drawSprite(x, y, n); return nil }, false
+	} else if inst&0xF0FF == 0xE09E {
+		return func(vm *CHIP_8) error { vm.skipIfPressed(x); return nil }, true
+	} else if inst&0xF0FF == 0xE0A1 {
+		return func(vm *CHIP_8) error { vm.skipIfNotPressed(x); return nil }, true
+	} else if inst&0xF0FF == 0xF007 {
+		return func(vm *CHIP_8) error { vm.loadXDT(x); return nil }, false
+	} else if inst&0xF0FF == 0xF00A {
+		return func(vm *CHIP_8) error { vm.loadXK(x); return nil }, true
+	} else if inst&0xF0FF == 0xF015 {
+		return func(vm *CHIP_8) error { vm.loadDTX(x); return nil }, false
+	} else if inst&0xF0FF == 0xF018 {
+		return func(vm *CHIP_8) error { vm.loadSTX(x); return nil }, false
+	} else if inst == 0xF002 {
+		return func(vm *CHIP_8) error { vm.loadPattern(); return nil }, false
+	} else if inst&0xF0FF == 0xF01E {
+		return func(vm *CHIP_8) error { vm.addIX(x); return nil }, false
+	} else if inst&0xF0FF == 0xF029 {
+		return func(vm *CHIP_8) error { vm.loadF(x); return nil }, false
+	} else if inst&0xF0FF == 0xF030 {
+		return func(vm *CHIP_8) error { vm.loadHF(x); return nil }, false
+	} else if inst&0xF0FF == 0xF03A {
+		return func(vm *CHIP_8) error { vm.loadTone(x); return nil }, false
+	} else if inst&0xF0FF == 0xF055 {
+		return func(vm *CHIP_8) error { vm.saveRegs(x); return nil }, false
+	} else if inst&0xF0FF == 0xF065 {
+		return func(vm *CHIP_8) error { vm.loadRegs(x); return nil }, false
+	} else if inst&0xF0FF == 0xF075 {
+		return func(vm *CHIP_8) error { vm.storeR(x); return nil }, false
+	} else if inst&0xF0FF == 0xF085 {
+		return func(vm *CHIP_8) error { vm.readR(x); return nil }, false
+	} else if inst&0xF0FF == 0xF094 {
+		return func(vm *CHIP_8) error { vm.loadASCII(x); return nil }, false
+	}
+
+	// invalid instructions fail every time
+	err := fmt.Errorf("Invalid opcode: %04X", inst)
+
+	return func(vm *CHIP_8) error { return err }, true
+}
@@ -0,0 +1,64 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"io/ioutil"
+	"testing"
+)
+
+// Run a game as fast as possible, with or without predecoding.
+func benchmarkGame(b *testing.B, file string, predecode bool) {
+	program, err := ioutil.ReadFile(file)
+	if err != nil {
+		b.Skip(err)
+	}
+
+	vm, err := LoadROM(program, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// a speed high enough that timers barely matter
+	vm.Predecode = predecode
+	vm.Speed = 1000000
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if err = vm.RunFrames(1); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(vm.Steps)/float64(b.N), "inst/op")
+}
+
+// BenchmarkInterpreter decodes every instruction as it is executed.
+func BenchmarkInterpreter(b *testing.B) {
+	benchmarkGame(b, "../games/roms/BRIX", false)
+}
+
+// BenchmarkPredecoded executes blocks of predecoded instructions.
+func BenchmarkPredecoded(b *testing.B) {
+	benchmarkGame(b, "../games/roms/BRIX", true)
+}
@@ -0,0 +1,104 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"fmt"
+)
+
+// Frame is a subroutine call on the stack.
+type Frame struct {
+	// Site is the address of the CALL instruction.
+	Site uint
+
+	// Return is the address the subroutine returns to.
+	Return uint
+
+	// Callee is the address of the subroutine called.
+	Callee uint
+}
+
+// CallStack returns the subroutine calls on the stack, innermost first.
+// The callee of each frame is found by decoding the CALL before the
+// return address.
+func (vm *CHIP_8) CallStack() []Frame {
+	frames := make([]Frame, 0, vm.SP)
+
+	for i := int(vm.SP) - 1; i >= 0; i-- {
+		if i >= len(vm.Stack) {
+			continue
+		}
+
+		ret := vm.Stack[i]
+		site := (ret - 2) & 0xFFF
+
+		// decode the address called
+		inst := uint(vm.Memory[site])<<8 | uint(vm.Memory[(site+1)&0xFFF])
+
+		frames = append(frames, Frame{
+			Site:   site,
+			Return: ret,
+			Callee: inst & 0xFFF,
+		})
+	}
+
+	return frames
+}
+
+// Symbol returns the label at an address, or the address if there isn't
+// one.
+func (vm *CHIP_8) Symbol(address uint) string {
+	if s, ok := vm.Symbols[address]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("#%04X", address)
+}
+
+// Location returns the nearest label at or before an address plus the
+// offset from it, or the address if there is no label before it.
+func (vm *CHIP_8) Location(address uint) string {
+	if s := location(vm.Symbols, address); s != "" {
+		return s
+	}
+
+	return fmt.Sprintf("#%04X", address)
+}
+
+// Find the nearest label at or before an address and return it with the
+// offset from it, or "" if there is no label before it.
+func location(symbols map[uint]string, address uint) string {
+	var label string
+	var at uint
+
+	for a, s := range symbols {
+		if a <= address && (label == "" || a > at || (a == at && s < label)) {
+			label, at = s, a
+		}
+	}
+
+	if label == "" || at == address {
+		return label
+	}
+
+	return fmt.Sprintf("%s+%d", label, address-at)
+}
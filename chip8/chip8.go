@@ -25,7 +25,10 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"math/rand"
+	"path/filepath"
+	"strings"
 	"time"
 	"unicode"
 )
@@ -76,14 +79,26 @@ type CHIP_8 struct {
 	// R are the 8, HP-RPL user flags.
 	R [8]byte
 
-	// DT is the delay timer register. It is set to a time (in ns) in the
-	// future and compared against the current time.
+	// DT is the delay timer register. It is set to an emulated time (in
+	// ns) in the future and compared against Time.
 	DT int64
 
-	// ST is the sound timer register. It is set to a time (in ns) in the
-	// future and compared against the current time.
+	// ST is the sound timer register. It is set to an emulated time (in
+	// ns) in the future and compared against Time.
 	ST int64
 
+	// Pattern is the XO-CHIP audio pattern buffer. It is 128, 1-bit
+	// samples (MSB first) played in a loop while the sound timer is set.
+	Pattern [16]byte
+
+	// Patterned is true once an audio pattern has been loaded. Until
+	// then, the buzzer is expected to emit a plain tone.
+	Patterned bool
+
+	// Tone is the XO-CHIP pitch register. It sets the rate at which the
+	// audio pattern is played back. See PatternRate.
+	Tone byte
+
 	// Clock is the time (in ns) when emulation begins.
 	Clock int64
 
@@ -91,6 +106,28 @@ type CHIP_8 struct {
 	// one clock cycle per instruction.
 	Cycles int64
 
+	// Time is the emulated time (in ns) since the last reset. Each
+	// instruction executed advances it by 1/Speed of a second, and it
+	// advances with the clock while paused or waiting for a key. The
+	// timer registers count down relative to it.
+	Time int64
+
+	// Steps is how many instructions have been executed since the last
+	// reset. Unlike Cycles, it isn't reset when the speed changes.
+	Steps int64
+
+	// Seed is the random number seed used on reset.
+	Seed int64
+
+	// rng generates random numbers for RND instructions.
+	rng *rand.Rand
+
+	// Recording is the movie that inputs are being recorded to, if any.
+	Recording *Movie
+
+	// Playback is the movie being played back, if any.
+	Playback *Player
+
 	// Speed is how many cycles (instructions) should execute per second.
 	// By default this is 700. The RCA CDP1802 ran at 1.76 MHz, with each
 	// instruction taking 16-24 clock cycles, which is a bit over 70,000
@@ -107,8 +144,54 @@ type CHIP_8 struct {
 	// Number of bytes per scan line. This is 8 in low mode and 16 when high.
 	Pitch int
 
+	// Dirty is a bit mask of the scan lines in Video that have changed
+	// since the last frame was acknowledged with Refresh. Bit N is set
+	// when scan line N needs to be redrawn.
+	Dirty uint64
+
 	// A mapping of address breakpoints.
 	Breakpoints map[int]Breakpoint
+
+	// Watchpoints are addresses of memory that break when the program
+	// accesses them in any of the ways given.
+	Watchpoints map[uint]Access
+
+	// watched is the watchpoint hit by the instruction executing, if any.
+	watched *Watchpoint
+
+	// Symbols are the names of labeled addresses, if the program was
+	// assembled from source.
+	Symbols map[uint]string
+
+	// Aliases are the names of V registers declared with VAR, if the
+	// program was assembled from source.
+	Aliases map[uint]string
+
+	// Profiler is the profile instructions executed are added to, if any.
+	Profiler *Profile
+
+	// Coverage tracks how memory is accessed, if any.
+	Coverage *Coverage
+
+	// Trace keeps the most recent instructions executed, if any.
+	Trace *Trace
+
+	// Quirks are the interpreter behaviors emulated.
+	Quirks Quirks
+
+	// Mirror is a copy of the program with different quirks, run in
+	// lockstep and compared after every instruction, if any.
+	Mirror *CHIP_8
+
+	// Diverged is true once the mirror no longer matches.
+	Diverged bool
+
+	// Predecode executes instructions from a cache of predecoded blocks
+	// instead of decoding every instruction as it is executed.
+	Predecode bool
+
+	// cache holds the predecoded instructions, if any.
+	cache *decodeCache
 }
 
 // Breakpoint is an implementation of error.
@@ -124,6 +207,9 @@ type Breakpoint struct {
 
 	// Once is true if the breakpoint should be removed once hit.
 	Once bool
+
+	// Condition, if set, must be non-zero for the breakpoint to trip.
+	Condition *Expr
 }
 
 // Error implements the error interface for a Breakpoint.
@@ -135,6 +221,46 @@ func (b Breakpoint) Error() string {
 	}
 }
 
+// Returns true if an error is a breakpoint or watchpoint being hit.
+func isBreak(err error) bool {
+	switch err.(type) {
+	case Breakpoint, Watchpoint:
+		return true
+	}
+
+	return false
+}
+
+// Watchpoint is an implementation of error, returned after an instruction
+// accesses watched memory.
+type Watchpoint struct {
+	// Address is the memory address accessed.
+	Address uint
+
+	// Access is how the memory was accessed.
+	Access Access
+}
+
+// Error implements the error interface for a Watchpoint.
+func (w Watchpoint) Error() string {
+	if w.Access == ACCESS_WRITE {
+		return fmt.Sprintf("hit watchpoint @ %04X: written", w.Address)
+	}
+
+	return fmt.Sprintf("hit watchpoint @ %04X: read", w.Address)
+}
+
+var (
+	// StackOverflow is returned by a CALL with a full stack.
+	StackOverflow = errors.New("Stack overflow!")
+
+	// StackUnderflow is returned by a RET with an empty stack.
+	StackUnderflow = errors.New("Stack underflow!")
+
+	// DivideByZero is returned by a DIV with VY equal to zero.
+	DivideByZero = errors.New("Divide by zero!")
+)
+
 // SysCall is an implementation of error.
 type SysCall struct {
 	// Address is the memory location where the CDP1802 instructions
@@ -144,7 +270,7 @@ type SysCall struct {
 
 // Error implements the error interface for a SysCall.
 func (call SysCall) Error() string {
-	return fmt.Sprintf("unimplmented syscall to #%04X", call.Address)
+	return fmt.Sprintf("unimplemented syscall to #%04X", call.Address)
 }
 
 // Load a ROM from a byte array and return a new CHIP-8 virtual machine.
@@ -167,6 +293,8 @@ func LoadROM(program []byte, eti bool) (*CHIP_8, error) {
 		Breakpoints: make(map[int]Breakpoint),
 		Base:        uint(base),
 		Speed:       700,
+		Seed:        time.Now().UnixNano(),
+		Predecode:   true,
 	}
 
 	// copy the RCA 1802 512 byte ROM into the CHIP-8 followed by the program
@@ -189,14 +317,27 @@ func LoadAssembly(asm *Assembly, eti bool) (*CHIP_8, error) {
 			vm.SetBreakpoint(b)
 		}
 
+		// name addresses with the labels
+		vm.Symbols = asm.Symbols()
+		vm.Aliases = asm.Aliases()
+
 		return vm, nil
 	}
 }
 
 // Load a ROM file and return a new CHIP-8 virtual machine.
 func LoadFile(file string, eti bool) (*CHIP_8, error) {
+	vm, _, err := LoadProgram(file, eti)
+
+	return vm, err
+}
+
+// LoadProgram loads a ROM file or assembles a source file (Octo source if
+// it ends in .8o) and returns a new CHIP-8 virtual machine, along with the
+// assembly if the file was source (nil if it was a binary ROM).
+func LoadProgram(file string, eti bool) (*CHIP_8, *Assembly, error) {
 	if program, err := ioutil.ReadFile(file); err != nil {
-		return nil, err
+		return nil, nil, err
 	} else {
 		for _, c := range string(program) {
 			if unicode.IsSpace(c) || unicode.IsGraphic(c) {
@@ -204,14 +345,25 @@ func LoadFile(file string, eti bool) (*CHIP_8, error) {
 			}
 
 			// file is a binary rom, load that
-			return LoadROM(program, eti)
+			vm, err := LoadROM(program, eti)
+
+			return vm, nil, err
+		}
+
+		assemble := Assemble
+
+		// octo source has its own syntax
+		if strings.EqualFold(filepath.Ext(file), ".8o") {
+			assemble = AssembleOcto
 		}
 
 		// a text file that needs assembled
-		if asm, err := Assemble(program, eti); err != nil {
-			return nil, err
+		if asm, err := assemble(program, eti); err != nil {
+			return nil, nil, err
 		} else {
-			return LoadAssembly(asm, eti)
+			vm, err := LoadAssembly(asm, eti)
+
+			return vm, asm, err
 		}
 	}
 }
@@ -228,12 +380,34 @@ func (vm *CHIP_8) SaveROM(file string, includeInterpreter bool) error {
 	return ioutil.WriteFile(file, bytes, 666)
 }
 
+// Write the ROM to disk as source that assembles back into it. The source
+// is Octo if the file ends in .8o.
+func (vm *CHIP_8) SaveSource(file string) error {
+	opts := DisassemblyOptions{Extensions: AllExtensions}
+
+	if strings.EqualFold(filepath.Ext(file), ".8o") {
+		opts.Syntax = SYNTAX_OCTO
+	}
+
+	source := Disassemble(vm.ROM[vm.Base:vm.Base+uint(vm.Size)], vm.Base, opts)
+
+	return ioutil.WriteFile(file, source, 0666)
+}
+
 // Reset the CHIP-8 virtual machine memory.
 func (vm *CHIP_8) Reset() {
+	if vm.Recording != nil {
+		vm.Recording.record(vm, EVENT_RESET, 0)
+	}
+
 	copy(vm.Memory[:], vm.ROM[:])
 
-	// reset video memory
+	// nothing has been predecoded
+	vm.cache = nil
+
+	// reset video memory and redraw everything
 	vm.Video = [0x440]byte{}
+	vm.Dirty = ^uint64(0)
 
 	// reset keys
 	vm.Keys = [16]bool{}
@@ -253,15 +427,41 @@ func (vm *CHIP_8) Reset() {
 	vm.DT = 0
 	vm.ST = 0
 
+	// reset the audio pattern and pitch
+	vm.Pattern = [16]byte{}
+	vm.Patterned = false
+	vm.Tone = 64
+
 	// reset the clock and cycles executed
 	vm.Clock = time.Now().UnixNano()
 	vm.Cycles = 0
 
+	// reset emulated time and instructions executed
+	vm.Time = 0
+	vm.Steps = 0
+
+	// restart the same sequence of random numbers
+	vm.rng = rand.New(rand.NewSource(vm.Seed))
+
 	// not waiting for a key
 	vm.W = nil
 
 	// not in high-res mode
 	vm.Pitch = 8
+
+	// restart the mirror with the same seed and speed
+	if vm.Mirror != nil {
+		vm.Mirror.Seed = vm.Seed
+		vm.Mirror.Speed = vm.Speed
+		vm.Mirror.Reset()
+	}
+
+	vm.Diverged = false
+
+	// the call stack is gone
+	if vm.Profiler != nil {
+		vm.Profiler.reset()
+	}
 }
 
 // HighRes returns true if the CHIP-8 is in high resolution mode.
@@ -269,14 +469,21 @@ func (vm *CHIP_8) HighRes() bool {
 	return vm.Pitch > 8
 }
 
+// Refresh returns the mask of scan lines that have changed since the
+// last time it was called, acknowledging them.
+func (vm *CHIP_8) Refresh() uint64 {
+	dirty := vm.Dirty
+
+	// the frame has been acknowledged
+	vm.Dirty = 0
+
+	return dirty
+}
+
 // IncSpeed increases CHIP-8 virtual machine performance.
 func (vm *CHIP_8) IncSpeed() int {
 	if vm.Speed < 15000 {
-		vm.Speed += 200
-
-		// reset the clock
-		vm.Clock = time.Now().UnixNano()
-		vm.Cycles = 0
+		vm.SetSpeed(vm.Speed + 200)
 	}
 
 	return int(vm.Speed * 100 / 700)
@@ -285,16 +492,30 @@ func (vm *CHIP_8) IncSpeed() int {
 // DecSpeed lowers CHIP-8 virtual machine performance.
 func (vm *CHIP_8) DecSpeed() int {
 	if vm.Speed > 100 {
-		vm.Speed -= 200
-
-		// reset the clock
-		vm.Clock = time.Now().UnixNano()
-		vm.Cycles = 0
+		vm.SetSpeed(vm.Speed - 200)
 	}
 
 	return int(vm.Speed * 100 / 700)
 }
 
+// SetSpeed changes how many instructions execute per second.
+func (vm *CHIP_8) SetSpeed(speed int64) {
+	vm.Speed = speed
+
+	// reset the clock
+	vm.Clock = time.Now().UnixNano()
+	vm.Cycles = 0
+
+	// the change is an input to record
+	if vm.Recording != nil {
+		vm.Recording.record(vm, EVENT_SPEED, speed)
+	}
+
+	if vm.Mirror != nil {
+		vm.Mirror.SetSpeed(speed)
+	}
+}
+
 // SetBreakpoint at a ROM address to the CHIP-8 virtual machine.
 func (vm *CHIP_8) SetBreakpoint(b Breakpoint) {
 	if b.Address >= 0x200 && b.Address < len(vm.ROM) {
@@ -305,12 +526,14 @@ func (vm *CHIP_8) SetBreakpoint(b Breakpoint) {
 // StepOverBreakpoint creates a one-time breakpoint on the next instruction
 // if the current instruction is a CALL.
 func (vm *CHIP_8) StepOverBreakpoint() bool {
-	if vm.Memory[vm.PC]&0xF0 != 0x20 {
+	pc := vm.PC & 0xFFF
+
+	if vm.Memory[pc]&0xF0 != 0x20 {
 		return false
 	}
 
 	// set create a one-time breakpoint at the next instruction
-	address := int(vm.PC) + 2
+	address := int(pc+2) & 0xFFF
 
 	// only if one isn't already there
 	if _, ok := vm.Breakpoints[address]; !ok {
@@ -347,9 +570,32 @@ func (vm *CHIP_8) ClearBreakpoints() {
 	vm.Breakpoints = make(map[int]Breakpoint)
 }
 
+// SetWatchpoint breaks after the program accesses memory at an address in
+// any of the ways given.
+func (vm *CHIP_8) SetWatchpoint(address uint, access Access) {
+	if vm.Watchpoints == nil {
+		vm.Watchpoints = make(map[uint]Access)
+	}
+
+	vm.Watchpoints[address&0xFFF] |= access
+}
+
+// RemoveWatchpoint stops watching memory at an address in the ways given.
+func (vm *CHIP_8) RemoveWatchpoint(address uint, access Access) {
+	address &= 0xFFF
+
+	if vm.Watchpoints[address] &^= access; vm.Watchpoints[address] == 0 {
+		delete(vm.Watchpoints, address)
+	}
+}
+
 // PressKey emulates a CHIP-8 key being pressed.
 func (vm *CHIP_8) PressKey(key uint) {
 	if key < 16 {
+		if vm.Recording != nil {
+			vm.Recording.record(vm, EVENT_PRESS, int64(key))
+		}
+
 		vm.Keys[key] = true
 
 		// if waiting for a key, set it now
@@ -359,22 +605,32 @@ func (vm *CHIP_8) PressKey(key uint) {
 			// clear wait flag
 			vm.W = nil
 		}
+
+		if vm.Mirror != nil {
+			vm.Mirror.PressKey(key)
+		}
 	}
 }
 
 // ReleaseKey emulates a CHIP-8 key being released.
 func (vm *CHIP_8) ReleaseKey(key uint) {
 	if key < 16 {
+		if vm.Recording != nil {
+			vm.Recording.record(vm, EVENT_RELEASE, int64(key))
+		}
+
 		vm.Keys[key] = false
+
+		if vm.Mirror != nil {
+			vm.Mirror.ReleaseKey(key)
+		}
 	}
 }
 
 // Converts a CHIP-8 delay timer register to a byte.
 func (vm *CHIP_8) GetDelayTimer() byte {
-	now := time.Now().UnixNano()
-
-	if now < vm.DT {
-		return uint8((vm.DT - now) * 60 / 1000000000)
+	if vm.Time < vm.DT {
+		return uint8((vm.DT - vm.Time) * 60 / 1000000000)
 	}
 
 	return 0
@@ -382,15 +638,39 @@ func (vm *CHIP_8) GetDelayTimer() byte {
 
 // Converts the CHIP-8 sound timer register to a byte.
 func (vm *CHIP_8) GetSoundTimer() byte {
-	now := time.Now().UnixNano()
-
-	if now < vm.ST {
-		return uint8((vm.ST - now) * 60 / 1000000000)
+	if vm.Time < vm.ST {
+		return uint8((vm.ST - vm.Time) * 60 / 1000000000)
 	}
 
 	return 0
 }
 
+// SetDelayTimer sets the delay timer register, as if by LD DT, VX.
+func (vm *CHIP_8) SetDelayTimer(b byte) {
+	vm.DT = vm.Time + int64(b)*1000000000/60
+}
+
+// SetSoundTimer sets the sound timer register, as if by LD ST, VX.
+func (vm *CHIP_8) SetSoundTimer(b byte) {
+	vm.ST = vm.Time + int64(b)*1000000000/60
+}
+
+// Buzzing returns true while the sound timer is counting down.
+func (vm *CHIP_8) Buzzing() bool {
+	return vm.Time < vm.ST
+}
+
+// Frame returns the number of 60 Hz frames of emulated time since reset.
+func (vm *CHIP_8) Frame() int64 {
+	return vm.Time * 60 / 1000000000
+}
+
+// PatternRate returns the number of audio pattern samples (bits) played
+// per second, which is 4000*2^((pitch-64)/48).
+func (vm *CHIP_8) PatternRate() float64 {
+	return 4000 * math.Pow(2, (float64(vm.Tone)-64)/48)
+}
+
 // GetResolution returns the width and height of the CHIP-8.
 func (vm *CHIP_8) GetResolution() (int, int) {
 	return vm.Pitch << 3, vm.Pitch << 2
@@ -403,18 +683,96 @@ func (vm *CHIP_8) Process(paused bool) error {
 	// calculate how many cycles should have been executed
 	count := (now - vm.Clock) * vm.Speed / 1000000000
 
+	// movies supply their own inputs and idle time
+	if vm.Playback != nil {
+		return vm.Playback.process(vm, count, paused)
+	}
+
 	// if paused, count cycles without stepping
 	if paused {
-		vm.Cycles = count
-	} else {
-		for vm.Cycles < count {
-			if err := vm.Step(); err != nil {
-				return err
+		vm.idle(count - vm.Cycles)
+		return nil
+	}
+
+	return vm.process(count)
+}
+
+// Execute instructions until the cycle count is reached.
+func (vm *CHIP_8) process(count int64) error {
+	for vm.Cycles < count {
+		if err := vm.run(count - vm.Cycles); err != nil {
+			return err
+		}
+
+		// if waiting for a key, catch up
+		if vm.W != nil {
+			vm.idle(count - vm.Cycles)
+		}
+	}
+
+	return nil
+}
+
+// Turbo executes instructions as fast as possible for a duration of wall
+// time instead of pacing them to the clock. Emulated time (and the timers)
+// still advance with each instruction. Afterwards, the clock is moved so
+// Process carries on from where turbo left off.
+func (vm *CHIP_8) Turbo(d time.Duration) error {
+	var err error
+
+	// run a frame of instructions at a time until out of time
+	for end := time.Now().Add(d); err == nil && time.Now().Before(end); {
+		count := vm.Cycles + vm.Speed/60
+
+		if vm.Playback != nil {
+			err = vm.Playback.process(vm, count, false)
+		} else {
+			err = vm.process(count)
+		}
+	}
+
+	// don't wait for the clock to catch up
+	vm.Clock = time.Now().UnixNano() - vm.Cycles*1000000000/vm.Speed
+
+	return err
+}
+
+// RunFrames executes instructions as fast as possible, without any wall
+// time pacing, until n frames (1/60 s) of emulated time have passed.
+// Breakpoints and watchpoints are ignored. While waiting for a key, time
+// still passes. If a movie is playing, it supplies the inputs.
+func (vm *CHIP_8) RunFrames(n int64) error {
+	end := vm.Time + n*1000000000/60
+
+	for vm.Time < end {
+		// movies supply their own inputs and idle time
+		if vm.Playback != nil {
+			if err := vm.Playback.advance(vm); err != nil {
+				if err == EndOfMovie {
+					vm.Playback = nil
+					return err
+				}
+
+				if !isBreak(err) {
+					return err
+				}
 			}
 
-			// if waiting for a key, catch up
-			if vm.W != nil {
-				vm.Cycles = count
+			continue
+		}
+
+		if vm.W != nil {
+			vm.idle((end-vm.Time)*vm.Speed/1000000000 + 1)
+			break
+		}
+
+		// instructions left until the end
+		ns := 1000000000 / vm.Speed
+		left := (end - vm.Time + ns - 1) / ns
+
+		if err := vm.run(left); err != nil {
+			if !isBreak(err) {
+				return err
 			}
 		}
 	}
@@ -422,15 +780,70 @@ func (vm *CHIP_8) Process(paused bool) error {
 	return nil
 }
 
+// Execute up to a number of instructions when using predecoded blocks,
+// or a single instruction when not.
+func (vm *CHIP_8) run(limit int64) error {
+	if vm.Predecode {
+		return vm.runBlock(limit)
+	}
+
+	return vm.Step()
+}
+
+// Let cycles pass without executing any instructions. Emulated time
+// still advances so the timers count down.
+func (vm *CHIP_8) idle(cycles int64) {
+	if cycles <= 0 {
+		return
+	}
+
+	// how much emulated time passed
+	ns := cycles * 1000000000 / vm.Speed
+
+	vm.Cycles += cycles
+	vm.Time += ns
+
+	// the passing of time is an input to record
+	if vm.Recording != nil {
+		vm.Recording.record(vm, EVENT_IDLE, ns)
+	}
+
+	if vm.Mirror != nil {
+		vm.Mirror.idle(cycles)
+	}
+}
+
 // Step the CHIP-8 virtual machine a single instruction.
 func (vm *CHIP_8) Step() error {
 	if vm.W != nil {
 		return nil
 	}
 
-	// fetch the next instruction
-	inst := vm.fetch()
+	// address of the instruction
+	pc := vm.PC
+
+	// execute the next instruction, predecoded or not
+	var err error
+
+	if vm.Predecode {
+		err = vm.executeCached()
+	} else {
+		err = vm.execute(vm.fetch())
+	}
+
+	// stay on a failed instruction, forgetting any memory it watched
+	if err != nil {
+		vm.PC = pc
+		vm.watched = nil
+		return err
+	}
+
+	return vm.retire(pc)
+}
 
+// Decode and execute a single instruction. The PC has already been
+// advanced past it.
+func (vm *CHIP_8) execute(inst uint) error {
 	// 12-bit address operand
 	a := inst & 0xFFF
 
@@ -446,7 +859,7 @@ func (vm *CHIP_8) Step() error {
 	if inst == 0x00E0 {
 		vm.cls()
 	} else if inst == 0x00EE {
-		vm.ret()
+		return vm.ret()
 	} else if inst == 0x00FB {
 		vm.scrollRight()
 	} else if inst == 0x00FC {
@@ -462,11 +875,11 @@ func (vm *CHIP_8) Step() error {
 	} else if inst&0xFFF0 == 0x00C0 {
 		vm.scrollDown(n)
 	} else if inst&0xF000 == 0x0000 {
-		vm.sys(a)
+		return vm.sys(a)
 	} else if inst&0xF000 == 0x1000 {
 		vm.jump(a)
 	} else if inst&0xF000 == 0x2000 {
-		vm.call(a)
+		return vm.call(a)
 	} else if inst&0xF000 == 0x3000 {
 		vm.skipIf(x, b)
 	} else if inst&0xF000 == 0x4000 {
@@ -494,17 +907,17 @@ func (vm *CHIP_8) Step() error {
 	} else if inst&0xF00F == 0x8005 {
 		vm.subXY(x, y)
 	} else if inst&0xF00F == 0x8006 {
-		vm.shr(x)
+		vm.shr(x, y)
 	} else if inst&0xF00F == 0x8007 {
 		vm.subYX(x, y)
 	} else if inst&0xF00F == 0x800E {
-		vm.shl(x)
+		vm.shl(x, y)
 	} else if inst&0xF00F == 0x9000 {
 		vm.skipIfNotXY(x, y)
 	} else if inst&0xF00F == 0x9001 {
 		vm.mulXY(x, y)
 	} else if inst&0xF00F == 0x9002 {
-		vm.divXY(x, y)
+		return vm.divXY(x, y)
 	} else if inst&0xF0FF == 0xF033 {
 		vm.bcd(x)
 	} else if inst&0xF00F == 0x9003 {
@@ -531,12 +944,16 @@ func (vm *CHIP_8) Step() error {
 		vm.loadDTX(x)
 	} else if inst&0xF0FF == 0xF018 {
 		vm.loadSTX(x)
+	} else if inst == 0xF002 {
+		vm.loadPattern()
 	} else if inst&0xF0FF == 0xF01E {
 		vm.addIX(x)
 	} else if inst&0xF0FF == 0xF029 {
 		vm.loadF(x)
 	} else if inst&0xF0FF == 0xF030 {
 		vm.loadHF(x)
+	} else if inst&0xF0FF == 0xF03A {
+		vm.loadTone(x)
 	} else if inst&0xF0FF == 0xF055 {
 		vm.saveRegs(x)
 	} else if inst&0xF0FF == 0xF065 {
@@ -551,14 +968,78 @@ func (vm *CHIP_8) Step() error {
 		return fmt.Errorf("Invalid opcode: %04X", inst)
 	}
 
-	// increment the cycle count
+	return nil
+}
+
+// Account for an executed instruction at an address, run the mirror, and
+// check for breakpoints.
+func (vm *CHIP_8) retire(pc uint) error {
+	// increment the cycle count and advance emulated time
 	vm.Cycles += 1
+	vm.Steps += 1
+	vm.Time += 1000000000 / vm.Speed
+
+	// the instruction was executed
+	if vm.Coverage != nil {
+		vm.Coverage.mark(pc&0xFFF, ACCESS_EXEC)
+		vm.Coverage.mark((pc+1)&0xFFF, ACCESS_EXEC)
+	}
+
+	// count the instruction in the profile
+	if vm.Profiler != nil {
+		vm.Profiler.sample(vm, pc)
+	}
+
+	// remember it was executed
+	if vm.Trace != nil {
+		vm.Trace.record(pc & 0xFFF)
+	}
+
+	// run the mirror in lockstep, noting if it diverges
+	var diverged error
+
+	if vm.Mirror != nil {
+		diverged = vm.stepMirror(pc)
+	}
+
+	// check watchpoints and breakpoints even when diverged, so that
+	// one-time breakpoints are always removed
+	var err error
+
+	if vm.watched != nil || len(vm.Breakpoints) > 0 {
+		err = vm.stopped()
+	}
+
+	if diverged != nil {
+		return diverged
+	}
+
+	return err
+}
+
+// Returns the watchpoint accessed by the instruction just executed, or the
+// breakpoint at the PC, if any. One-time breakpoints are removed.
+func (vm *CHIP_8) stopped() error {
+	if vm.watched != nil {
+		w := *vm.watched
+
+		vm.watched = nil
+
+		return w
+	}
 
 	// if at a breakpoint, return it
-	if b, ok := vm.Breakpoints[int(vm.PC)]; ok {
-		if !b.Conditional || vm.V[0xF] != 0 {
+	if len(vm.Breakpoints) == 0 {
+		return nil
+	}
+
+	// the PC can be past the end of memory, where fetching wraps
+	address := int(vm.PC & 0xFFF)
+
+	if b, ok := vm.Breakpoints[address]; ok {
+		if (!b.Conditional || vm.V[0xF] != 0) && (b.Condition == nil || b.Condition.Eval(vm) != 0) {
 			if b.Once {
-				delete(vm.Breakpoints, int(vm.PC))
+				delete(vm.Breakpoints, address)
 			}
 
 			return b
@@ -568,12 +1049,14 @@ func (vm *CHIP_8) Step() error {
 	return nil
 }
 
-// StepOut executes instructions until a RET instruction is executed.
+// StepOut executes instructions until a RET instruction is executed. It
+// gives up after a second of emulated time or when waiting for a key, so
+// a subroutine that never returns can't hang the debugger.
 func (vm *CHIP_8) StepOut() error {
 	sp := vm.SP
 
 	// if not in a subroutine, don't do anything
-	for sp > 0 && vm.SP >= sp {
+	for n := int64(0); sp > 0 && vm.SP >= sp && vm.W == nil && n < vm.Speed; n++ {
 		if err := vm.Step(); err != nil {
 			return err
 		}
@@ -584,13 +1067,13 @@ func (vm *CHIP_8) StepOut() error {
 
 // Fetch the next 16-bit instruction to execute.
 func (vm *CHIP_8) fetch() uint {
-	i := vm.PC
+	i := vm.PC & 0xFFF
 
 	// advance the program counter
-	vm.PC += 2
+	vm.PC = i + 2
 
-	// return the 16-bit instruction
-	return uint(vm.Memory[i])<<8 | uint(vm.Memory[i+1])
+	// return the 16-bit instruction, wrapping around memory
+	return uint(vm.Memory[i])<<8 | uint(vm.Memory[(i+1)&0xFFF])
 }
 
 // Clear the video display memory.
@@ -598,17 +1081,20 @@ func (vm *CHIP_8) cls() {
 	for i := range vm.Video {
 		vm.Video[i] = 0
 	}
+
+	// every scan line was wiped
+	vm.Dirty = ^uint64(0)
 }
 
 // System call an RCA 1802 program at an address.
-func (vm *CHIP_8) sys(address uint) {
-	panic("SYS calls are unimplemented")
+func (vm *CHIP_8) sys(address uint) error {
+	return SysCall{Address: address}
 }
 
 // Call a subroutine at address.
-func (vm *CHIP_8) call(address uint) {
+func (vm *CHIP_8) call(address uint) error {
 	if int(vm.SP) >= len(vm.Stack) {
-		panic("Stack overflow!")
+		return StackOverflow
 	}
 
 	// post increment
@@ -617,17 +1103,21 @@ func (vm *CHIP_8) call(address uint) {
 
 	// jump to address
 	vm.PC = address
+
+	return nil
 }
 
 // Return from subroutine.
-func (vm *CHIP_8) ret() {
+func (vm *CHIP_8) ret() error {
 	if vm.SP == 0 {
-		panic("Stack underflow!")
+		return StackUnderflow
 	}
 
 	// pre-decrement
 	vm.SP -= 1
 	vm.PC = vm.Stack[vm.SP]
+
+	return nil
 }
 
 // Exit the interpreter.
@@ -638,11 +1128,13 @@ func (vm *CHIP_8) exit() {
 // Set low res mode.
 func (vm *CHIP_8) low() {
 	vm.Pitch = 8
+	vm.Dirty = ^uint64(0)
 }
 
 // Set high res mode.
 func (vm *CHIP_8) high() {
 	vm.Pitch = 16
+	vm.Dirty = ^uint64(0)
 }
 
 // Scroll n pixels up.
@@ -658,6 +1150,9 @@ func (vm *CHIP_8) scrollUp(n byte) {
 	for i := 0x400 - int(n)*vm.Pitch; i < 0x400; i++ {
 		vm.Video[i] = 0
 	}
+
+	// every scan line moved
+	vm.Dirty = ^uint64(0)
 }
 
 // Scroll n pixels down.
@@ -673,6 +1168,9 @@ func (vm *CHIP_8) scrollDown(n byte) {
 	for i := 0; i < int(n)*vm.Pitch; i++ {
 		vm.Video[i] = 0
 	}
+
+	// every scan line moved
+	vm.Dirty = ^uint64(0)
 }
 
 // Scroll pixels right.
@@ -687,6 +1185,9 @@ func (vm *CHIP_8) scrollRight() {
 			vm.Video[i] |= vm.Video[i-1] << (8 - shift)
 		}
 	}
+
+	// every scan line moved
+	vm.Dirty = ^uint64(0)
 }
 
 // Scroll pixels left.
@@ -701,6 +1202,9 @@ func (vm *CHIP_8) scrollLeft() {
 			vm.Video[i] |= vm.Video[i+1] >> (8 - shift)
 		}
 	}
+
+	// every scan line moved
+	vm.Dirty = ^uint64(0)
 }
 
 // Jump to address.
@@ -708,9 +1212,13 @@ func (vm *CHIP_8) jump(address uint) {
 	vm.PC = address
 }
 
-// Jump to address + v0.
+// Jump to address + v0, or address + vx with the JumpVX quirk.
 func (vm *CHIP_8) jumpV0(address uint) {
-	vm.PC = address + uint(vm.V[0])
+	if vm.Quirks.JumpVX {
+		vm.PC = address + uint(vm.V[address>>8&0xF])
+	} else {
+		vm.PC = address + uint(vm.V[0])
+	}
 }
 
 // Skip next instruction if vx == n.
@@ -757,14 +1265,14 @@ func (vm *CHIP_8) skipIfLess(x, y uint) {
 
 // Skip next instruction if key(vx) is pressed.
 func (vm *CHIP_8) skipIfPressed(x uint) {
-	if vm.Keys[vm.V[x]] {
+	if vm.Keys[vm.V[x]&0xF] {
 		vm.PC += 2
 	}
 }
 
 // Skip next instruction if key(vx) is not pressed.
 func (vm *CHIP_8) skipIfNotPressed(x uint) {
-	if !vm.Keys[vm.V[x]] {
+	if !vm.Keys[vm.V[x]&0xF] {
 		vm.PC += 2
 	}
 }
@@ -786,12 +1294,12 @@ func (vm *CHIP_8) loadXDT(x uint) {
 
 // Load vx into delay timer.
 func (vm *CHIP_8) loadDTX(x uint) {
-	vm.DT = time.Now().UnixNano() + int64(vm.V[x])*1000000000/60
+	vm.DT = vm.Time + int64(vm.V[x])*1000000000/60
 }
 
 // Load vx into sound timer.
 func (vm *CHIP_8) loadSTX(x uint) {
-	vm.ST = time.Now().UnixNano() + int64(vm.V[x])*1000000000/60
+	vm.ST = vm.Time + int64(vm.V[x])*1000000000/60
 }
 
 // Load vx with next key hit (blocking).
@@ -799,6 +1307,21 @@ func (vm *CHIP_8) loadXK(x uint) {
 	vm.W = &vm.V[x]
 }
 
+// Load the audio pattern buffer from 16 bytes at I.
+func (vm *CHIP_8) loadPattern() {
+	for i := range vm.Pattern {
+		vm.Pattern[i] = vm.read(vm.I + uint(i))
+	}
+
+	// the buzzer plays the pattern from now on
+	vm.Patterned = true
+}
+
+// Load vx into the audio pitch register.
+func (vm *CHIP_8) loadTone(x uint) {
+	vm.Tone = vm.V[x]
+}
+
 // Load address register.
 func (vm *CHIP_8) loadI(address uint) {
 	vm.I = address
@@ -826,9 +1349,9 @@ func (vm *CHIP_8) bcd(x uint) {
 	}
 
 	// write to memory
-	vm.Memory[vm.I+0] = byte(b>>8) & 0xF
-	vm.Memory[vm.I+1] = byte(b>>4) & 0xF
-	vm.Memory[vm.I+2] = byte(b>>0) & 0xF
+	vm.write(vm.I+0, byte(b>>8)&0xF)
+	vm.write(vm.I+1, byte(b>>4)&0xF)
+	vm.write(vm.I+2, byte(b>>0)&0xF)
 }
 
 // Load address with 16-bit, BCD of vx, vy.
@@ -859,11 +1382,11 @@ func (vm *CHIP_8) bcd16(x, y uint) {
 	}
 
 	// write to memory
-	vm.Memory[vm.I+0] = byte(b>>16) & 0xF
-	vm.Memory[vm.I+1] = byte(b>>12) & 0xF
-	vm.Memory[vm.I+2] = byte(b>>8) & 0xF
-	vm.Memory[vm.I+3] = byte(b>>4) & 0xF
-	vm.Memory[vm.I+4] = byte(b>>0) & 0xF
+	vm.write(vm.I+0, byte(b>>16)&0xF)
+	vm.write(vm.I+1, byte(b>>12)&0xF)
+	vm.write(vm.I+2, byte(b>>8)&0xF)
+	vm.write(vm.I+3, byte(b>>4)&0xF)
+	vm.write(vm.I+4, byte(b>>0)&0xF)
 }
 
 // Load font sprite for vx into I.
@@ -878,18 +1401,18 @@ func (vm *CHIP_8) loadHF(x uint) {
 
 // Load ASCII font sprite for vx into I and length into v0.
 func (vm *CHIP_8) loadASCII(x uint) {
-	c := 0x100 + int(vm.V[x])*3
+	c := 0x100 + uint(vm.V[x])*3
 
 	// AB CD EF are the bytes in memory, but are unpacked as
 	// EF CD AB where E is the length and F-B are the rows
-	ab, cd, ef := vm.Memory[c], vm.Memory[c+1], vm.Memory[c+2]
+	ab, cd, ef := vm.read(c), vm.read(c+1), vm.read(c+2)
 
 	// write the byte patters of each nibble to character memory
-	vm.Memory[0x1C0] = vm.Memory[0xF0+(ef&0xF)]
-	vm.Memory[0x1C1] = vm.Memory[0xF0+(cd>>4)]
-	vm.Memory[0x1C2] = vm.Memory[0xF0+(cd&0xF)]
-	vm.Memory[0x1C3] = vm.Memory[0xF0+(ab>>4)]
-	vm.Memory[0x1C4] = vm.Memory[0xF0+(ab&0xF)]
+	vm.write(0x1C0, vm.read(0xF0+uint(ef&0xF)))
+	vm.write(0x1C1, vm.read(0xF0+uint(cd>>4)))
+	vm.write(0x1C2, vm.read(0xF0+uint(cd&0xF)))
+	vm.write(0x1C3, vm.read(0xF0+uint(ab>>4)))
+	vm.write(0x1C4, vm.read(0xF0+uint(ab&0xF)))
 
 	// set the length to v0
 	vm.V[0] = ef >> 4
@@ -901,28 +1424,56 @@ func (vm *CHIP_8) loadASCII(x uint) {
 // Bitwise or vx with vy into vx.
 func (vm *CHIP_8) or(x, y uint) {
 	vm.V[x] |= vm.V[y]
+
+	if vm.Quirks.ResetVF {
+		vm.V[0xF] = 0
+	}
 }
 
 // Bitwise and vx with vy into vx.
 func (vm *CHIP_8) and(x, y uint) {
 	vm.V[x] &= vm.V[y]
+
+	if vm.Quirks.ResetVF {
+		vm.V[0xF] = 0
+	}
 }
 
 // Bitwise xor vx with vy into vx.
 func (vm *CHIP_8) xor(x, y uint) {
 	vm.V[x] ^= vm.V[y]
+
+	if vm.Quirks.ResetVF {
+		vm.V[0xF] = 0
+	}
 }
 
-// Bitwise shift vx 1 bit, set carry to MSB of vx before shift.
-func (vm *CHIP_8) shl(x uint) {
-	vm.V[0xF] = vm.V[x] >> 7
-	vm.V[x] <<= 1
+// Bitwise shift vx 1 bit, set carry to MSB of vx before shift. With the
+// ShiftVY quirk, vy is shifted into vx instead.
+func (vm *CHIP_8) shl(x, y uint) {
+	if vm.Quirks.ShiftVY {
+		v := vm.V[y]
+
+		vm.V[x] = v << 1
+		vm.V[0xF] = v >> 7
+	} else {
+		vm.V[0xF] = vm.V[x] >> 7
+		vm.V[x] <<= 1
+	}
 }
 
-// Bitwise shift vx 1 bit, set carry to LSB of vx before shift.
-func (vm *CHIP_8) shr(x uint) {
-	vm.V[0xF] = vm.V[x] & 1
-	vm.V[x] >>= 1
+// Bitwise shift vx 1 bit, set carry to LSB of vx before shift. With the
+// ShiftVY quirk, vy is shifted into vx instead.
+func (vm *CHIP_8) shr(x, y uint) {
+	if vm.Quirks.ShiftVY {
+		v := vm.V[y]
+
+		vm.V[x] = v >> 1
+		vm.V[0xF] = v & 1
+	} else {
+		vm.V[0xF] = vm.V[x] & 1
+		vm.V[x] >>= 1
+	}
 }
 
 // Add n to vx.
@@ -983,13 +1534,19 @@ func (vm *CHIP_8) mulXY(x, y uint) {
 }
 
 // Divide vx by vy; vf is set to the remainder.
-func (vm *CHIP_8) divXY(x, y uint) {
+func (vm *CHIP_8) divXY(x, y uint) error {
+	if vm.V[y] == 0 {
+		return DivideByZero
+	}
+
 	vm.V[x], vm.V[0xF] = vm.V[x]/vm.V[y], vm.V[x]%vm.V[y]
+
+	return nil
 }
 
 // Load a random number & n into vx.
 func (vm *CHIP_8) loadRandom(x uint, b byte) {
-	vm.V[x] = byte(rand.Intn(256) & int(b))
+	vm.V[x] = byte(vm.rng.Intn(256) & int(b))
 }
 
 // Draw a sprite in memory to video at x,y with a height of n.
@@ -1003,8 +1560,10 @@ func (vm *CHIP_8) draw(a uint, x, y int8, n byte) byte {
 	// which scan line will it render on
 	pos := int(y) * vm.Pitch
 
-	// draw each row of the sprite
-	for _, s := range vm.Memory[a : a+uint(n)] {
+	// draw each row of the sprite, wrapping around memory
+	for r := uint(0); r < uint(n); r++ {
+		s := vm.read(a + r)
+
 		if pos >= 0 {
 			n := uint(pos) + b
 
@@ -1028,6 +1587,9 @@ func (vm *CHIP_8) draw(a uint, x, y int8, n byte) byte {
 			// were any pixels turned off?
 			c |= b0 & ^vm.Video[n]
 			c |= b1 & ^vm.Video[n+1]
+
+			// the scan line needs to be redrawn
+			vm.Dirty |= 1 << uint(pos/vm.Pitch)
 		}
 
 		// next scan line
@@ -1073,28 +1635,40 @@ func (vm *CHIP_8) drawSpriteEx(x, y uint) {
 func (vm *CHIP_8) saveRegs(x uint) {
 	for i := uint(0); i <= x; i++ {
 		if vm.I+i < 0x1000 {
-			vm.Memory[vm.I+i] = vm.V[i]
+			vm.write(vm.I+i, vm.V[i])
 		}
 	}
+
+	if vm.Quirks.LoadStoreI {
+		vm.I += x + 1
+	}
 }
 
 // Load registers v0..vx from I.
 func (vm *CHIP_8) loadRegs(x uint) {
 	for i := uint(0); i <= x; i++ {
 		if vm.I+i < 0x1000 {
-			vm.V[i] = vm.Memory[vm.I+i]
+			vm.V[i] = vm.read(vm.I + i)
 		} else {
 			vm.V[i] = 0
 		}
 	}
+
+	if vm.Quirks.LoadStoreI {
+		vm.I += x + 1
+	}
 }
 
-// Store v0..v7 in the HP-RPL user flags.
+// Store v0..v7 in the HP-RPL user flags. There are only 8 flags.
 func (vm *CHIP_8) storeR(x uint) {
 	copy(vm.R[:], vm.V[:x+1])
 }
 
-// Read the HP-RPL user flags into v0..v7.
+// Read the HP-RPL user flags into v0..v7. There are only 8 flags.
 func (vm *CHIP_8) readR(x uint) {
+	if x > 7 {
+		x = 7
+	}
+
 	copy(vm.V[:], vm.R[:x+1])
 }
@@ -0,0 +1,270 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+// Package conformance runs CHIP-8 test ROMs headlessly for a fixed number
+// of frames and compares the final screen to golden images.
+package conformance
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+)
+
+// Test is a ROM run for a number of frames whose final screen is known.
+type Test struct {
+	// Name of the test, which is also the name of its golden image.
+	Name string
+
+	// ROM is the path of the ROM or C8 file, relative to the test data.
+	ROM string
+
+	// Frames is how many frames (1/60 s) the ROM runs for.
+	Frames int64
+
+	// Quirks are the interpreter behaviors to emulate.
+	Quirks chip8.Quirks
+
+	// Keys are pressed for a single frame each while the ROM runs.
+	Keys []Key
+
+	// Optional tests are skipped when the ROM isn't present. This is
+	// for third party test suites that aren't checked in.
+	Optional bool
+}
+
+// Key is a CHIP-8 key pressed at a frame.
+type Key struct {
+	// Frame is when the key is pressed.
+	Frame int64
+
+	// Key is the key (0-F) pressed.
+	Key uint
+}
+
+// Status is the outcome of running a test.
+type Status int
+
+// Test outcomes.
+const (
+	STATUS_PASS Status = iota
+	STATUS_FAIL
+	STATUS_SKIP
+)
+
+// Result is the outcome of running a test.
+type Result struct {
+	// Test is the test that was run.
+	Test Test
+
+	// Status is whether the test passed, failed, or was skipped.
+	Status Status
+
+	// Screen is the final screen of the ROM.
+	Screen string
+
+	// Diff shows the pixels that differed from the golden image.
+	Diff string
+
+	// Reason explains a failure or why the test was skipped.
+	Reason string
+}
+
+// String returns PASS, FAIL, or SKIP.
+func (s Status) String() string {
+	return []string{"PASS", "FAIL", "SKIP"}[s]
+}
+
+// Run a test, with the ROM and golden image found in the test data
+// directory. If update is true, the golden image is written instead of
+// compared.
+func Run(dir string, t Test, update bool) Result {
+	r := Result{Test: t}
+
+	// third party ROMs may not be there
+	if _, err := os.Stat(filepath.Join(dir, t.ROM)); err != nil && t.Optional {
+		r.Status, r.Reason = STATUS_SKIP, t.ROM+" not found"
+		return r
+	}
+
+	vm, err := Execute(filepath.Join(dir, t.ROM), t)
+	if err != nil {
+		r.Status, r.Reason = STATUS_FAIL, err.Error()
+		return r
+	}
+
+	r.Screen = Screen(vm)
+
+	// golden images are named after the test
+	golden := filepath.Join(dir, "golden", t.Name+".txt")
+
+	if update {
+		if err = ioutil.WriteFile(golden, []byte(r.Screen), 0666); err != nil {
+			r.Status, r.Reason = STATUS_FAIL, err.Error()
+		}
+
+		return r
+	}
+
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		r.Status, r.Reason = STATUS_FAIL, "no golden image"
+		return r
+	}
+
+	// compare the screens
+	if diff, n := Diff(string(want), r.Screen); n > 0 {
+		r.Status, r.Diff = STATUS_FAIL, diff
+		r.Reason = fmt.Sprintf("%d pixels differ", n)
+	}
+
+	return r
+}
+
+// Execute loads a ROM and runs it for the frames of a test. The random
+// number seed is always 0, so every run is the same.
+func Execute(file string, t Test) (*chip8.CHIP_8, error) {
+	vm, err := chip8.LoadFile(file, false)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.Quirks = t.Quirks
+	vm.Seed = 0
+
+	// restart with the seed and quirks
+	vm.Reset()
+
+	for frame := int64(0); frame < t.Frames; frame++ {
+		var pressed []uint
+
+		// press keys for this frame only
+		for _, k := range t.Keys {
+			if k.Frame == frame {
+				vm.PressKey(k.Key)
+				pressed = append(pressed, k.Key)
+			}
+		}
+
+		if err = vm.RunFrames(1); err != nil {
+			return nil, fmt.Errorf("frame %d - %s", frame, err)
+		}
+
+		for _, k := range pressed {
+			vm.ReleaseKey(k)
+		}
+	}
+
+	return vm, nil
+}
+
+// Screen returns the video memory as lines of text, where lit pixels are
+// '#' and unlit pixels are '.'.
+func Screen(vm *chip8.CHIP_8) string {
+	var s strings.Builder
+
+	w, h := vm.GetResolution()
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := y*w + x
+
+			if vm.Video[p>>3]&(0x80>>uint(p&7)) != 0 {
+				s.WriteByte('#')
+			} else {
+				s.WriteByte('.')
+			}
+		}
+
+		s.WriteByte('\n')
+	}
+
+	return s.String()
+}
+
+// Diff compares two screens and returns the number of pixels that differ
+// along with the screen marked with them. Pixels lit but expected unlit
+// are '+' and pixels unlit but expected lit are '-'.
+func Diff(want, got string) (string, int) {
+	var s strings.Builder
+
+	wantLines := strings.Split(strings.TrimSuffix(want, "\n"), "\n")
+	gotLines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	// a different resolution is entirely different
+	if len(wantLines) != len(gotLines) || len(wantLines[0]) != len(gotLines[0]) {
+		return fmt.Sprintf("resolution %dx%d, expected %dx%d\n", len(gotLines[0]), len(gotLines), len(wantLines[0]), len(wantLines)), len(gotLines) * len(gotLines[0])
+	}
+
+	n := 0
+
+	for y, line := range gotLines {
+		for x := 0; x < len(line); x++ {
+			c := line[x]
+
+			if c != wantLines[y][x] {
+				if n++; c == '#' {
+					c = '+'
+				} else {
+					c = '-'
+				}
+			}
+
+			s.WriteByte(c)
+		}
+
+		s.WriteByte('\n')
+	}
+
+	return s.String(), n
+}
+
+// Report returns the pass/fail report of results, with the difference
+// of every failed test.
+func Report(results []Result) string {
+	var s strings.Builder
+
+	count := [3]int{}
+
+	for _, r := range results {
+		count[r.Status]++
+
+		// one line per test
+		if r.Reason != "" {
+			fmt.Fprintf(&s, "%s %s (%s)\n", r.Status, r.Test.Name, r.Reason)
+		} else {
+			fmt.Fprintf(&s, "%s %s\n", r.Status, r.Test.Name)
+		}
+
+		// show what didn't match
+		if r.Diff != "" {
+			s.WriteString(r.Diff)
+		}
+	}
+
+	fmt.Fprintf(&s, "%d passed, %d failed, %d skipped\n", count[STATUS_PASS], count[STATUS_FAIL], count[STATUS_SKIP])
+
+	return s.String()
+}
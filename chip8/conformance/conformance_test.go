@@ -0,0 +1,52 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package conformance
+
+import (
+	"flag"
+	"testing"
+)
+
+// update writes new golden images instead of comparing against them.
+var update = flag.Bool("update", false, "Write golden images.")
+
+// TestConformance runs every test ROM in the suite.
+func TestConformance(t *testing.T) {
+	var results []Result
+
+	for _, test := range Suite {
+		r := Run("testdata", test, *update)
+
+		t.Run(test.Name, func(t *testing.T) {
+			switch r.Status {
+			case STATUS_SKIP:
+				t.Skip(r.Reason)
+			case STATUS_FAIL:
+				t.Errorf("%s\n%s", r.Reason, r.Diff)
+			}
+		})
+
+		results = append(results, r)
+	}
+
+	t.Log("\n" + Report(results))
+}
@@ -0,0 +1,63 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package conformance
+
+import (
+	"github.com/massung/CHIP-8/emulator/chip8"
+)
+
+var (
+	// quirk settings of the platforms tested
+	schip, _  = chip8.ParseQuirks("schip")
+	cosmac, _ = chip8.ParseQuirks("cosmac")
+	chip48, _ = chip8.ParseQuirks("chip48")
+)
+
+// Suite is every conformance test. ROM paths are relative to testdata.
+var Suite = []Test{
+	// test ROMs written for the emulator
+	{Name: "alu", ROM: "roms/alu.c8", Frames: 60},
+	{Name: "draw", ROM: "roms/draw.c8", Frames: 30, Keys: []Key{{Frame: 20, Key: 0xA}}},
+	{Name: "super", ROM: "roms/super.c8", Frames: 30, Quirks: schip},
+	{Name: "quirks-none", ROM: "roms/quirks.c8", Frames: 30},
+	{Name: "quirks-schip", ROM: "roms/quirks.c8", Frames: 30, Quirks: schip},
+	{Name: "quirks-cosmac", ROM: "roms/quirks.c8", Frames: 30, Quirks: cosmac},
+	{Name: "quirks-chip48", ROM: "roms/quirks.c8", Frames: 30, Quirks: chip48},
+
+	// the example programs and games that come with the emulator
+	{Name: "ascii", ROM: "../../../games/sources/ascii.c8", Frames: 60},
+	{Name: "boot", ROM: "../../../games/sources/boot.c8", Frames: 120},
+	{Name: "brix", ROM: "../../../games/roms/BRIX", Frames: 300, Keys: []Key{{Frame: 100, Key: 4}, {Frame: 200, Key: 6}}},
+	{Name: "maze", ROM: "../../../games/roms/MAZE", Frames: 120},
+	{Name: "pong", ROM: "../../../games/roms/PONG", Frames: 300, Keys: []Key{{Frame: 60, Key: 1}}},
+	{Name: "tetris", ROM: "../../../games/roms/TETRIS", Frames: 300, Keys: []Key{{Frame: 120, Key: 5}}},
+	{Name: "ufo", ROM: "../../../games/roms/UFO", Frames: 300, Keys: []Key{{Frame: 90, Key: 5}}},
+
+	// Timendus' CHIP-8 test suite; copy the ROMs to testdata/roms/timendus
+	{Name: "timendus-chip8-logo", ROM: "roms/timendus/1-chip8-logo.ch8", Frames: 60, Optional: true},
+	{Name: "timendus-ibm-logo", ROM: "roms/timendus/2-ibm-logo.ch8", Frames: 60, Optional: true},
+	{Name: "timendus-corax", ROM: "roms/timendus/3-corax+.ch8", Frames: 60, Optional: true},
+	{Name: "timendus-flags", ROM: "roms/timendus/4-flags.ch8", Frames: 60, Optional: true},
+	{Name: "timendus-quirks", ROM: "roms/timendus/5-quirks.ch8", Frames: 600, Optional: true, Keys: []Key{{Frame: 30, Key: 2}, {Frame: 60, Key: 1}}},
+	{Name: "timendus-keypad", ROM: "roms/timendus/6-keypad.ch8", Frames: 120, Optional: true, Keys: []Key{{Frame: 30, Key: 3}, {Frame: 60, Key: 5}}},
+	{Name: "timendus-scrolling", ROM: "roms/timendus/8-scrolling.ch8", Frames: 120, Optional: true, Keys: []Key{{Frame: 30, Key: 1}}},
+}
@@ -0,0 +1,199 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Access is a set of flags for the ways a byte of memory was used.
+type Access byte
+
+// Ways memory can be accessed.
+const (
+	ACCESS_EXEC Access = 1 << iota
+	ACCESS_READ
+	ACCESS_WRITE
+)
+
+// Coverage tracks which bytes of memory were executed as instructions,
+// read as sprites or data, and written.
+type Coverage struct {
+	// Access is every way each byte of memory has been used.
+	Access [0x1000]Access
+
+	// Hits is how many times each byte of memory has been used.
+	Hits [0x1000]uint32
+}
+
+// StartCoverage creates new coverage that all memory accesses are
+// tracked in until coverage is stopped.
+func (vm *CHIP_8) StartCoverage() *Coverage {
+	vm.Coverage = &Coverage{}
+
+	return vm.Coverage
+}
+
+// StopCoverage returns the coverage being tracked and stops tracking it.
+func (vm *CHIP_8) StopCoverage() *Coverage {
+	c := vm.Coverage
+
+	vm.Coverage = nil
+
+	return c
+}
+
+// Read a byte from memory, wrapping around, and track the access.
+func (vm *CHIP_8) read(address uint) byte {
+	address &= 0xFFF
+
+	vm.access(address, ACCESS_READ)
+
+	return vm.Memory[address]
+}
+
+// Track an access to memory by the program for coverage and watchpoints.
+func (vm *CHIP_8) access(address uint, access Access) {
+	if vm.Coverage != nil {
+		vm.Coverage.mark(address, access)
+	}
+
+	// stop after the instruction if watched
+	if len(vm.Watchpoints) > 0 {
+		if watch, ok := vm.Watchpoints[address]; ok && watch&access != 0 {
+			vm.watched = &Watchpoint{Address: address, Access: access}
+		}
+	}
+}
+
+// Add an access to a byte of memory.
+func (c *Coverage) mark(address uint, access Access) {
+	c.Access[address] |= access
+
+	// don't wrap around to zero hits
+	if c.Hits[address] < ^uint32(0) {
+		c.Hits[address] += 1
+	}
+}
+
+// String returns the access flags as a string, e.g. "XR-".
+func (a Access) String() string {
+	flags := []byte("---")
+
+	if a&ACCESS_EXEC != 0 {
+		flags[0] = 'X'
+	}
+
+	if a&ACCESS_READ != 0 {
+		flags[1] = 'R'
+	}
+
+	if a&ACCESS_WRITE != 0 {
+		flags[2] = 'W'
+	}
+
+	return string(flags)
+}
+
+// Count returns how many bytes in a range of memory have any of a set
+// of accesses, or no access at all if access is 0.
+func (c *Coverage) Count(start, end uint, access Access) int {
+	n := 0
+
+	for a := start; a < end && a < uint(len(c.Access)); a++ {
+		if (access == 0 && c.Access[a] == 0) || c.Access[a]&access != 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Listing writes the program in the memory of a virtual machine annotated
+// with coverage.
+// Each executed instruction is disassembled with how many times it ran,
+// and the bytes that weren't executed are listed as data, marked with
+// how they were accessed. Bytes marked "---" were never used and may be
+// dead code.
+func (c *Coverage) Listing(w io.Writer, vm *CHIP_8) error {
+	var b bytes.Buffer
+
+	start, end := vm.Base, vm.Base+uint(vm.Size)
+
+	// summary of the whole ROM
+	fmt.Fprintf(&b, "; %d bytes: %d executed, %d read, %d written, %d unused\n",
+		vm.Size,
+		c.Count(start, end, ACCESS_EXEC),
+		c.Count(start, end, ACCESS_READ),
+		c.Count(start, end, ACCESS_WRITE),
+		c.Count(start, end, 0))
+
+	for a := start; a < end; {
+		if s, ok := vm.Symbols[a]; ok {
+			fmt.Fprintf(&b, "\n%s:\n", s)
+		}
+
+		// executed instructions are disassembled
+		if c.Access[a]&ACCESS_EXEC != 0 {
+			inst := vm.Disassemble(a)
+
+			if i := strings.Index(inst, " - "); i >= 0 {
+				inst = inst[i+3:]
+			} else {
+				inst = "-"
+			}
+
+			fmt.Fprintf(&b, "%s  #%04X  %02X%02X  %-24s ; %d\n", c.Access[a], a, vm.Memory[a], vm.Memory[(a+1)&0xFFF], inst, c.Hits[a])
+
+			a += 2
+			continue
+		}
+
+		// data is listed in rows of bytes with the same access
+		access := c.Access[a]
+		row := make([]string, 0, 8)
+
+		for n := a; n < end && len(row) < 8; n++ {
+			if c.Access[n] != access {
+				break
+			}
+
+			// labels start a new row
+			if _, ok := vm.Symbols[n]; ok && n > a {
+				break
+			}
+
+			row = append(row, fmt.Sprintf("%02X", vm.Memory[n]))
+		}
+
+		fmt.Fprintf(&b, "%s  #%04X  %s\n", access, a, strings.Join(row, " "))
+
+		a += uint(len(row))
+	}
+
+	_, err := w.Write(b.Bytes())
+
+	return err
+}
@@ -0,0 +1,109 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bytes"
+	"testing"
+)
+
+// A program that executes, reads, and writes memory, and has code and
+// data that are never used.
+const covered = `
+            ld          i, sprite
+            drw         v0, v0, 2
+            ld          i, buffer
+            ld          [i], v0
+            ld          v0, [i]
+done        jp          done
+dead        cls
+sprite      byte        #F0
+            byte        #90
+            byte        #FF
+buffer      byte        0
+`
+
+// TestCoverage checks the accesses tracked for each byte of a program.
+func TestCoverage(t *testing.T) {
+	vm := loadSource(t, covered)
+	c := vm.StartCoverage()
+
+	// run the loop at the end three times
+	steps(t, vm, 8)
+
+	want := []struct {
+		address uint
+		access  string
+		hits    uint32
+	}{
+		{0x200, "X--", 1},
+		{0x202, "X--", 1},
+		{0x208, "X--", 1},
+		{0x20A, "X--", 3},
+		{0x20C, "---", 0},
+		{0x20E, "-R-", 1},
+		{0x20F, "-R-", 1},
+		{0x210, "---", 0},
+		{0x211, "-RW", 2},
+	}
+
+	for _, w := range want {
+		if s := c.Access[w.address].String(); s != w.access || c.Hits[w.address] != w.hits {
+			t.Errorf("#%04X: expected %s %d, got %s %d", w.address, w.access, w.hits, s, c.Hits[w.address])
+		}
+	}
+
+	if n := c.Count(0x200, 0x212, 0); n != 3 {
+		t.Errorf("expected 3 unused bytes, got %d", n)
+	}
+
+	var b bytes.Buffer
+
+	if err := c.Listing(&b, vm); err != nil {
+		t.Fatal(err)
+	}
+
+	listing := `; 18 bytes: 12 executed, 3 read, 1 written, 3 unused
+X--  #0200  A20E  LD     I, SPRITE         ; 1
+X--  #0202  D002  DRW    V0, V0, 2         ; 1
+X--  #0204  A211  LD     I, BUFFER         ; 1
+X--  #0206  F055  LD     [I], V0           ; 1
+X--  #0208  F065  LD     V0, [I]           ; 1
+
+DONE:
+X--  #020A  120A  JP     DONE              ; 3
+
+DEAD:
+---  #020C  00 E0
+
+SPRITE:
+-R-  #020E  F0 90
+---  #0210  FF
+
+BUFFER:
+-RW  #0211  00
+`
+
+	if b.String() != listing {
+		t.Errorf("expected listing:\n%s\ngot:\n%s", listing, b.String())
+	}
+}
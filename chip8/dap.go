@@ -0,0 +1,650 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Variable references of the scopes shown for every stack frame.
+const (
+	DAP_SCOPE_REGISTERS = 1 + iota
+	DAP_SCOPE_MEMORY
+)
+
+// dapThread is the ID of the only thread there is.
+const dapThread = 1
+
+// dapRequest is a request sent by a debug adapter client.
+type dapRequest struct {
+	Seq       int             `json:"seq"`
+	Type      string          `json:"type"`
+	Command   string          `json:"command"`
+	Arguments json.RawMessage `json:"arguments"`
+}
+
+// dapResponse is the response to a request.
+type dapResponse struct {
+	Seq        int         `json:"seq"`
+	Type       string      `json:"type"`
+	RequestSeq int         `json:"request_seq"`
+	Command    string      `json:"command"`
+	Success    bool        `json:"success"`
+	Message    string      `json:"message,omitempty"`
+	Body       interface{} `json:"body,omitempty"`
+}
+
+// dapEvent is an event sent to the client.
+type dapEvent struct {
+	Seq   int         `json:"seq"`
+	Type  string      `json:"type"`
+	Event string      `json:"event"`
+	Body  interface{} `json:"body,omitempty"`
+}
+
+// dapSource is a source file.
+type dapSource struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+}
+
+// dapSession is a single connection from a debug adapter client.
+type dapSession struct {
+	// runner owns the virtual machine being debugged.
+	runner *Runner
+
+	// launch loads a program into the runner.
+	launch func(program string) (*Assembly, error)
+
+	// conn is the connection to the client.
+	conn net.Conn
+
+	// lock guards writing messages and everything below.
+	lock sync.Mutex
+
+	// seq is the sequence number of the last message sent.
+	seq int
+
+	// program is the file launched, and asm its assembly, if it was
+	// source.
+	program string
+	asm     *Assembly
+
+	// stopOnEntry is true if the program should pause before running.
+	stopOnEntry bool
+
+	// breakpoints are the addresses of those set by the client, so they
+	// can be replaced and removed when it disconnects.
+	breakpoints map[int]bool
+
+	// running is true once the client resumes the virtual machine and
+	// until it is seen to stop again.
+	running bool
+
+	// stepping is true if the client is stepping instead of continuing.
+	stepping bool
+
+	// done is closed once the client disconnects.
+	done chan struct{}
+}
+
+// ServeDAP accepts connections from editors speaking the Debug Adapter
+// Protocol and lets them debug programs, one connection at a time. The
+// launch function loads the program requested into the runner, and
+// returns its assembly if it was source, which is used to map lines to
+// addresses. Returns when the listener is closed.
+func ServeDAP(l net.Listener, r *Runner, launch func(program string) (*Assembly, error)) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		newDAPSession(r, conn, launch).serve()
+	}
+}
+
+// Create a session for a client connection.
+func newDAPSession(r *Runner, conn net.Conn, launch func(program string) (*Assembly, error)) *dapSession {
+	return &dapSession{
+		runner:      r,
+		launch:      launch,
+		conn:        conn,
+		breakpoints: make(map[int]bool),
+		done:        make(chan struct{}),
+	}
+}
+
+// Handle requests until the client disconnects.
+func (s *dapSession) serve() {
+	defer s.conn.Close()
+
+	go s.watch()
+
+	in := textproto.NewReader(bufio.NewReader(s.conn))
+
+	for {
+		req, err := readDAPRequest(in)
+		if err != nil {
+			break
+		}
+
+		if req.Type != "request" {
+			continue
+		}
+
+		body, err := s.handle(req)
+
+		s.respond(req, body, err)
+
+		// the client is done with the session
+		if req.Command == "disconnect" || req.Command == "terminate" {
+			break
+		}
+
+		// run the virtual machine and send events after responding
+		if err == nil {
+			s.after(req.Command)
+		}
+	}
+
+	close(s.done)
+
+	s.detach()
+}
+
+// Read a single request, which has a header with the length of the JSON
+// content after it.
+func readDAPRequest(in *textproto.Reader) (*dapRequest, error) {
+	header, err := in.ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := strconv.Atoi(header.Get("Content-Length"))
+	if err != nil {
+		return nil, err
+	}
+
+	content := make([]byte, n)
+
+	if _, err := io.ReadFull(in.R, content); err != nil {
+		return nil, err
+	}
+
+	req := &dapRequest{}
+
+	return req, json.Unmarshal(content, req)
+}
+
+// Write a message to the client with the next sequence number. The lock
+// must be held.
+func (s *dapSession) write(seq *int, msg interface{}) {
+	s.seq += 1
+	*seq = s.seq
+
+	if content, err := json.Marshal(msg); err == nil {
+		fmt.Fprintf(s.conn, "Content-Length: %d\r\n\r\n%s", len(content), content)
+	}
+}
+
+// Send the response to a request.
+func (s *dapSession) respond(req *dapRequest, body interface{}, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	resp := &dapResponse{
+		Type:       "response",
+		RequestSeq: req.Seq,
+		Command:    req.Command,
+		Success:    err == nil,
+		Body:       body,
+	}
+
+	if err != nil {
+		resp.Message = err.Error()
+	}
+
+	s.write(&resp.Seq, resp)
+}
+
+// Send an event.
+func (s *dapSession) send(event string, body interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	e := &dapEvent{
+		Type:  "event",
+		Event: event,
+		Body:  body,
+	}
+
+	s.write(&e.Seq, e)
+}
+
+// Remove everything the client set and resume the virtual machine.
+func (s *dapSession) detach() {
+	s.runner.Do(func(vm *CHIP_8) {
+		for address := range s.breakpoints {
+			vm.RemoveBreakpoint(address)
+		}
+	})
+
+	s.runner.Pause(false)
+}
+
+// Handle a request and return the body of the response.
+func (s *dapSession) handle(req *dapRequest) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		return map[string]interface{}{
+			"supportsConfigurationDoneRequest": true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, s.launchProgram(req.Arguments)
+	case "setBreakpoints":
+		return s.setBreakpoints(req.Arguments)
+	case "setExceptionBreakpoints":
+		return map[string]interface{}{}, nil
+	case "configurationDone":
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{
+			"threads": []interface{}{
+				map[string]interface{}{"id": dapThread, "name": "CHIP-8"},
+			},
+		}, nil
+	case "stackTrace":
+		return s.stackTrace(), nil
+	case "scopes":
+		return s.scopes(), nil
+	case "variables":
+		return s.variables(req.Arguments)
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true}, nil
+	case "next", "stepIn", "stepOut", "pause", "disconnect", "terminate":
+		return nil, nil
+	}
+
+	return nil, fmt.Errorf("%s is not supported", req.Command)
+}
+
+// Act on a request once it has been responded to.
+func (s *dapSession) after(command string) {
+	switch command {
+	case "launch":
+		s.send("initialized", nil)
+	case "configurationDone":
+		s.start()
+	case "continue":
+		s.resume(false, func() { s.runner.Pause(false) })
+	case "next":
+		s.resume(true, s.runner.StepOver)
+	case "stepIn":
+		s.resume(true, s.runner.Step)
+	case "stepOut":
+		s.resume(true, s.runner.StepOut)
+	case "pause":
+		s.pause()
+	}
+}
+
+// Load the program to debug and pause before its first instruction.
+func (s *dapSession) launchProgram(args json.RawMessage) error {
+	var launch struct {
+		Program     string `json:"program"`
+		StopOnEntry bool   `json:"stopOnEntry"`
+	}
+
+	if err := json.Unmarshal(args, &launch); err != nil {
+		return err
+	}
+
+	if launch.Program == "" {
+		return fmt.Errorf("no program to launch")
+	}
+
+	asm, err := s.launch(launch.Program)
+	if err != nil {
+		return err
+	}
+
+	// run from a clean state once configured
+	s.runner.Reset(true)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.program, s.asm = launch.Program, asm
+	s.stopOnEntry = launch.StopOnEntry
+	s.breakpoints = make(map[int]bool)
+
+	return nil
+}
+
+// Start running the program once configured, unless it should stop on
+// entry.
+func (s *dapSession) start() {
+	s.lock.Lock()
+	stopOnEntry := s.stopOnEntry
+	s.lock.Unlock()
+
+	if stopOnEntry {
+		s.stopped("entry", "")
+	} else {
+		s.resume(false, func() { s.runner.Pause(false) })
+	}
+}
+
+// Replace the breakpoints set in a source file, mapping each line to the
+// first address assembled from it (or the lines after it).
+func (s *dapSession) setBreakpoints(args json.RawMessage) (interface{}, error) {
+	var set struct {
+		Source      dapSource `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+
+	if err := json.Unmarshal(args, &set); err != nil {
+		return nil, err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// the breakpoints set on each line
+	breakpoints := make([]interface{}, 0, len(set.Breakpoints))
+	addresses := make(map[int]bool)
+
+	for i, b := range set.Breakpoints {
+		bp := map[string]interface{}{"id": i + 1, "verified": false, "line": b.Line}
+
+		switch {
+		case s.asm == nil:
+			bp["message"] = "The program was not assembled from source"
+		case !samePath(set.Source.Path, s.program):
+			bp["message"] = "The source is not the program launched"
+		default:
+			if address, ok := s.asm.Address(b.Line); !ok {
+				bp["message"] = "No code at or after this line"
+			} else {
+				bp["verified"] = true
+				bp["line"] = s.asm.Lines[address]
+
+				addresses[address] = true
+			}
+		}
+
+		breakpoints = append(breakpoints, bp)
+	}
+
+	reason := filepath.Base(s.program)
+
+	s.runner.Do(func(vm *CHIP_8) {
+		for address := range s.breakpoints {
+			vm.RemoveBreakpoint(address)
+		}
+
+		// don't take over breakpoints assembled into the program
+		for address := range addresses {
+			if _, ok := vm.Breakpoints[address]; ok {
+				delete(addresses, address)
+				continue
+			}
+
+			vm.SetBreakpoint(Breakpoint{
+				Address: address,
+				Reason:  fmt.Sprintf("%s:%d", reason, s.asm.Lines[address]),
+			})
+		}
+	})
+
+	s.breakpoints = addresses
+
+	return map[string]interface{}{"breakpoints": breakpoints}, nil
+}
+
+// True if two paths name the same file.
+func samePath(a, b string) bool {
+	if a, err := filepath.Abs(a); err == nil {
+		if b, err := filepath.Abs(b); err == nil {
+			return a == b
+		}
+	}
+
+	return a == b
+}
+
+// Build the stack trace: the PC followed by the CALL of each subroutine on
+// the stack.
+func (s *dapSession) stackTrace() interface{} {
+	vm := s.runner.Latest()
+	calls := vm.CallStack()
+
+	frames := make([]interface{}, 0, len(calls)+1)
+
+	for i := 0; i <= len(calls); i++ {
+		pc, name := vm.PC, vm.Symbol(vm.Base)
+
+		// the call site of the frame above
+		if i > 0 {
+			pc = calls[i-1].Site
+		}
+
+		// the subroutine the frame is in
+		if i < len(calls) {
+			name = vm.Symbol(calls[i].Callee)
+		}
+
+		frame := map[string]interface{}{
+			"id":                          i,
+			"name":                        name,
+			"line":                        0,
+			"column":                      0,
+			"instructionPointerReference": fmt.Sprintf("0x%X", pc),
+		}
+
+		s.lock.Lock()
+
+		// point the frame at the line of source
+		if s.asm != nil {
+			if line := s.asm.Line(int(pc)); line > 0 {
+				frame["source"] = dapSource{Name: filepath.Base(s.program), Path: s.program}
+				frame["line"] = line
+				frame["column"] = 1
+			}
+		}
+
+		s.lock.Unlock()
+
+		frames = append(frames, frame)
+	}
+
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}
+}
+
+// The scopes of every stack frame are the same: registers and memory.
+func (s *dapSession) scopes() interface{} {
+	return map[string]interface{}{
+		"scopes": []interface{}{
+			map[string]interface{}{
+				"name":               "Registers",
+				"presentationHint":   "registers",
+				"variablesReference": DAP_SCOPE_REGISTERS,
+				"expensive":          false,
+			},
+			map[string]interface{}{
+				"name":               "Memory",
+				"variablesReference": DAP_SCOPE_MEMORY,
+				"expensive":          true,
+			},
+		},
+	}
+}
+
+// List the registers or the rows of memory.
+func (s *dapSession) variables(args json.RawMessage) (interface{}, error) {
+	var list struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+
+	if err := json.Unmarshal(args, &list); err != nil {
+		return nil, err
+	}
+
+	vm := s.runner.Latest()
+	vars := make([]interface{}, 0, 0x100)
+
+	// add a variable to the list
+	add := func(name, value string) {
+		vars = append(vars, map[string]interface{}{
+			"name":               name,
+			"value":              value,
+			"variablesReference": 0,
+		})
+	}
+
+	switch list.VariablesReference {
+	case DAP_SCOPE_REGISTERS:
+		for i, v := range vm.V {
+			add(fmt.Sprintf("V%X", i), fmt.Sprintf("#%02X", v))
+		}
+
+		add("I", fmt.Sprintf("#%04X", vm.I))
+		add("PC", fmt.Sprintf("#%04X", vm.PC))
+		add("SP", fmt.Sprintf("#%02X", vm.SP))
+		add("DT", fmt.Sprintf("#%02X", vm.GetDelayTimer()))
+		add("ST", fmt.Sprintf("#%02X", vm.GetSoundTimer()))
+	case DAP_SCOPE_MEMORY:
+		for address := 0; address < len(vm.Memory); address += 16 {
+			add(fmt.Sprintf("#%04X", address), fmt.Sprintf("% X", vm.Memory[address:address+16]))
+		}
+	default:
+		return nil, fmt.Errorf("unknown variables reference %d", list.VariablesReference)
+	}
+
+	return map[string]interface{}{"variables": vars}, nil
+}
+
+// Resume the virtual machine with a command, noting it is running so it
+// is reported when it stops again. The command is sent first so that the
+// runner is never seen stopped from before it.
+func (s *dapSession) resume(stepping bool, cmd func()) {
+	cmd()
+
+	s.lock.Lock()
+	s.running, s.stepping = true, stepping
+	s.lock.Unlock()
+}
+
+// Pause the virtual machine.
+func (s *dapSession) pause() {
+	s.lock.Lock()
+	s.stepping = false
+	s.lock.Unlock()
+
+	s.runner.Pause(true)
+}
+
+// Watch for the virtual machine to stop after being resumed until the
+// client disconnects.
+func (s *dapSession) watch() {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			s.lock.Lock()
+			running, stepping := s.running, s.stepping
+			s.lock.Unlock()
+
+			if !running {
+				continue
+			}
+
+			if paused, halt := s.runner.Halted(); paused {
+				s.lock.Lock()
+				s.running = false
+				s.lock.Unlock()
+
+				s.stopped(stopReason(halt, stepping))
+			}
+		}
+	}
+}
+
+// Get the reason the virtual machine stopped and a description of it.
+func stopReason(halt error, stepping bool) (string, string) {
+	switch h := halt.(type) {
+	case nil:
+		if stepping {
+			return "step", ""
+		}
+
+		return "pause", ""
+	case Breakpoint:
+		if h.Once {
+			return "step", ""
+		}
+
+		return "breakpoint", h.Error()
+	case Watchpoint:
+		return "data breakpoint", h.Error()
+	}
+
+	// faults and divergences
+	return "exception", halt.Error()
+}
+
+// Tell the client the virtual machine stopped.
+func (s *dapSession) stopped(reason, text string) {
+	body := map[string]interface{}{
+		"reason":            reason,
+		"threadId":          dapThread,
+		"allThreadsStopped": true,
+	}
+
+	if text != "" {
+		body["text"] = text
+
+		s.send("output", map[string]interface{}{
+			"category": "console",
+			"output":   text + "\n",
+		})
+	}
+
+	s.send("stopped", body)
+}
@@ -0,0 +1,305 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// A loop calling a subroutine. Line 5 is the first line of the subroutine.
+const dapProgram = `
+            ld          v0, 0
+loop        call        inc
+            jp          loop
+inc         add         v0, 1
+            ret
+`
+
+// dapClient speaks the Debug Adapter Protocol to a session.
+type dapClient struct {
+	t    *testing.T
+	conn net.Conn
+	seq  int
+
+	// messages are every message received, in order.
+	messages chan map[string]interface{}
+
+	// events are those received while waiting for a response.
+	events []map[string]interface{}
+
+	// done is closed once the session has detached.
+	done chan struct{}
+}
+
+// Connect a client to a new session debugging a runner, which launches
+// programs by assembling source.
+func connectDAP(t *testing.T, r *Runner, source string) *dapClient {
+	client, server := net.Pipe()
+
+	c := &dapClient{
+		t:        t,
+		conn:     client,
+		messages: make(chan map[string]interface{}, 64),
+		done:     make(chan struct{}),
+	}
+
+	launch := func(program string) (*Assembly, error) {
+		asm, err := Assemble([]byte(source), false)
+		if err != nil {
+			return nil, err
+		}
+
+		vm, err := LoadAssembly(asm, false)
+		if err != nil {
+			return nil, err
+		}
+
+		r.Load(vm)
+
+		return asm, nil
+	}
+
+	go func() {
+		defer close(c.done)
+
+		newDAPSession(r, server, launch).serve()
+	}()
+
+	go c.receive()
+
+	return c
+}
+
+// Read messages until the connection is closed.
+func (c *dapClient) receive() {
+	defer close(c.messages)
+
+	in := textproto.NewReader(bufio.NewReader(c.conn))
+
+	for {
+		header, err := in.ReadMIMEHeader()
+		if err != nil {
+			return
+		}
+
+		n, err := strconv.Atoi(header.Get("Content-Length"))
+		if err != nil {
+			return
+		}
+
+		content := make([]byte, n)
+
+		if _, err := io.ReadFull(in.R, content); err != nil {
+			return
+		}
+
+		var msg map[string]interface{}
+
+		if json.Unmarshal(content, &msg) == nil {
+			c.messages <- msg
+		}
+	}
+}
+
+// Disconnect and wait for the session to detach.
+func (c *dapClient) close() {
+	c.conn.Close()
+
+	<-c.done
+}
+
+// Wait for the next message.
+func (c *dapClient) next() map[string]interface{} {
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			c.t.Fatal("disconnected")
+		}
+
+		return msg
+	case <-time.After(5 * time.Second):
+		c.t.Fatal("timed out")
+	}
+
+	return nil
+}
+
+// Send a request and return the body of its response, failing if it
+// wasn't successful.
+func (c *dapClient) request(command string, args interface{}) map[string]interface{} {
+	c.seq += 1
+
+	content, err := json.Marshal(map[string]interface{}{
+		"seq":       c.seq,
+		"type":      "request",
+		"command":   command,
+		"arguments": args,
+	})
+
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	if _, err := fmt.Fprintf(c.conn, "Content-Length: %d\r\n\r\n%s", len(content), content); err != nil {
+		c.t.Fatal(err)
+	}
+
+	for {
+		msg := c.next()
+
+		if msg["type"] == "event" {
+			c.events = append(c.events, msg)
+			continue
+		}
+
+		if msg["request_seq"] != float64(c.seq) || msg["success"] != true {
+			c.t.Fatalf("%s failed: %v", command, msg)
+		}
+
+		body, _ := msg["body"].(map[string]interface{})
+
+		return body
+	}
+}
+
+// Wait for an event and return its body.
+func (c *dapClient) event(name string) map[string]interface{} {
+	for {
+		var msg map[string]interface{}
+
+		if len(c.events) > 0 {
+			msg, c.events = c.events[0], c.events[1:]
+		} else {
+			msg = c.next()
+		}
+
+		if msg["type"] == "event" && msg["event"] == name {
+			body, _ := msg["body"].(map[string]interface{})
+
+			return body
+		}
+	}
+}
+
+// Wait for the virtual machine to stop and fail if it wasn't for the
+// reason expected.
+func (c *dapClient) stopped(reason string) {
+	if body := c.event("stopped"); body["reason"] != reason {
+		c.t.Fatalf("expected to stop for %s, got %v", reason, body)
+	}
+}
+
+// Get the line and instruction pointer of every stack frame.
+func (c *dapClient) stackTrace() []string {
+	body := c.request("stackTrace", map[string]interface{}{"threadId": dapThread})
+	frames := body["stackFrames"].([]interface{})
+
+	trace := make([]string, 0, len(frames))
+
+	for _, f := range frames {
+		frame := f.(map[string]interface{})
+
+		trace = append(trace, fmt.Sprintf("%v@%v", frame["line"], frame["instructionPointerReference"]))
+	}
+
+	return trace
+}
+
+// Fail if the stack frames aren't the ones expected.
+func (c *dapClient) expectStack(want ...string) {
+	if got := c.stackTrace(); fmt.Sprint(got) != fmt.Sprint(want) {
+		c.t.Fatalf("expected stack %v, got %v", want, got)
+	}
+}
+
+// TestDAP runs a scripted session: launching, setting a breakpoint,
+// continuing to it, reading the stack, and stepping out.
+func TestDAP(t *testing.T) {
+	r := NewRunner(loadSource(t, dapProgram))
+	defer r.Stop()
+
+	c := connectDAP(t, r, dapProgram)
+	defer c.close()
+
+	if body := c.request("initialize", map[string]interface{}{"adapterID": "chip-8"}); body["supportsConfigurationDoneRequest"] != true {
+		t.Fatalf("expected configuration done support, got %v", body)
+	}
+
+	c.request("launch", map[string]interface{}{"program": "loop.c8"})
+	c.event("initialized")
+
+	// a breakpoint at the start of the subroutine
+	body := c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]interface{}{"path": "loop.c8"},
+		"breakpoints": []interface{}{map[string]interface{}{"line": 5}},
+	})
+
+	bp := body["breakpoints"].([]interface{})[0].(map[string]interface{})
+
+	if bp["verified"] != true || bp["line"] != float64(5) {
+		t.Fatalf("expected a verified breakpoint on line 5, got %v", bp)
+	}
+
+	c.request("configurationDone", nil)
+	c.stopped("breakpoint")
+
+	// and again the next time through the loop
+	c.request("continue", map[string]interface{}{"threadId": dapThread})
+	c.stopped("breakpoint")
+	c.expectStack("5@0x206", "3@0x202")
+
+	// reading the state doesn't acknowledge changes to the screen
+	r.Do(func(vm *CHIP_8) { vm.Dirty = 1 })
+	c.stackTrace()
+
+	r.lock.Lock()
+	dirty := r.dirty
+	r.lock.Unlock()
+
+	if dirty == 0 {
+		t.Fatal("expected dirty scan lines to remain unacknowledged")
+	}
+
+	// back to the loop after the call
+	c.request("stepOut", map[string]interface{}{"threadId": dapThread})
+	c.stopped("step")
+	c.expectStack("4@0x204")
+
+	c.request("disconnect", nil)
+
+	<-c.done
+
+	// the breakpoint is removed when the client disconnects
+	r.Do(func(vm *CHIP_8) {
+		if len(vm.Breakpoints) != 0 {
+			t.Errorf("expected no breakpoints, got %v", vm.Breakpoints)
+		}
+	})
+}
@@ -21,23 +21,52 @@
 
 package chip8
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
-// Disassemble a CHIP-8 instruction.
-func (vm *CHIP_8) Disassemble(i uint) string {
-	if int(i) >= len(vm.Memory)-1 {
-		return ""
-	}
+// Ways an instruction can change the flow of control.
+const (
+	FLOW_NEXT = iota
+	FLOW_SKIP
+	FLOW_JUMP
+	FLOW_CALL
+	FLOW_TABLE
+	FLOW_STOP
+)
 
-	// fetch the instruction at this location
-	inst := uint(vm.Memory[i])<<8 | uint(vm.Memory[i+1])
+// Instruction sets that an instruction may belong to.
+const (
+	SET_CHIP8 = iota
+	SET_SUPER
+	SET_EXTENDED
+	SET_XOCHIP
+)
 
-	// end of program memory?
-	if inst == 0 {
-		return fmt.Sprintf("%04X -", i)
-	}
+// An instruction decoded for disassembly.
+type instruction struct {
+	// mnemonic is empty for a zero word and "??" if unknown.
+	mnemonic string
+	operands []string
+
+	// address is the index of the operand that is an address, or -1.
+	address int
+	target  uint
+
+	// flow is how the instruction continues execution.
+	flow int
+
+	// set is the instruction set the instruction belongs to.
+	set int
 
-	// 12-bit literal Address
+	// lossy is true if the assembler can't encode the instruction.
+	lossy bool
+}
+
+// Decode a CHIP-8 instruction for disassembly.
+func disassemble(inst uint) instruction {
+	// 12-bit literal address
 	a := inst & 0xFFF
 
 	// byte and nibble literals
@@ -48,111 +77,331 @@ func (vm *CHIP_8) Disassemble(i uint) string {
 	x := inst >> 8 & 0xF
 	y := inst >> 4 & 0xF
 
+	// constructors for each kind of instruction
+	op := func(m string, operands ...string) instruction {
+		return instruction{mnemonic: m, operands: operands, address: -1}
+	}
+	ref := func(m string, flow int, operands ...string) instruction {
+		i := op(m, append(operands, fmt.Sprintf("#%04X", a))...)
+
+		i.address = len(i.operands) - 1
+		i.target = a
+		i.flow = flow
+
+		return i
+	}
+	in := func(set int, i instruction) instruction {
+		i.set = set
+		return i
+	}
+
+	// operand formats
+	vx := fmt.Sprintf("V%X", x)
+	vy := fmt.Sprintf("V%X", y)
+	nn := fmt.Sprintf("#%02X", b)
+
 	// instruction decoding
-	if inst == 0x00E0 {
-		return fmt.Sprintf("%04X - CLS", i)
+	if inst == 0 {
+		return instruction{address: -1, flow: FLOW_STOP}
+	} else if inst == 0x00E0 {
+		return op("CLS")
 	} else if inst == 0x00EE {
-		return fmt.Sprintf("%04X - RET", i)
+		return instruction{mnemonic: "RET", address: -1, flow: FLOW_STOP}
 	} else if inst == 0x00FE {
-		return fmt.Sprintf("%04X - LOW", i)
+		return in(SET_SUPER, op("LOW"))
 	} else if inst == 0x00FF {
-		return fmt.Sprintf("%04X - HIGH", i)
+		return in(SET_SUPER, op("HIGH"))
 	} else if inst == 0x00FB {
-		return fmt.Sprintf("%04X - SCR", i)
+		return in(SET_SUPER, op("SCR"))
 	} else if inst == 0x00FC {
-		return fmt.Sprintf("%04X - SCL", i)
+		return in(SET_SUPER, op("SCL"))
 	} else if inst == 0x00FD {
-		return fmt.Sprintf("%04X - EXIT", i)
+		return instruction{mnemonic: "EXIT", address: -1, flow: FLOW_STOP, set: SET_SUPER}
 	} else if inst&0xFFF0 == 0x00B0 {
-		return fmt.Sprintf("%04X - SCU    %d", i, n)
+		return in(SET_SUPER, op("SCU", fmt.Sprint(n)))
 	} else if inst&0xFFF0 == 0x00C0 {
-		return fmt.Sprintf("%04X - SCD    %d", i, n)
+		return in(SET_SUPER, op("SCD", fmt.Sprint(n)))
 	} else if inst&0xF000 == 0x0000 {
-		return fmt.Sprintf("%04X - SYS    #%04X", i, a)
+		return ref("SYS", FLOW_NEXT)
 	} else if inst&0xF000 == 0x1000 {
-		return fmt.Sprintf("%04X - JP     #%04X", i, a)
+		return ref("JP", FLOW_JUMP)
 	} else if inst&0xF000 == 0x2000 {
-		return fmt.Sprintf("%04X - CALL   #%04X", i, a)
+		return ref("CALL", FLOW_CALL)
 	} else if inst&0xF000 == 0x3000 {
-		return fmt.Sprintf("%04X - SE     V%X, #%02X", i, x, b)
+		return skip(op("SE", vx, nn))
 	} else if inst&0xF000 == 0x4000 {
-		return fmt.Sprintf("%04X - SNE    V%X, #%02X", i, x, b)
+		return skip(op("SNE", vx, nn))
 	} else if inst&0xF00F == 0x5000 {
-		return fmt.Sprintf("%04X - SE     V%X, V%X", i, x, y)
+		return skip(op("SE", vx, vy))
 	} else if inst&0xF00F == 0x5001 {
-		return fmt.Sprintf("%04X - SGT    V%X, V%X", i, x, y)
+		return skip(in(SET_EXTENDED, op("SGT", vx, vy)))
 	} else if inst&0xF00F == 0x5002 {
-		return fmt.Sprintf("%04X - SLT    V%X, V%X", i, x, y)
+		return skip(in(SET_EXTENDED, op("SLT", vx, vy)))
 	} else if inst&0xF000 == 0x6000 {
-		return fmt.Sprintf("%04X - LD     V%X, #%02X", i, x, b)
+		return op("LD", vx, nn)
 	} else if inst&0xF000 == 0x7000 {
-		return fmt.Sprintf("%04X - ADD    V%X, #%02X", i, x, b)
+		return op("ADD", vx, nn)
 	} else if inst&0xF00F == 0x8000 {
-		return fmt.Sprintf("%04X - LD     V%X, V%X", i, x, y)
+		return op("LD", vx, vy)
 	} else if inst&0xF00F == 0x8001 {
-		return fmt.Sprintf("%04X - OR     V%X, V%X", i, x, y)
+		return op("OR", vx, vy)
 	} else if inst&0xF00F == 0x8002 {
-		return fmt.Sprintf("%04X - AND    V%X, V%X", i, x, y)
+		return op("AND", vx, vy)
 	} else if inst&0xF00F == 0x8003 {
-		return fmt.Sprintf("%04X - XOR    V%X, V%X", i, x, y)
+		return op("XOR", vx, vy)
 	} else if inst&0xF00F == 0x8004 {
-		return fmt.Sprintf("%04X - ADD    V%X, V%X", i, x, y)
+		return op("ADD", vx, vy)
 	} else if inst&0xF00F == 0x8005 {
-		return fmt.Sprintf("%04X - SUB    V%X, V%X", i, x, y)
+		return op("SUB", vx, vy)
 	} else if inst&0xF00F == 0x8006 {
-		return fmt.Sprintf("%04X - SHR    V%X", i, x)
+		return shift(op("SHR", vx), x, y)
 	} else if inst&0xF00F == 0x8007 {
-		return fmt.Sprintf("%04X - SUBN   V%X, V%X", i, x, y)
+		return op("SUBN", vx, vy)
 	} else if inst&0xF00F == 0x800E {
-		return fmt.Sprintf("%04X - SHL    V%X", i, x)
+		return shift(op("SHL", vx), x, y)
 	} else if inst&0xF00F == 0x9000 {
-		return fmt.Sprintf("%04X - SNE    V%X, V%X", i, x, y)
+		return skip(op("SNE", vx, vy))
 	} else if inst&0xF00F == 0x9001 {
-		return fmt.Sprintf("%04X - MUL    V%X, V%X", i, x, y)
+		return in(SET_EXTENDED, op("MUL", vx, vy))
 	} else if inst&0xF00F == 0x9002 {
-		return fmt.Sprintf("%04X - DIV    V%X, V%X", i, x, y)
+		return in(SET_EXTENDED, op("DIV", vx, vy))
 	} else if inst&0xF00F == 0x9003 {
-		return fmt.Sprintf("%04X - BCD    V%X, V%X", i, x, y)
+		return in(SET_EXTENDED, op("BCD", vx, vy))
 	} else if inst&0xF000 == 0xA000 {
-		return fmt.Sprintf("%04X - LD     I, #%04X", i, a)
+		return ref("LD", FLOW_NEXT, "I")
 	} else if inst&0xF000 == 0xB000 {
-		return fmt.Sprintf("%04X - JP     V0, #%04X", i, a)
+		return ref("JP", FLOW_TABLE, "V0")
 	} else if inst&0xF000 == 0xC000 {
-		return fmt.Sprintf("%04X - RND    V%X, #%02X", i, x, b)
+		return op("RND", vx, nn)
 	} else if inst&0xF000 == 0xD000 {
-		return fmt.Sprintf("%04X - DRW    V%X, V%X, %d", i, x, y, n)
+		return op("DRW", vx, vy, fmt.Sprint(n))
 	} else if inst&0xF0FF == 0xE09E {
-		return fmt.Sprintf("%04X - SKP    V%X", i, x)
+		return skip(op("SKP", vx))
 	} else if inst&0xF0FF == 0xE0A1 {
-		return fmt.Sprintf("%04X - SKNP   V%X", i, x)
+		return skip(op("SKNP", vx))
 	} else if inst&0xF0FF == 0xF007 {
-		return fmt.Sprintf("%04X - LD     V%X, DT", i, x)
+		return op("LD", vx, "DT")
 	} else if inst&0xF0FF == 0xF00A {
-		return fmt.Sprintf("%04X - LD     V%X, K", i, x)
+		return op("LD", vx, "K")
 	} else if inst&0xF0FF == 0xF015 {
-		return fmt.Sprintf("%04X - LD     DT, V%X", i, x)
+		return op("LD", "DT", vx)
 	} else if inst&0xF0FF == 0xF018 {
-		return fmt.Sprintf("%04X - LD     ST, V%X", i, x)
+		return op("LD", "ST", vx)
+	} else if inst == 0xF002 {
+		return in(SET_XOCHIP, op("AUDIO"))
 	} else if inst&0xF0FF == 0xF01E {
-		return fmt.Sprintf("%04X - ADD    I, V%X", i, x)
+		return op("ADD", "I", vx)
 	} else if inst&0xF0FF == 0xF029 {
-		return fmt.Sprintf("%04X - LD     F, V%X", i, x)
+		return op("LD", "F", vx)
 	} else if inst&0xF0FF == 0xF030 {
-		return fmt.Sprintf("%04X - LD     HF, V%X", i, x)
+		return in(SET_SUPER, op("LD", "HF", vx))
 	} else if inst&0xF0FF == 0xF033 {
-		return fmt.Sprintf("%04X - BCD    V%X", i, x)
+		return op("BCD", vx)
+	} else if inst&0xF0FF == 0xF03A {
+		return in(SET_XOCHIP, op("PITCH", vx))
 	} else if inst&0xF0FF == 0xF055 {
-		return fmt.Sprintf("%04X - LD     [I], V%X", i, x)
+		return op("LD", "[I]", vx)
 	} else if inst&0xF0FF == 0xF065 {
-		return fmt.Sprintf("%04X - LD     V%X, [I]", i, x)
+		return op("LD", vx, "[I]")
 	} else if inst&0xF0FF == 0xF075 {
-		return fmt.Sprintf("%04X - LD     R, V%X", i, x)
+		return flags(in(SET_SUPER, op("LD", "R", vx)), x)
 	} else if inst&0xF0FF == 0xF085 {
-		return fmt.Sprintf("%04X - LD     V%X, R", i, x)
+		return flags(in(SET_SUPER, op("LD", vx, "R")), x)
 	} else if inst&0xF0FF == 0xF094 {
-		return fmt.Sprintf("%04X - LD     A, V%X", i, x)
+		return in(SET_EXTENDED, op("LD", "A", vx))
 	}
 
 	// unknown instruction
-	return fmt.Sprintf("%04X - ??", i)
+	return instruction{mnemonic: "??", address: -1, flow: FLOW_STOP}
+}
+
+// Mark an instruction as conditionally skipping the next one.
+func skip(i instruction) instruction {
+	i.flow = FLOW_SKIP
+	return i
+}
+
+// Mark a shift as lossy if it shifts VY into VX, which the assembler
+// can't encode.
+func shift(i instruction, x, y uint) instruction {
+	i.lossy = x != y
+	return i
+}
+
+// Write an instruction as it's written in source, with the address
+// operand (if any) replaced by a label.
+func (i instruction) text(label string) string {
+	if len(i.operands) == 0 {
+		return i.mnemonic
+	}
+
+	return fmt.Sprintf("%-6s %s", i.mnemonic, i.operandText(label))
+}
+
+// Write the operands of an instruction, with the address operand (if any)
+// replaced by a label.
+func (i instruction) operandText(label string) string {
+	operands := append([]string{}, i.operands...)
+
+	if i.address >= 0 && label != "" {
+		operands[i.address] = label
+	}
+
+	return strings.Join(operands, ", ")
+}
+
+// Mark a flags register load or store as lossy if it uses registers past
+// V7, which the assembler won't encode.
+func flags(i instruction, x uint) instruction {
+	i.lossy = x > 7
+	return i
+}
+
+// Disassemble a CHIP-8 instruction. If the program was assembled from
+// source, addresses are shown as labels and V registers by their VAR
+// aliases.
+func (vm *CHIP_8) Disassemble(i uint) string {
+	if int(i) >= len(vm.Memory)-1 {
+		return ""
+	}
+
+	// fetch the instruction at this location
+	inst := disassemble(uint(vm.Memory[i])<<8 | uint(vm.Memory[i+1]))
+
+	// end of program memory?
+	if inst.mnemonic == "" {
+		return fmt.Sprintf("%04X -", i)
+	}
+
+	// name the registers, except V0 of JP V0, which is always V0
+	if len(vm.Aliases) > 0 && inst.flow != FLOW_TABLE {
+		inst.operands = append([]string{}, inst.operands...)
+
+		for n, s := range inst.operands {
+			var r uint
+
+			if _, err := fmt.Sscanf(s, "V%X", &r); err == nil && len(s) == 2 {
+				if alias, ok := vm.Aliases[r]; ok {
+					inst.operands[n] = alias
+				}
+			}
+		}
+	}
+
+	return fmt.Sprintf("%04X - %s", i, inst.text(vm.Symbols[inst.target]))
+}
+
+// Write an instruction in Octo syntax, with its address operand (if any)
+// replaced by a label. Returns false if Octo has no way to write it.
+func octo(inst uint, label string) (string, bool) {
+	a := inst & 0xFFF
+	b := inst & 0xFF
+	n := inst & 0xF
+
+	// vx and vy registers
+	x := fmt.Sprintf("v%x", inst>>8&0xF)
+	y := fmt.Sprintf("v%x", inst>>4&0xF)
+
+	if label == "" {
+		label = fmt.Sprintf("0x%03X", a)
+	}
+
+	switch {
+	case inst == 0x00E0:
+		return "clear", true
+	case inst == 0x00EE:
+		return "return", true
+	case inst == 0x00FB:
+		return "scroll-right", true
+	case inst == 0x00FC:
+		return "scroll-left", true
+	case inst == 0x00FD:
+		return "exit", true
+	case inst == 0x00FE:
+		return "lores", true
+	case inst == 0x00FF:
+		return "hires", true
+	case inst&0xFFF0 == 0x00C0:
+		return fmt.Sprintf("scroll-down %d", n), true
+	case inst&0xF000 == 0x1000:
+		return "jump " + label, true
+	case inst&0xF000 == 0x2000:
+		if strings.HasPrefix(label, "0x") {
+			return ":call " + label, true
+		}
+		return label, true
+	case inst&0xF000 == 0x3000:
+		return fmt.Sprintf("if %s != 0x%02X then", x, b), true
+	case inst&0xF000 == 0x4000:
+		return fmt.Sprintf("if %s == 0x%02X then", x, b), true
+	case inst&0xF00F == 0x5000:
+		return fmt.Sprintf("if %s != %s then", x, y), true
+	case inst&0xF000 == 0x6000:
+		return fmt.Sprintf("%s := 0x%02X", x, b), true
+	case inst&0xF000 == 0x7000:
+		return fmt.Sprintf("%s += 0x%02X", x, b), true
+	case inst&0xF00F == 0x8000:
+		return fmt.Sprintf("%s := %s", x, y), true
+	case inst&0xF00F == 0x8001:
+		return fmt.Sprintf("%s |= %s", x, y), true
+	case inst&0xF00F == 0x8002:
+		return fmt.Sprintf("%s &= %s", x, y), true
+	case inst&0xF00F == 0x8003:
+		return fmt.Sprintf("%s ^= %s", x, y), true
+	case inst&0xF00F == 0x8004:
+		return fmt.Sprintf("%s += %s", x, y), true
+	case inst&0xF00F == 0x8005:
+		return fmt.Sprintf("%s -= %s", x, y), true
+	case inst&0xF00F == 0x8006:
+		return fmt.Sprintf("%s >>= %s", x, y), true
+	case inst&0xF00F == 0x8007:
+		return fmt.Sprintf("%s =- %s", x, y), true
+	case inst&0xF00F == 0x800E:
+		return fmt.Sprintf("%s <<= %s", x, y), true
+	case inst&0xF00F == 0x9000:
+		return fmt.Sprintf("if %s == %s then", x, y), true
+	case inst&0xF000 == 0xA000:
+		return "i := " + label, true
+	case inst&0xF000 == 0xB000:
+		return "jump0 " + label, true
+	case inst&0xF000 == 0xC000:
+		return fmt.Sprintf("%s := random 0x%02X", x, b), true
+	case inst&0xF000 == 0xD000:
+		return fmt.Sprintf("sprite %s %s %d", x, y, n), true
+	case inst&0xF0FF == 0xE09E:
+		return fmt.Sprintf("if %s -key then", x), true
+	case inst&0xF0FF == 0xE0A1:
+		return fmt.Sprintf("if %s key then", x), true
+	case inst == 0xF002:
+		return "audio", true
+	case inst&0xF0FF == 0xF007:
+		return x + " := delay", true
+	case inst&0xF0FF == 0xF00A:
+		return x + " := key", true
+	case inst&0xF0FF == 0xF015:
+		return "delay := " + x, true
+	case inst&0xF0FF == 0xF018:
+		return "buzzer := " + x, true
+	case inst&0xF0FF == 0xF01E:
+		return "i += " + x, true
+	case inst&0xF0FF == 0xF029:
+		return "i := hex " + x, true
+	case inst&0xF0FF == 0xF030:
+		return "i := bighex " + x, true
+	case inst&0xF0FF == 0xF033:
+		return "bcd " + x, true
+	case inst&0xF0FF == 0xF03A:
+		return "pitch := " + x, true
+	case inst&0xF0FF == 0xF055:
+		return "save " + x, true
+	case inst&0xF0FF == 0xF065:
+		return "load " + x, true
+	case inst&0xF0FF == 0xF075:
+		return "saveflags " + x, true
+	case inst&0xF0FF == 0xF085:
+		return "loadflags " + x, true
+	}
+
+	// SYS, SCU, and the CHIP-8E instructions
+	return "", false
 }
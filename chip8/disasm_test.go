@@ -0,0 +1,64 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import "testing"
+
+// TestDisassembleSymbols checks that programs assembled from source are
+// disassembled with their labels and register aliases.
+func TestDisassembleSymbols(t *testing.T) {
+	source := `
+head_x      var         v4
+            ld          v0, 0
+            call        draw_snake
+            jp          v0, draw_snake
+draw_snake  ld          i, food_sprite
+            add         head_x, v0
+            ret
+food_sprite byte        %1.......
+`
+
+	asm, err := Assemble([]byte(source), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := LoadAssembly(asm, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"0200 - LD     V0, #00",
+		"0202 - CALL   DRAW_SNAKE",
+		"0204 - JP     V0, DRAW_SNAKE",
+		"0206 - LD     I, FOOD_SPRITE",
+		"0208 - ADD    HEAD_X, V0",
+		"020A - RET",
+	}
+
+	for i, s := range want {
+		if got := vm.Disassemble(0x200 + uint(i*2)); got != s {
+			t.Errorf("got %q, want %q", got, s)
+		}
+	}
+}
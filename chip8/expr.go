@@ -0,0 +1,340 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expr is an expression parsed from text, which can be evaluated with the
+// state of a virtual machine. Expressions use C operators and precedence,
+// the registers (V0-VF, I, PC, SP, DT, and ST), labels, literals written
+// as they are in assembly, and [address] to read a byte of memory.
+type Expr struct {
+	// source is the text the expression was parsed from.
+	source string
+
+	// eval computes the value of the expression.
+	eval func(vm *CHIP_8) int
+}
+
+// exprParser is the state of parsing an expression.
+type exprParser struct {
+	// s is the text being parsed, and pos the offset of the next token.
+	s   string
+	pos int
+
+	// asm is the assembled program labels are looked up in, if any.
+	asm *Assembly
+}
+
+// binaryOp is an infix operator.
+type binaryOp struct {
+	// prec is the precedence of the operator; higher binds tighter.
+	prec int
+
+	// apply computes the operator.
+	apply func(a, b int) int
+}
+
+// binaryOps are all infix operators. Longer operators must be matched
+// before their prefixes.
+var binaryOps = map[string]binaryOp{
+	"||": {1, func(a, b int) int { return truth(a != 0 || b != 0) }},
+	"&&": {2, func(a, b int) int { return truth(a != 0 && b != 0) }},
+	"|":  {3, func(a, b int) int { return a | b }},
+	"^":  {4, func(a, b int) int { return a ^ b }},
+	"&":  {5, func(a, b int) int { return a & b }},
+	"==": {6, func(a, b int) int { return truth(a == b) }},
+	"!=": {6, func(a, b int) int { return truth(a != b) }},
+	"<":  {7, func(a, b int) int { return truth(a < b) }},
+	"<=": {7, func(a, b int) int { return truth(a <= b) }},
+	">":  {7, func(a, b int) int { return truth(a > b) }},
+	">=": {7, func(a, b int) int { return truth(a >= b) }},
+	"<<": {8, func(a, b int) int { return a << uint(b&63) }},
+	">>": {8, func(a, b int) int { return a >> uint(b&63) }},
+	"+":  {9, func(a, b int) int { return a + b }},
+	"-":  {9, func(a, b int) int { return a - b }},
+	"*":  {10, func(a, b int) int { return a * b }},
+	"/":  {10, func(a, b int) int { return divide(a, b, false) }},
+	"%":  {10, func(a, b int) int { return divide(a, b, true) }},
+}
+
+// ParseExpr parses an expression. Labels are looked up in the assembled
+// program given, which may be nil, preferring an exact match but ignoring
+// case otherwise.
+func ParseExpr(s string, asm *Assembly) (*Expr, error) {
+	p := &exprParser{s: s, asm: asm}
+
+	eval, err := p.parse(1)
+	if err != nil {
+		return nil, err
+	}
+
+	// the entire string must be the expression
+	if p.skipSpace(); p.pos < len(p.s) {
+		return nil, fmt.Errorf("unexpected '%s'", p.s[p.pos:])
+	}
+
+	return &Expr{source: strings.TrimSpace(s), eval: eval}, nil
+}
+
+// Eval returns the value of the expression. Division by zero is zero.
+func (e *Expr) Eval(vm *CHIP_8) int {
+	return e.eval(vm)
+}
+
+// String returns the text the expression was parsed from.
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Convert a boolean to 1 or 0.
+func truth(b bool) int {
+	if b {
+		return 1
+	}
+
+	return 0
+}
+
+// Divide or take the modulo of two values, which is zero if dividing by
+// zero.
+func divide(a, b int, mod bool) int {
+	if b == 0 {
+		return 0
+	}
+
+	if mod {
+		return a % b
+	}
+
+	return a / b
+}
+
+// Skip whitespace.
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.s) && (p.s[p.pos] == ' ' || p.s[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+// Parse binary operators of at least a minimum precedence, left to right.
+func (p *exprParser) parse(prec int) (func(vm *CHIP_8) int, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		p.skipSpace()
+
+		// find the longest operator next
+		var name string
+
+		for n := 2; n > 0 && name == ""; n-- {
+			if p.pos+n <= len(p.s) {
+				if _, ok := binaryOps[p.s[p.pos:p.pos+n]]; ok {
+					name = p.s[p.pos : p.pos+n]
+				}
+			}
+		}
+
+		op, ok := binaryOps[name]
+		if !ok || op.prec < prec {
+			return left, nil
+		}
+
+		p.pos += len(name)
+
+		// operators of higher precedence bind to the right operand
+		right, err := p.parse(op.prec + 1)
+		if err != nil {
+			return nil, err
+		}
+
+		l, apply := left, op.apply
+
+		left = func(vm *CHIP_8) int { return apply(l(vm), right(vm)) }
+	}
+}
+
+// Parse a prefix operator or a primary value.
+func (p *exprParser) parseUnary() (func(vm *CHIP_8) int, error) {
+	p.skipSpace()
+
+	if p.pos >= len(p.s) {
+		return nil, errors.New("unexpected end of expression")
+	}
+
+	switch c := p.s[p.pos]; c {
+	case '-', '!', '~':
+		p.pos++
+
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+
+		switch c {
+		case '-':
+			return func(vm *CHIP_8) int { return -x(vm) }, nil
+		case '!':
+			return func(vm *CHIP_8) int { return truth(x(vm) == 0) }, nil
+		default:
+			return func(vm *CHIP_8) int { return ^x(vm) }, nil
+		}
+	case '(':
+		p.pos++
+
+		return p.parseGroup(')', func(x int, vm *CHIP_8) int { return x })
+	case '[':
+		p.pos++
+
+		return p.parseGroup(']', func(x int, vm *CHIP_8) int { return int(vm.Memory[x&0xFFF]) })
+	}
+
+	return p.parsePrimary()
+}
+
+// Parse an expression in brackets, and apply a function to its value.
+func (p *exprParser) parseGroup(end byte, f func(x int, vm *CHIP_8) int) (func(vm *CHIP_8) int, error) {
+	x, err := p.parse(1)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.skipSpace(); p.pos >= len(p.s) || p.s[p.pos] != end {
+		return nil, fmt.Errorf("missing '%c'", end)
+	}
+
+	p.pos++
+
+	return func(vm *CHIP_8) int { return f(x(vm), vm) }, nil
+}
+
+// Parse a literal, register, or label.
+func (p *exprParser) parsePrimary() (func(vm *CHIP_8) int, error) {
+	start := p.pos
+
+	// literals are prefixed with # or %, identifiers may contain both
+	for p.pos < len(p.s) && (p.pos == start || !strings.ContainsRune("#%", rune(p.s[p.pos]))) && isExprChar(p.s[p.pos]) {
+		p.pos++
+	}
+
+	token := p.s[start:p.pos]
+
+	if token == "" {
+		return nil, fmt.Errorf("unexpected '%s'", p.s[start:])
+	}
+
+	// numeric literals
+	if c := token[0]; c == '#' || c == '%' || (c >= '0' && c <= '9') {
+		v, err := parseLiteral(token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid literal %s", token)
+		}
+
+		return func(*CHIP_8) int { return v }, nil
+	}
+
+	name := strings.ToUpper(token)
+
+	// registers
+	if len(name) == 2 && name[0] == 'V' {
+		if x, err := strconv.ParseUint(name[1:], 16, 4); err == nil {
+			return func(vm *CHIP_8) int { return int(vm.V[x]) }, nil
+		}
+	}
+
+	switch name {
+	case "I":
+		return func(vm *CHIP_8) int { return int(vm.I) }, nil
+	case "PC":
+		return func(vm *CHIP_8) int { return int(vm.PC) }, nil
+	case "SP":
+		return func(vm *CHIP_8) int { return int(vm.SP) }, nil
+	case "DT":
+		return func(vm *CHIP_8) int { return int(vm.GetDelayTimer()) }, nil
+	case "ST":
+		return func(vm *CHIP_8) int { return int(vm.GetSoundTimer()) }, nil
+	}
+
+	// labels
+	if address, ok := p.label(token); ok {
+		return func(*CHIP_8) int { return address }, nil
+	}
+
+	return nil, fmt.Errorf("unknown label or register %s", token)
+}
+
+// Look up the address of a label in the assembled program.
+func (p *exprParser) label(name string) (int, bool) {
+	if p.asm == nil {
+		return 0, false
+	}
+
+	t, ok := p.asm.Labels[name]
+
+	// the C8 assembler uppercases labels, octo doesn't
+	if !ok {
+		for label, lt := range p.asm.Labels {
+			if strings.EqualFold(label, name) {
+				t, ok = lt, true
+				break
+			}
+		}
+	}
+
+	if !ok || t.typ != TOKEN_LIT {
+		return 0, false
+	}
+
+	address, ok := t.val.(int)
+	return address, ok
+}
+
+// True if a character can be part of a literal or identifier.
+func isExprChar(c byte) bool {
+	return c == '_' || c == '.' || c == '#' || c == '%' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// Parse a decimal, #hexadecimal, or %binary literal (which may use . in
+// place of 0).
+func parseLiteral(s string) (int, error) {
+	var v uint64
+	var err error
+
+	switch s[0] {
+	case '#':
+		v, err = strconv.ParseUint(s[1:], 16, 32)
+	case '%':
+		v, err = strconv.ParseUint(strings.Replace(s[1:], ".", "0", -1), 2, 32)
+	default:
+		v, err = strconv.ParseUint(s, 10, 32)
+	}
+
+	return int(v), err
+}
@@ -0,0 +1,145 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"testing"
+)
+
+// exprProgram has two labels at the same address.
+const exprProgram = `
+main
+loop        jp          loop
+data        byte        #42
+            byte        7
+`
+
+// TestParseExpr evaluates expressions with registers, memory, and labels.
+func TestParseExpr(t *testing.T) {
+	asm, err := Assemble([]byte(exprProgram), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm := &CHIP_8{I: 0x202}
+
+	vm.V[0] = 3
+	vm.V[1] = 10
+	vm.Memory[0x202] = 0x42
+	vm.Memory[0x203] = 7
+
+	tests := []struct {
+		expr string
+		want int
+	}{
+		// precedence and associativity
+		{"1 + 2 * 3", 7},
+		{"(1 + 2) * 3", 9},
+		{"10 - 3 - 2", 5},
+		{"1 << 2 + 1", 8},
+		{"1 | 2 & 3", 3},
+		{"1 ^ 3 | 4", 6},
+		{"2 == 2 && 1 < 2", 1},
+		{"0 || 2 > 3", 0},
+		{"1 + 2 == 3", 1},
+		{"-V0 + 5", 2},
+		{"!0 + (~0 & #FF)", 256},
+
+		// % is modulo between operands and a binary literal otherwise
+		{"V1 % 3", 1},
+		{"V1%4", 2},
+		{"%101", 5},
+		{"%1.1.", 10},
+		{"V1 % %11", 1},
+		{"%101 % 3", 2},
+		{"10 / 0", 0},
+		{"7 % 0", 0},
+
+		// memory
+		{"[I]", 0x42},
+		{"[I + 1]", 7},
+		{"[#202] + [#203]", 0x49},
+		{"[data] * 2", 0x84},
+
+		// labels ignore case, and aliases share an address
+		{"loop", 0x200},
+		{"LOOP + 2", 0x202},
+		{"main", 0x200},
+		{"Data", 0x202},
+
+		// registers
+		{"V0 + v1", 13},
+		{"I", 0x202},
+		{"#FF", 255},
+	}
+
+	for _, test := range tests {
+		e, err := ParseExpr(test.expr, asm)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+
+		if got := e.Eval(vm); got != test.want {
+			t.Errorf("%s: expected %d, got %d", test.expr, test.want, got)
+		}
+	}
+}
+
+// TestParseExprOcto checks an exact label match is preferred, since octo
+// labels are case-sensitive.
+func TestParseExprOcto(t *testing.T) {
+	asm, err := AssembleOcto([]byte(": main\n: Loop jump Loop\n: loop jump loop\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		expr string
+		want int
+	}{
+		{"main", 0x200},
+		{"Loop", 0x200},
+		{"loop", 0x202},
+	}
+
+	for _, test := range tests {
+		e, err := ParseExpr(test.expr, asm)
+		if err != nil {
+			t.Errorf("%s: %s", test.expr, err)
+			continue
+		}
+
+		if got := e.Eval(&CHIP_8{}); got != test.want {
+			t.Errorf("%s: expected %d, got %d", test.expr, test.want, got)
+		}
+	}
+}
+
+// TestParseExprErrors checks malformed expressions aren't parsed.
+func TestParseExprErrors(t *testing.T) {
+	for _, s := range []string{"", "1 +", "(1", "[I", "1 2", "#G", "VG", "nolabel", "V0 )"} {
+		if _, err := ParseExpr(s, nil); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+//go:build go1.18
+// +build go1.18
+
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// addSeeds adds every file matching a pattern to the fuzzing corpus.
+func addSeeds(f *testing.F, pattern string) {
+	files, _ := filepath.Glob(pattern)
+
+	for _, file := range files {
+		if data, err := ioutil.ReadFile(file); err == nil {
+			f.Add(data)
+		}
+	}
+}
+
+// FuzzStep runs random programs, which must never crash the VM.
+func FuzzStep(f *testing.F) {
+	addSeeds(f, "../games/roms/*")
+
+	// programs that used to crash the VM
+	f.Add([]byte{0x00, 0x01})             // SYS
+	f.Add([]byte{0x00, 0xEE})             // RET with an empty stack
+	f.Add([]byte{0x22, 0x00})             // CALL until the stack is full
+	f.Add([]byte{0x90, 0x02})             // DIV by zero
+	f.Add([]byte{0xAF, 0xFF, 0xD0, 0x05}) // DRW past the end of memory
+	f.Add([]byte{0xAF, 0xFF, 0xD0, 0x00}) // 16x16 DRW past the end of memory
+	f.Add([]byte{0x60, 0xFF, 0xE0, 0x9E}) // SKP with VX > F
+	f.Add([]byte{0xFF, 0x85})             // LD VF, R
+	f.Add([]byte{0xAF, 0xFF, 0xF0, 0x33}) // BCD at the end of memory
+	f.Add([]byte{0x60, 0xFF, 0xBF, 0xFF}) // JP V0 past the end of memory
+	f.Add([]byte{0x1F, 0xFF})             // fetch at the end of memory
+	f.Add([]byte{0x60, 0x10, 0xBF, 0xFF}) // step over past the end of memory
+
+	f.Fuzz(func(t *testing.T, program []byte) {
+		vm, err := LoadROM(program, false)
+		if err != nil {
+			return
+		}
+
+		// the first byte picks the quirks
+		if len(program) > 0 {
+			vm.Quirks = Quirks{
+				ShiftVY:    program[0]&1 != 0,
+				LoadStoreI: program[0]&2 != 0,
+				JumpVX:     program[0]&4 != 0,
+				ResetVF:    program[0]&8 != 0,
+			}
+		}
+
+		// the same program run without predecoding
+		ref, _ := LoadROM(program, false)
+
+		ref.Quirks = vm.Quirks
+		ref.Predecode = false
+		ref.Seed = vm.Seed
+		ref.Reset()
+
+		for i := 0; i < 500; i++ {
+			err := vm.run(16)
+
+			// catch up, or fail the same way
+			if ref.Step() != nil && err == nil {
+				t.Fatalf("only the interpreter failed at #%04X", ref.PC)
+			}
+
+			for ref.Steps < vm.Steps {
+				ref.Step()
+			}
+
+			compareStep(t, vm, ref)
+
+			// keep going past key waits
+			if vm.W != nil {
+				vm.PressKey(uint(i & 0xF))
+				ref.PressKey(uint(i & 0xF))
+			}
+		}
+
+		// the debugger can step from wherever the program ended up
+		if vm.StepOverBreakpoint() {
+			for address := range vm.Breakpoints {
+				if address > 0xFFF {
+					t.Fatalf("step over breakpoint at #%04X", address)
+				}
+			}
+		}
+
+		vm.StepOut()
+	})
+}
+
+// Fail if a VM executing predecoded instructions isn't in the same state
+// as one that is not.
+func compareStep(t *testing.T, vm, ref *CHIP_8) {
+	if vm.PC != ref.PC || vm.I != ref.I || vm.SP != ref.SP || vm.V != ref.V || vm.Stack != ref.Stack {
+		t.Fatalf("registers differ after %d steps: PC=#%04X/#%04X I=#%04X/#%04X V=%v/%v", vm.Steps, vm.PC, ref.PC, vm.I, ref.I, vm.V, ref.V)
+	}
+
+	if vm.Memory != ref.Memory || vm.Video != ref.Video || (vm.W == nil) != (ref.W == nil) {
+		t.Fatalf("memory or video differs after %d steps", vm.Steps)
+	}
+}
+
+// FuzzAssemble assembles random source, which must either succeed or
+// return an error; never crash.
+func FuzzAssemble(f *testing.F) {
+	addSeeds(f, "../games/sources/*.c8")
+	addSeeds(f, "conformance/testdata/roms/*.c8")
+
+	f.Fuzz(func(t *testing.T, source []byte) {
+		if _, err := Assemble(source, false); err != nil {
+			if strings.Contains(err.Error(), "runtime error") {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+// FuzzAssembleOcto assembles random Octo source, which must either
+// succeed or return an error; never crash.
+func FuzzAssembleOcto(f *testing.F) {
+	f.Add([]byte(": main\n\tloop\n\t\tv0 += 1\n\t\twhile v0 < 10\n\tagain\n"))
+	f.Add([]byte(":macro twice a { a a }\n: main twice clear if v1 key begin i := main else ; end"))
+	f.Add([]byte(":calc X { ( 1 + 2 ) * HERE }\n: main :byte { X >> 4 } :unpack 1 X :org 0x300"))
+
+	f.Fuzz(func(t *testing.T, source []byte) {
+		if _, err := AssembleOcto(source, false); err != nil {
+			if strings.Contains(err.Error(), "runtime error") {
+				t.Fatal(err)
+			}
+		}
+	})
+}
+
+// FuzzRoundTrip assembles random source, disassembles the ROM, and
+// reassembles it. Disassembling the second ROM must give the same text.
+func FuzzRoundTrip(f *testing.F) {
+	addSeeds(f, "../games/sources/*.c8")
+	addSeeds(f, "conformance/testdata/roms/*.c8")
+
+	f.Fuzz(func(t *testing.T, source []byte) {
+		asm, err := Assemble(source, false)
+		if err != nil {
+			return
+		}
+
+		text := disassembleROM(t, asm.ROM)
+
+		again, err := Assemble([]byte(text), false)
+		if err != nil {
+			t.Fatalf("%s\n%s", err, text)
+		}
+
+		if s := disassembleROM(t, again.ROM); s != text {
+			t.Fatalf("disassembly changed\n%s\n%s", text, s)
+		}
+	})
+}
+
+// FuzzDisassembleROM disassembles random ROMs, which must assemble back
+// into the identical ROM.
+func FuzzDisassembleROM(f *testing.F) {
+	addSeeds(f, "../games/roms/*")
+	addSeeds(f, "../games/roms/super/*")
+
+	// control flow that is hard to disassemble
+	f.Add([]byte{0x12, 0x03, 0x00, 0x60, 0x01})                   // JP into the middle of data
+	f.Add([]byte{0x60, 0x02, 0xB2, 0x04, 0x12, 0x08, 0x12, 0x0A}) // JP V0 to a table
+	f.Add([]byte{0x81, 0x26, 0xF0, 0x02, 0x50, 0x13, 0xFC, 0x85}) // lossy and unknown
+	f.Add([]byte{0xA2, 0x06, 0xD0, 0x01, 0x00, 0xEE, 0xFF})       // sprite
+
+	f.Fuzz(func(t *testing.T, rom []byte) {
+		if len(rom) > 0x1000-0x200 {
+			return
+		}
+
+		roundTrip(t, "rom", rom, false)
+	})
+}
+
+// Disassemble a ROM into source that can be assembled again.
+func disassembleROM(t *testing.T, rom []byte) string {
+	vm, err := LoadROM(rom, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := []string{"super", "extended"}
+
+	for i := 0; i < len(rom); i += 2 {
+		a := vm.Base + uint(i)
+
+		// a trailing byte of data
+		if i+1 == len(rom) {
+			lines = append(lines, fmt.Sprintf("byte #%02X", rom[i]))
+			break
+		}
+
+		// drop the address
+		s := strings.TrimSpace(vm.Disassemble(a)[6:])
+
+		// instructions the assembler doesn't know are written as data
+		if s == "" || s == "??" || s == "AUDIO" || strings.HasPrefix(s, "PITCH") {
+			s = fmt.Sprintf("word #%02X%02X", rom[i], rom[i+1])
+		}
+
+		lines = append(lines, s)
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
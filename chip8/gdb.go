@@ -0,0 +1,694 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// gdbTarget describes the registers to GDB. The 16-bit registers are
+// sent little-endian, like all other register values.
+const gdbTarget = `<?xml version="1.0"?>
+<!DOCTYPE target SYSTEM "gdb-target.dtd">
+<target version="1.0">
+  <feature name="org.chip8.core">
+    <reg name="v0" bitsize="8" regnum="0"/>
+    <reg name="v1" bitsize="8"/>
+    <reg name="v2" bitsize="8"/>
+    <reg name="v3" bitsize="8"/>
+    <reg name="v4" bitsize="8"/>
+    <reg name="v5" bitsize="8"/>
+    <reg name="v6" bitsize="8"/>
+    <reg name="v7" bitsize="8"/>
+    <reg name="v8" bitsize="8"/>
+    <reg name="v9" bitsize="8"/>
+    <reg name="va" bitsize="8"/>
+    <reg name="vb" bitsize="8"/>
+    <reg name="vc" bitsize="8"/>
+    <reg name="vd" bitsize="8"/>
+    <reg name="ve" bitsize="8"/>
+    <reg name="vf" bitsize="8"/>
+    <reg name="i" bitsize="16" type="data_ptr"/>
+    <reg name="pc" bitsize="16" type="code_ptr"/>
+    <reg name="sp" bitsize="8"/>
+    <reg name="dt" bitsize="8"/>
+    <reg name="st" bitsize="8"/>
+  </feature>
+</target>
+`
+
+// Register numbers after V0-VF.
+const (
+	GDB_REG_I = 16 + iota
+	GDB_REG_PC
+	GDB_REG_SP
+	GDB_REG_DT
+	GDB_REG_ST
+	GDB_REG_COUNT
+)
+
+// gdbSession is a single connection from a GDB client.
+type gdbSession struct {
+	// runner owns the virtual machine being debugged.
+	runner *Runner
+
+	// conn is the connection to the client.
+	conn net.Conn
+
+	// packets are the packets received, with an interrupt sent as "\x03".
+	packets chan string
+
+	// noAck is true once the client has turned off acknowledgements.
+	noAck bool
+
+	// breakpoints and watchpoints are those set by the client, so they
+	// can be removed when it disconnects.
+	breakpoints map[int]bool
+	watchpoints map[uint]Access
+}
+
+// ServeGDB accepts connections from GDB (or any other client speaking the
+// GDB remote serial protocol) and lets them debug the virtual machine
+// being run, one connection at a time. The virtual machine is paused
+// when a client connects and resumed when it disconnects. Returns when
+// the listener is closed.
+func ServeGDB(l net.Listener, r *Runner) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		newGDBSession(r, conn).serve()
+	}
+}
+
+// Create a session for a client connection.
+func newGDBSession(r *Runner, conn net.Conn) *gdbSession {
+	return &gdbSession{
+		runner:      r,
+		conn:        conn,
+		packets:     make(chan string, 16),
+		breakpoints: make(map[int]bool),
+		watchpoints: make(map[uint]Access),
+	}
+}
+
+// Handle packets until the client disconnects.
+func (s *gdbSession) serve() {
+	defer s.conn.Close()
+
+	// the target is stopped while a debugger is attached
+	s.runner.Pause(true)
+
+	go s.receive()
+
+	for p := range s.packets {
+		if p == "\x03" {
+			continue
+		}
+
+		reply, resume := s.handle(p)
+
+		// wait for the virtual machine to stop again
+		if resume {
+			reply = s.wait()
+		}
+
+		if s.send(reply) != nil || p == "D" || p == "k" {
+			break
+		}
+	}
+
+	s.detach()
+}
+
+// Read packets from the client, acknowledging them, until the connection
+// is closed.
+func (s *gdbSession) receive() {
+	defer close(s.packets)
+
+	in := bufio.NewReader(s.conn)
+
+	for {
+		c, err := in.ReadByte()
+		if err != nil {
+			return
+		}
+
+		switch c {
+		case 0x03:
+			s.packets <- "\x03"
+		case '$':
+			data, err := in.ReadString('#')
+			if err != nil {
+				return
+			}
+
+			// two hex digits of checksum follow
+			sum := make([]byte, 2)
+
+			if _, err := in.Read(sum[:1]); err != nil {
+				return
+			}
+
+			if _, err := in.Read(sum[1:]); err != nil {
+				return
+			}
+
+			data = data[:len(data)-1]
+
+			// ask for the packet again if it was corrupt
+			if n, err := strconv.ParseUint(string(sum), 16, 8); err != nil || byte(n) != checksum(data) {
+				if !s.noAck {
+					s.conn.Write([]byte("-"))
+				}
+
+				continue
+			}
+
+			if !s.noAck {
+				s.conn.Write([]byte("+"))
+			}
+
+			s.packets <- data
+		}
+	}
+}
+
+// Send a reply packet.
+func (s *gdbSession) send(data string) error {
+	_, err := fmt.Fprintf(s.conn, "$%s#%02x", data, checksum(data))
+
+	return err
+}
+
+// Sum the bytes of a packet.
+func checksum(data string) byte {
+	sum := byte(0)
+
+	for i := 0; i < len(data); i++ {
+		sum += data[i]
+	}
+
+	return sum
+}
+
+// Remove everything the client set and resume the virtual machine.
+func (s *gdbSession) detach() {
+	s.runner.Do(func(vm *CHIP_8) {
+		for address := range s.breakpoints {
+			vm.RemoveBreakpoint(address)
+		}
+
+		for address, access := range s.watchpoints {
+			vm.RemoveWatchpoint(address, access)
+		}
+	})
+
+	s.runner.Pause(false)
+}
+
+// Handle a packet and return the reply. If the virtual machine was
+// resumed, the reply is sent once it stops.
+func (s *gdbSession) handle(p string) (reply string, resume bool) {
+	switch {
+	case p == "":
+		return "", false
+	case p == "?":
+		return s.stopReply(nil), false
+	case p == "g":
+		return s.readRegisters(), false
+	case p[0] == 'G':
+		return s.writeRegisters(p[1:]), false
+	case p[0] == 'p':
+		return s.readRegister(p[1:]), false
+	case p[0] == 'P':
+		return s.writeRegister(p[1:]), false
+	case p[0] == 'm':
+		return s.readMemory(p[1:]), false
+	case p[0] == 'M':
+		return s.writeMemory(p[1:]), false
+	case p[0] == 'c':
+		return s.resume(p[1:])
+	case p[0] == 's':
+		return s.step(p[1:]), false
+	case p[0] == 'Z' || p[0] == 'z':
+		return s.setBreakpoint(p[0] == 'Z', p[1:]), false
+	case p[0] == 'H', p == "D", p == "k":
+		return "OK", false
+	case p == "QStartNoAckMode":
+		s.noAck = true
+		return "OK", false
+	case strings.HasPrefix(p, "qSupported"):
+		return "PacketSize=4000;qXfer:features:read+;QStartNoAckMode+;swbreak+;hwbreak+", false
+	case strings.HasPrefix(p, "qXfer:features:read:target.xml:"):
+		return readXfer(gdbTarget, p[len("qXfer:features:read:target.xml:"):]), false
+	case p == "qAttached":
+		return "1", false
+	case p == "qC":
+		return "QC1", false
+	case p == "qfThreadInfo":
+		return "m1", false
+	case p == "qsThreadInfo":
+		return "l", false
+	}
+
+	// unsupported
+	return "", false
+}
+
+// Build the stop reply for the reason the virtual machine stopped.
+func (s *gdbSession) stopReply(halt error) string {
+	switch h := halt.(type) {
+	case nil:
+		return "S05"
+	case Watchpoint:
+		access, ok := s.watchpoints[h.Address]
+
+		// report the kind of watchpoint the client set, if it set one
+		if !ok {
+			access = h.Access
+		}
+
+		switch access {
+		case ACCESS_WRITE:
+			return fmt.Sprintf("T05watch:%x;", h.Address)
+		case ACCESS_READ:
+			return fmt.Sprintf("T05rwatch:%x;", h.Address)
+		}
+
+		return fmt.Sprintf("T05awatch:%x;", h.Address)
+	case Breakpoint:
+		return "T05swbreak:;"
+	case Divergence:
+		return "S05"
+	}
+
+	// faults
+	return "S0B"
+}
+
+// Resume at an optional address and wait for the virtual machine to stop.
+func (s *gdbSession) resume(args string) (string, bool) {
+	if args != "" {
+		address, err := strconv.ParseUint(args, 16, 16)
+		if err != nil {
+			return "E01", false
+		}
+
+		s.runner.Do(func(vm *CHIP_8) { vm.PC = uint(address) & 0xFFF })
+	}
+
+	s.runner.Pause(false)
+
+	return "", true
+}
+
+// Wait until the virtual machine stops, or the client interrupts it, and
+// return the stop reply.
+func (s *gdbSession) wait() string {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case p, ok := <-s.packets:
+			if !ok {
+				return ""
+			}
+
+			if p == "\x03" {
+				s.runner.Pause(true)
+
+				return "S02"
+			}
+		case <-ticker.C:
+			if paused, halt := s.runner.Halted(); paused {
+				return s.stopReply(halt)
+			}
+		}
+	}
+}
+
+// Step a single instruction from an optional address.
+func (s *gdbSession) step(args string) string {
+	var err error
+
+	address, perr := strconv.ParseUint(args, 16, 16)
+	if args != "" && perr != nil {
+		return "E01"
+	}
+
+	s.runner.Do(func(vm *CHIP_8) {
+		if args != "" {
+			vm.PC = uint(address) & 0xFFF
+		}
+
+		err = vm.Step()
+	})
+
+	// stepping onto a breakpoint is just a step
+	if _, ok := err.(Breakpoint); ok {
+		err = nil
+	}
+
+	return s.stopReply(err)
+}
+
+// Get the value and size (in bytes) of a register.
+func gdbRegister(vm *CHIP_8, n int) (uint, int) {
+	switch {
+	case n < 16:
+		return uint(vm.V[n]), 1
+	case n == GDB_REG_I:
+		return vm.I, 2
+	case n == GDB_REG_PC:
+		return vm.PC, 2
+	case n == GDB_REG_SP:
+		return vm.SP, 1
+	case n == GDB_REG_DT:
+		return uint(vm.GetDelayTimer()), 1
+	case n == GDB_REG_ST:
+		return uint(vm.GetSoundTimer()), 1
+	}
+
+	return 0, 0
+}
+
+// Set the value of a register.
+func setGDBRegister(vm *CHIP_8, n int, v uint) {
+	switch {
+	case n < 16:
+		vm.V[n] = byte(v)
+	case n == GDB_REG_I:
+		vm.I = v & 0xFFFF
+	case n == GDB_REG_PC:
+		vm.PC = v & 0xFFF
+	case n == GDB_REG_SP:
+		if v <= uint(len(vm.Stack)) {
+			vm.SP = v
+		}
+	case n == GDB_REG_DT:
+		vm.SetDelayTimer(byte(v))
+	case n == GDB_REG_ST:
+		vm.SetSoundTimer(byte(v))
+	}
+}
+
+// Encode a register value as little-endian hex.
+func encodeRegister(v uint, size int) string {
+	b := make([]byte, size)
+
+	for i := range b {
+		b[i] = byte(v >> uint(8*i))
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// Decode a little-endian hex register value.
+func decodeRegister(s string) (uint, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		return 0, err
+	}
+
+	v := uint(0)
+
+	for i := range b {
+		v |= uint(b[i]) << uint(8*i)
+	}
+
+	return v, nil
+}
+
+// Reply with all the registers.
+func (s *gdbSession) readRegisters() string {
+	var b strings.Builder
+
+	s.runner.Do(func(vm *CHIP_8) {
+		for n := 0; n < GDB_REG_COUNT; n++ {
+			b.WriteString(encodeRegister(gdbRegister(vm, n)))
+		}
+	})
+
+	return b.String()
+}
+
+// Set all the registers.
+func (s *gdbSession) writeRegisters(data string) string {
+	ok := true
+
+	s.runner.Do(func(vm *CHIP_8) {
+		for n := 0; n < GDB_REG_COUNT; n++ {
+			_, size := gdbRegister(vm, n)
+
+			if len(data) < size*2 {
+				ok = false
+				return
+			}
+
+			v, err := decodeRegister(data[:size*2])
+			if err != nil {
+				ok = false
+				return
+			}
+
+			setGDBRegister(vm, n, v)
+
+			data = data[size*2:]
+		}
+	})
+
+	if !ok {
+		return "E01"
+	}
+
+	return "OK"
+}
+
+// Reply with a single register.
+func (s *gdbSession) readRegister(args string) string {
+	n, err := strconv.ParseUint(args, 16, 8)
+	if err != nil || n >= GDB_REG_COUNT {
+		return "E01"
+	}
+
+	var reply string
+
+	s.runner.Do(func(vm *CHIP_8) { reply = encodeRegister(gdbRegister(vm, int(n))) })
+
+	return reply
+}
+
+// Set a single register.
+func (s *gdbSession) writeRegister(args string) string {
+	i := strings.IndexByte(args, '=')
+	if i < 0 {
+		return "E01"
+	}
+
+	n, err := strconv.ParseUint(args[:i], 16, 8)
+	if err != nil || n >= GDB_REG_COUNT {
+		return "E01"
+	}
+
+	v, err := decodeRegister(args[i+1:])
+	if err != nil {
+		return "E01"
+	}
+
+	s.runner.Do(func(vm *CHIP_8) { setGDBRegister(vm, int(n), v) })
+
+	return "OK"
+}
+
+// Parse an "address,length" pair.
+func parseRange(args string) (uint, uint, error) {
+	i := strings.IndexByte(args, ',')
+	if i < 0 {
+		return 0, 0, fmt.Errorf("missing length")
+	}
+
+	address, err := strconv.ParseUint(args[:i], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	length, err := strconv.ParseUint(args[i+1:], 16, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return uint(address), uint(length), nil
+}
+
+// Reply with a range of memory.
+func (s *gdbSession) readMemory(args string) string {
+	address, length, err := parseRange(args)
+	if err != nil || address >= 0x1000 {
+		return "E01"
+	}
+
+	// stop at the end of memory
+	if address+length > 0x1000 {
+		length = 0x1000 - address
+	}
+
+	var reply string
+
+	s.runner.Do(func(vm *CHIP_8) { reply = hex.EncodeToString(vm.Memory[address : address+length]) })
+
+	return reply
+}
+
+// Write to a range of memory.
+func (s *gdbSession) writeMemory(args string) string {
+	i := strings.IndexByte(args, ':')
+	if i < 0 {
+		return "E01"
+	}
+
+	address, length, err := parseRange(args[:i])
+	if err != nil || address+length > 0x1000 {
+		return "E01"
+	}
+
+	data, err := hex.DecodeString(args[i+1:])
+	if err != nil || uint(len(data)) != length {
+		return "E01"
+	}
+
+	s.runner.Do(func(vm *CHIP_8) {
+		for n, b := range data {
+			vm.Poke(address+uint(n), b)
+		}
+	})
+
+	return "OK"
+}
+
+// Insert or remove a breakpoint or watchpoint: "type,address,kind".
+func (s *gdbSession) setBreakpoint(insert bool, args string) string {
+	i := strings.IndexByte(args, ',')
+	if i < 0 {
+		return "E01"
+	}
+
+	address, length, err := parseRange(args[i+1:])
+	if err != nil || address >= 0x1000 {
+		return "E01"
+	}
+
+	// watched memory access
+	var access Access
+
+	switch args[:i] {
+	case "0", "1":
+		return s.setCodeBreakpoint(insert, int(address))
+	case "2":
+		access = ACCESS_WRITE
+	case "3":
+		access = ACCESS_READ
+	case "4":
+		access = ACCESS_READ | ACCESS_WRITE
+	default:
+		return ""
+	}
+
+	s.runner.Do(func(vm *CHIP_8) {
+		for a := address; a < address+length && a < 0x1000; a++ {
+			if insert {
+				vm.SetWatchpoint(a, access)
+				s.watchpoints[a] |= access
+				continue
+			}
+
+			vm.RemoveWatchpoint(a, access)
+
+			if s.watchpoints[a] &^= access; s.watchpoints[a] == 0 {
+				delete(s.watchpoints, a)
+			}
+		}
+	})
+
+	return "OK"
+}
+
+// Insert or remove a breakpoint at an address.
+func (s *gdbSession) setCodeBreakpoint(insert bool, address int) string {
+	ok := true
+
+	s.runner.Do(func(vm *CHIP_8) {
+		if !insert {
+			if s.breakpoints[address] {
+				vm.RemoveBreakpoint(address)
+				delete(s.breakpoints, address)
+			}
+
+			return
+		}
+
+		// don't replace a breakpoint from the assembler
+		if _, exists := vm.Breakpoints[address]; exists {
+			return
+		}
+
+		vm.SetBreakpoint(Breakpoint{Address: address, Reason: "GDB"})
+
+		if _, ok = vm.Breakpoints[address]; ok {
+			s.breakpoints[address] = true
+		}
+	})
+
+	if !ok {
+		return "E01"
+	}
+
+	return "OK"
+}
+
+// Reply to a qXfer read of a document with the requested "offset,length".
+func readXfer(doc, args string) string {
+	offset, length, err := parseRange(args)
+	if err != nil {
+		return "E01"
+	}
+
+	if offset >= uint(len(doc)) {
+		return "l"
+	}
+
+	// the final chunk is prefixed with "l"
+	if offset+length >= uint(len(doc)) {
+		return "l" + doc[offset:]
+	}
+
+	return "m" + doc[offset:offset+length]
+}
@@ -0,0 +1,210 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+// A loop that keeps writing to memory.
+const gdbProgram = `
+            ld          v0, 5
+            ld          i, data
+loop        add         v0, 1
+            ld          [i], v0
+            jp          loop
+data        byte        0
+`
+
+// A loop that reads and then writes memory.
+const gdbAccessProgram = `
+loop        ld          i, data
+            ld          v0, [i]
+            ld          [i], v0
+            jp          loop
+data        byte        0
+`
+
+// gdbClient speaks the GDB remote serial protocol to a session.
+type gdbClient struct {
+	t    *testing.T
+	conn net.Conn
+	in   *bufio.Reader
+
+	// done is closed once the session has detached.
+	done chan struct{}
+}
+
+// Connect a client to a new session debugging a runner.
+func connectGDB(t *testing.T, r *Runner) *gdbClient {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		newGDBSession(r, server).serve()
+	}()
+
+	return &gdbClient{t: t, conn: client, in: bufio.NewReader(client), done: done}
+}
+
+// Disconnect and wait for the session to detach.
+func (c *gdbClient) close() {
+	c.conn.Close()
+
+	<-c.done
+}
+
+// Send a packet and return the reply, failing if it isn't acknowledged.
+func (c *gdbClient) request(p string) string {
+	if _, err := fmt.Fprintf(c.conn, "$%s#%02x", p, checksum(p)); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if ack, err := c.in.ReadByte(); err != nil || ack != '+' {
+		c.t.Fatalf("%s: not acknowledged", p)
+	}
+
+	if _, err := c.in.ReadString('$'); err != nil {
+		c.t.Fatal(err)
+	}
+
+	reply, err := c.in.ReadString('#')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+
+	reply = reply[:len(reply)-1]
+
+	// checksum
+	sum := make([]byte, 2)
+
+	if _, err := c.in.Read(sum[:1]); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if _, err := c.in.Read(sum[1:]); err != nil {
+		c.t.Fatal(err)
+	}
+
+	if string(sum) != fmt.Sprintf("%02x", checksum(reply)) {
+		c.t.Fatalf("%s: bad checksum for reply %q", p, reply)
+	}
+
+	return reply
+}
+
+// Send a packet and fail if the reply isn't what's expected.
+func (c *gdbClient) expect(p, want string) {
+	if reply := c.request(p); reply != want {
+		c.t.Fatalf("%s: expected %q, got %q", p, want, reply)
+	}
+}
+
+// TestGDB runs a scripted session: stop reason, registers, memory,
+// breakpoints, watchpoints, continuing, and stepping.
+func TestGDB(t *testing.T) {
+	r := NewRunner(loadSource(t, gdbProgram))
+	defer r.Stop()
+
+	c := connectGDB(t, r)
+	defer c.close()
+
+	// connecting pauses before the first instruction
+	c.expect("?", "S05")
+
+	// V0-VF, I, PC, SP, DT, ST
+	zero := strings.Repeat("00", 16)
+
+	c.expect("g", zero+"0000"+"0002"+"000000")
+
+	// step over the first instruction
+	c.expect("s", "S05")
+	c.expect("g", "05"+strings.Repeat("00", 15)+"0000"+"0202"+"000000")
+
+	// write every register and read them back
+	regs := "0512" + strings.Repeat("00", 14) + "0a02" + "0202" + "000000"
+
+	c.expect("G"+regs, "OK")
+	c.expect("g", regs)
+
+	// memory
+	c.expect("m200,4", "6005a20a")
+	c.expect("M20a,1:7f", "OK")
+	c.expect("m20a,1", "7f")
+
+	// run to a breakpoint
+	c.expect("Z0,208,2", "OK")
+	c.expect("c", "T05swbreak:;")
+	c.expect("p11", "0802")
+	c.expect("z0,208,2", "OK")
+
+	// run until memory is written
+	c.expect("Z2,20a,1", "OK")
+	c.expect("c", "T05watch:20a;")
+	c.expect("p11", "0802")
+	c.expect("z2,20a,1", "OK")
+
+	// step from an address
+	c.expect("s204", "S05")
+	c.expect("p11", "0602")
+
+	c.expect("D", "OK")
+
+	<-c.done
+
+	// everything the client set is removed when it detaches
+	r.Do(func(vm *CHIP_8) {
+		if len(vm.Breakpoints) != 0 || len(vm.Watchpoints) != 0 {
+			t.Errorf("expected no breakpoints or watchpoints, got %v and %v", vm.Breakpoints, vm.Watchpoints)
+		}
+	})
+}
+
+// TestGDBWatchpoints checks each kind of watchpoint is reported as the
+// kind set.
+func TestGDBWatchpoints(t *testing.T) {
+	tests := []struct {
+		set, stop string
+	}{
+		{"Z2,208,1", "T05watch:208;"},
+		{"Z3,208,1", "T05rwatch:208;"},
+		{"Z4,208,1", "T05awatch:208;"},
+	}
+
+	for _, test := range tests {
+		r := NewRunner(loadSource(t, gdbAccessProgram))
+		c := connectGDB(t, r)
+
+		c.expect(test.set, "OK")
+		c.expect("c", test.stop)
+		c.expect("D", "OK")
+		c.close()
+
+		r.Stop()
+	}
+}
@@ -0,0 +1,134 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"fmt"
+)
+
+// Divergence is returned by Step the first time the mirror of a virtual
+// machine being compared no longer matches it.
+type Divergence struct {
+	// Address is the address of the instruction that caused it.
+	Address uint
+
+	// Diffs describe each register or memory that doesn't match.
+	Diffs []string
+
+	// Reason is the error the mirror stopped with, if it faulted.
+	Reason error
+}
+
+// Error implements the error interface for a Divergence.
+func (d Divergence) Error() string {
+	if d.Reason != nil {
+		return fmt.Sprintf("Divergence after #%04X: mirror faulted: %s", d.Address, d.Reason)
+	}
+
+	return fmt.Sprintf("Divergence after #%04X: %d differences", d.Address, len(d.Diffs))
+}
+
+// Compare runs a second copy of the program with different quirks in
+// lockstep with the virtual machine, sharing the same inputs. Both are
+// reset. The copy is returned.
+func (vm *CHIP_8) Compare(quirks Quirks) *CHIP_8 {
+	vm.Mirror = &CHIP_8{
+		ROM:         vm.ROM,
+		Size:        vm.Size,
+		Breakpoints: make(map[int]Breakpoint),
+		Base:        vm.Base,
+		Speed:       vm.Speed,
+		Seed:        vm.Seed,
+		Quirks:      quirks,
+		Predecode:   vm.Predecode,
+	}
+
+	// start both from the same state
+	vm.Reset()
+
+	return vm.Mirror
+}
+
+// StopComparing stops running the mirror of the virtual machine.
+func (vm *CHIP_8) StopComparing() {
+	vm.Mirror = nil
+	vm.Diverged = false
+}
+
+// Step the mirror after an instruction and compare it with the virtual
+// machine. Returns a Divergence the first time they differ.
+func (vm *CHIP_8) stepMirror(address uint) error {
+	m := vm.Mirror
+
+	// keep time and input in lockstep, even once diverged
+	err := m.Step()
+
+	if vm.Diverged {
+		return nil
+	}
+
+	// the mirror faulting is a divergence as well
+	if err != nil {
+		vm.Diverged = true
+
+		return Divergence{Address: address, Reason: err}
+	}
+
+	var diffs []string
+
+	// compare the registers
+	for i := range vm.V {
+		if vm.V[i] != m.V[i] {
+			diffs = append(diffs, fmt.Sprintf("V%X = #%02X / #%02X", i, vm.V[i], m.V[i]))
+		}
+	}
+
+	if vm.I != m.I {
+		diffs = append(diffs, fmt.Sprintf("I = #%04X / #%04X", vm.I, m.I))
+	}
+
+	if vm.PC != m.PC {
+		diffs = append(diffs, fmt.Sprintf("PC = #%04X / #%04X", vm.PC, m.PC))
+	}
+
+	// count the pixels that differ
+	pixels := 0
+
+	for i := range vm.Video {
+		for b := vm.Video[i] ^ m.Video[i]; b != 0; b &= b - 1 {
+			pixels++
+		}
+	}
+
+	if pixels > 0 || vm.Pitch != m.Pitch {
+		diffs = append(diffs, fmt.Sprintf("%d pixels differ", pixels))
+	}
+
+	if diffs == nil {
+		return nil
+	}
+
+	// only the first divergence is reported
+	vm.Diverged = true
+
+	return Divergence{Address: address, Diffs: diffs}
+}
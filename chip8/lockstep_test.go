@@ -0,0 +1,80 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"testing"
+)
+
+// Load a ROM and run a mirror of it with the jump quirk.
+func compareJump(t *testing.T, program []byte) *CHIP_8 {
+	vm, err := LoadROM(program, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm.Compare(Quirks{JumpVX: true})
+
+	return vm
+}
+
+// TestMirrorFault checks a fault in the mirror is reported as a divergence.
+func TestMirrorFault(t *testing.T) {
+	vm := compareJump(t, []byte{0x12, 0x00, 0x12, 0x00}) // JP #200, JP #200
+
+	// only the mirror runs an invalid instruction
+	vm.Mirror.Memory[0x200] = 0xFF
+	vm.Mirror.Memory[0x201] = 0xFF
+
+	if d, ok := vm.Step().(Divergence); !ok || d.Reason == nil {
+		t.Fatalf("expected a divergence with a reason, got %v", d)
+	}
+
+	// only the first divergence is reported
+	if err := vm.Step(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDivergenceOnceBreakpoint checks a one-time breakpoint is removed
+// even when the mirror diverges on the same instruction.
+func TestDivergenceOnceBreakpoint(t *testing.T) {
+	vm := compareJump(t, []byte{
+		0x62, 0x02, // LD V2, 2
+		0xB2, 0x08, // JP V0, #208 (mirror jumps to #20A)
+		0x00, 0x00,
+		0x00, 0x00,
+		0x12, 0x08, // JP #208
+		0x12, 0x0A, // JP #20A
+	})
+
+	vm.SetBreakpoint(Breakpoint{Address: 0x208, Once: true})
+	vm.Step()
+
+	if _, ok := vm.Step().(Divergence); !ok {
+		t.Fatal("expected a divergence")
+	}
+
+	if _, ok := vm.Breakpoints[0x208]; ok {
+		t.Fatal("expected the one-time breakpoint to be removed")
+	}
+}
@@ -0,0 +1,368 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+)
+
+// Type of input recorded in a movie.
+type EventKind uint
+
+// Inputs recorded in a movie.
+const (
+	EVENT_PRESS EventKind = iota
+	EVENT_RELEASE
+	EVENT_IDLE
+	EVENT_SPEED
+	EVENT_RESET
+	EVENT_END
+)
+
+// Movie is a recording of every input given to a CHIP-8 virtual machine
+// from the moment it was reset. Played back, it drives the VM through
+// exactly the same states.
+type Movie struct {
+	// ROM is the SHA-1 hash (in hex) of the program recorded.
+	ROM string
+
+	// Base is the address the program was loaded at.
+	Base uint
+
+	// Seed is the random number seed the VM was reset with.
+	Seed int64
+
+	// Speed is the instructions per second when recording began.
+	Speed int64
+
+	// Quirks are the interpreter behaviors the VM emulated.
+	Quirks Quirks
+
+	// Events are all the inputs in the order they happened.
+	Events []Event
+}
+
+// Event is a single input to the virtual machine.
+type Event struct {
+	// Step is the number of instructions executed before the input.
+	Step int64
+
+	// Frame is the 60 Hz frame of emulated time of the input. It is only
+	// informational; playback is synchronized on Step.
+	Frame int64
+
+	// Kind is what the input was.
+	Kind EventKind
+
+	// Value is the key pressed or released, ns of idle time, or speed.
+	// It is unused for resets and the end of the movie.
+	Value int64
+}
+
+// Player plays back a movie into a virtual machine.
+type Player struct {
+	// Movie is the movie being played.
+	Movie *Movie
+
+	// Next is the index of the next event to play.
+	Next int
+}
+
+var (
+	// EndOfMovie is returned once every event in a movie has been played.
+	EndOfMovie = errors.New("end of movie")
+
+	// eventNames are how each kind of event is written to a movie file.
+	eventNames = []string{"PRESS", "RELEASE", "IDLE", "SPEED", "RESET", "END"}
+)
+
+// Hash returns the SHA-1 (in hex) of the program loaded in the VM.
+func (vm *CHIP_8) Hash() string {
+	return fmt.Sprintf("%x", sha1.Sum(vm.ROM[vm.Base:vm.Base+uint(vm.Size)]))
+}
+
+// Record resets the virtual machine and begins recording all its inputs
+// to a new movie, which is returned.
+func (vm *CHIP_8) Record() *Movie {
+	vm.Recording = nil
+	vm.Playback = nil
+
+	// always record from a clean state
+	vm.Reset()
+
+	vm.Recording = &Movie{
+		ROM:    vm.Hash(),
+		Base:   vm.Base,
+		Seed:   vm.Seed,
+		Speed:  vm.Speed,
+		Quirks: vm.Quirks,
+		Events: make([]Event, 0, 1000),
+	}
+
+	return vm.Recording
+}
+
+// StopRecording ends recording and returns the movie recorded.
+func (vm *CHIP_8) StopRecording() *Movie {
+	m := vm.Recording
+
+	// mark where the movie ends so playback runs up to it
+	if m != nil {
+		m.record(vm, EVENT_END, 0)
+	}
+
+	// no longer recording
+	vm.Recording = nil
+
+	return m
+}
+
+// Play resets the virtual machine to the state a movie was recorded from
+// and begins playing it back. From then on, Process uses the inputs from
+// the movie instead of wall time and key presses.
+func (vm *CHIP_8) Play(m *Movie) error {
+	if m.ROM != vm.Hash() {
+		return errors.New("movie was recorded with a different ROM")
+	}
+
+	if m.Base != vm.Base {
+		return fmt.Errorf("movie was recorded with the ROM at #%04X", m.Base)
+	}
+
+	vm.Recording = nil
+	vm.Playback = nil
+
+	// restore the recorded settings
+	vm.Seed = m.Seed
+	vm.Speed = m.Speed
+	vm.Quirks = m.Quirks
+
+	// start from a clean state
+	vm.Reset()
+
+	vm.Playback = &Player{Movie: m}
+
+	return nil
+}
+
+// Replay plays back an entire movie into a virtual machine as fast as
+// possible, without any wall time pacing. Breakpoints and watchpoints are
+// ignored.
+func (m *Movie) Replay(vm *CHIP_8) error {
+	if err := vm.Play(m); err != nil {
+		return err
+	}
+
+	// play until there are no events left
+	for {
+		if err := vm.Playback.advance(vm); err != nil {
+			if isBreak(err) {
+				continue
+			}
+
+			// stop playing back
+			vm.Playback = nil
+
+			if err == EndOfMovie {
+				return nil
+			}
+
+			return err
+		}
+	}
+}
+
+// Add an input to the movie.
+func (m *Movie) record(vm *CHIP_8, kind EventKind, value int64) {
+	e := Event{
+		Step:  vm.Steps,
+		Frame: vm.Frame(),
+		Kind:  kind,
+		Value: value,
+	}
+
+	// idle time back to back can be combined
+	if kind == EVENT_IDLE && len(m.Events) > 0 {
+		last := &m.Events[len(m.Events)-1]
+
+		if last.Kind == EVENT_IDLE && last.Step == e.Step {
+			last.Value += value
+			return
+		}
+	}
+
+	m.Events = append(m.Events, e)
+}
+
+// Run the VM until the clock has caught up, using the movie for inputs.
+func (p *Player) process(vm *CHIP_8, count int64, paused bool) error {
+	if paused {
+		vm.Cycles = count
+	} else {
+		for vm.Cycles < count {
+			if err := p.advance(vm); err != nil {
+				if err == EndOfMovie {
+					vm.Playback = nil
+				}
+
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Play the next event if the VM has reached it, otherwise step the VM.
+func (p *Player) advance(vm *CHIP_8) error {
+	if p.Next >= len(p.Movie.Events) {
+		return EndOfMovie
+	}
+
+	e := p.Movie.Events[p.Next]
+
+	// execute instructions until the event is due
+	if vm.Steps < e.Step {
+		if vm.W != nil {
+			return fmt.Errorf("movie desynchronized at step %d", vm.Steps)
+		}
+
+		return vm.Step()
+	}
+
+	p.Next += 1
+
+	switch e.Kind {
+	case EVENT_PRESS:
+		vm.PressKey(uint(e.Value))
+	case EVENT_RELEASE:
+		vm.ReleaseKey(uint(e.Value))
+	case EVENT_IDLE:
+		vm.Time += e.Value
+		vm.Cycles += e.Value * vm.Speed / 1000000000
+
+		// the mirror passes the same time
+		if vm.Mirror != nil {
+			vm.Mirror.Time += e.Value
+			vm.Mirror.Cycles += e.Value * vm.Speed / 1000000000
+		}
+	case EVENT_SPEED:
+		vm.SetSpeed(e.Value)
+	case EVENT_RESET:
+		vm.Reset()
+	case EVENT_END:
+		return EndOfMovie
+	}
+
+	return nil
+}
+
+// Save writes the movie to a file.
+func (m *Movie) Save(file string) error {
+	var b bytes.Buffer
+
+	// write the header
+	fmt.Fprintln(&b, "CHIP-8 MOVIE 1")
+	fmt.Fprintln(&b, "ROM", m.ROM)
+	fmt.Fprintln(&b, "BASE", m.Base)
+	fmt.Fprintln(&b, "SEED", m.Seed)
+	fmt.Fprintln(&b, "SPEED", m.Speed)
+	fmt.Fprintln(&b, "QUIRKS", m.Quirks)
+
+	// write each event: step, frame, kind, value
+	for _, e := range m.Events {
+		fmt.Fprintln(&b, e.Step, e.Frame, eventNames[e.Kind], e.Value)
+	}
+
+	return ioutil.WriteFile(file, b.Bytes(), 0666)
+}
+
+// LoadMovie reads a movie file.
+func LoadMovie(file string) (*Movie, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	m := &Movie{}
+
+	// validate the signature
+	if !scanner.Scan() || scanner.Text() != "CHIP-8 MOVIE 1" {
+		return nil, errors.New("not a CHIP-8 movie")
+	}
+
+	for line := 2; scanner.Scan(); line++ {
+		var e Event
+		var kind string
+
+		s := scanner.Text()
+
+		// header fields come first
+		switch {
+		case strings.HasPrefix(s, "ROM "):
+			m.ROM = strings.TrimSpace(s[4:])
+		case strings.HasPrefix(s, "BASE "):
+			_, err = fmt.Sscan(s[5:], &m.Base)
+		case strings.HasPrefix(s, "SEED "):
+			_, err = fmt.Sscan(s[5:], &m.Seed)
+		case strings.HasPrefix(s, "SPEED "):
+			_, err = fmt.Sscan(s[6:], &m.Speed)
+		case strings.HasPrefix(s, "QUIRKS "):
+			m.Quirks, err = ParseQuirks(strings.TrimSpace(s[7:]))
+		default:
+			if _, err = fmt.Sscan(s, &e.Step, &e.Frame, &kind, &e.Value); err == nil {
+				if e.Kind, err = parseEventKind(kind); err == nil {
+					m.Events = append(m.Events, e)
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d - %s", line, err)
+		}
+	}
+
+	// a movie without a speed can't be played back
+	if m.Speed <= 0 {
+		return nil, errors.New("movie is missing its speed")
+	}
+
+	return m, nil
+}
+
+// Parse the name of an event kind.
+func parseEventKind(name string) (EventKind, error) {
+	for i, s := range eventNames {
+		if s == name {
+			return EventKind(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown event %s", name)
+}
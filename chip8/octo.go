@@ -0,0 +1,913 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A token of Octo source and the line it's on.
+type octoToken struct {
+	s    string
+	line int
+}
+
+// An Octo macro, which expands to its body with the arguments replaced.
+type octoMacro struct {
+	args []string
+	body []octoToken
+}
+
+// A loop being assembled, with the jumps out of it to resolve at again.
+type octoLoop struct {
+	start  int
+	whiles []int
+}
+
+// Octo source being assembled.
+type octoAssembler struct {
+	*Assembly
+
+	// tokens is the source left to assemble in reverse order, so that
+	// macros are expanded by pushing their bodies onto the end.
+	tokens []octoToken
+
+	// line is the line of the last token scanned.
+	line int
+
+	macros map[string]octoMacro
+
+	// jumps of if blocks and loops waiting on end and again
+	ifs   []int
+	loops []octoLoop
+
+	// expansions counts macro expansions to stop recursive macros.
+	expansions int
+}
+
+// The conditions that negate each other.
+var octoNegations = map[string]string{
+	"==":   "!=",
+	"!=":   "==",
+	"<":    ">=",
+	">=":   "<",
+	">":    "<=",
+	"<=":   ">",
+	"key":  "-key",
+	"-key": "key",
+}
+
+// AssembleOcto assembles Octo source into the same assembly Assemble
+// creates from C8 source. Execution begins at the label main; if the
+// program doesn't begin with it, a jump to main is placed first.
+func AssembleOcto(program []byte, eti bool) (out *Assembly, err error) {
+	base := 0x200
+
+	// ETI-660 binaries are loaded to 0x600
+	if eti {
+		base = 0x600
+	}
+
+	out = &Assembly{
+		ROM:         make([]byte, base, 0x1000),
+		Breakpoints: make([]Breakpoint, 0, 10),
+		Labels:      make(map[string]token),
+		Unresolved:  make(map[int]string),
+		Lines:       make(map[int]int),
+		Base:        base,
+	}
+
+	o := &octoAssembler{
+		Assembly: out,
+		tokens:   reverseOcto(scanOcto(program)),
+		macros:   make(map[string]octoMacro),
+	}
+
+	// handle panics during assembly
+	defer func() {
+		if r := recover(); r != nil {
+			if o.line > 0 {
+				err = fmt.Errorf("line %d - %s", o.line, r)
+			} else {
+				err = fmt.Errorf("%s", r)
+			}
+
+			// return a dummy ROM
+			out = &Assembly{ROM: Dummy}
+		}
+	}()
+
+	o.assemble()
+
+	// resolve all label addresses
+	out.resolve()
+
+	// clear the line number as we're done assembling
+	o.line = 0
+
+	// if there are any unresolved addresses, panic
+	for _, label := range out.Unresolved {
+		panic(fmt.Errorf("unresolved label: %s", label))
+	}
+
+	// drop the first 512 bytes from the rom
+	out.ROM = out.ROM[base:]
+
+	return
+}
+
+// Split Octo source into tokens, dropping comments.
+func scanOcto(program []byte) []octoToken {
+	tokens := make([]octoToken, 0, len(program)/4)
+
+	for n, line := range strings.Split(string(program), "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+
+		// braces and parentheses are tokens of their own
+		for _, c := range []string{"{", "}", "(", ")"} {
+			line = strings.Replace(line, c, " "+c+" ", -1)
+		}
+
+		for _, s := range strings.Fields(line) {
+			tokens = append(tokens, octoToken{s: s, line: n + 1})
+		}
+	}
+
+	return tokens
+}
+
+// Reverse the order of tokens.
+func reverseOcto(tokens []octoToken) []octoToken {
+	for i, j := 0, len(tokens)-1; i < j; i, j = i+1, j-1 {
+		tokens[i], tokens[j] = tokens[j], tokens[i]
+	}
+
+	return tokens
+}
+
+// Assemble all the statements in the source.
+func (o *octoAssembler) assemble() {
+	if !o.startsWithMain() {
+		o.emitAddress(0x1000, "main")
+	}
+
+	for len(o.tokens) > 0 {
+		address := len(o.ROM)
+		line := o.peek(0).line
+
+		o.statement(o.next())
+
+		// remember where the line was assembled to
+		if len(o.ROM) > address {
+			if _, ok := o.Lines[address]; !ok {
+				o.Lines[address] = line
+			}
+		}
+	}
+
+	if len(o.ifs) > 0 {
+		panic("begin without end")
+	}
+
+	if len(o.loops) > 0 {
+		panic("loop without again")
+	}
+}
+
+// True if the label main comes before any code or data.
+func (o *octoAssembler) startsWithMain() bool {
+	for i := 0; i+1 < len(o.tokens); {
+		switch o.peek(i).s {
+		case ":":
+			return o.peek(i+1).s == "main"
+		case ":const", ":alias":
+			i += 3
+		case ":macro", ":calc":
+			for i < len(o.tokens) && o.peek(i).s != "{" {
+				i++
+			}
+
+			i = o.skipBlock(i)
+		default:
+			return false
+		}
+	}
+
+	return false
+}
+
+// Find the token after the closing brace of a block that opens i tokens
+// ahead.
+func (o *octoAssembler) skipBlock(i int) int {
+	depth := 0
+
+	for ; i < len(o.tokens); i++ {
+		switch o.peek(i).s {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return i + 1
+			}
+		}
+	}
+
+	return i
+}
+
+// Look at a token ahead without scanning it. Past the end of the source
+// is an empty token.
+func (o *octoAssembler) peek(i int) octoToken {
+	if i >= len(o.tokens) {
+		return octoToken{}
+	}
+
+	return o.tokens[len(o.tokens)-1-i]
+}
+
+// Scan the next token.
+func (o *octoAssembler) next() string {
+	if len(o.tokens) == 0 {
+		panic("unexpected end of source")
+	}
+
+	t := o.tokens[len(o.tokens)-1]
+
+	o.tokens = o.tokens[:len(o.tokens)-1]
+	o.line = t.line
+
+	return t.s
+}
+
+// Scan the next token, which must be a given token.
+func (o *octoAssembler) expect(s string) {
+	if t := o.next(); t != s {
+		panic(fmt.Sprintf("expected %s, found %s", s, t))
+	}
+}
+
+// Scan the tokens of a block in braces.
+func (o *octoAssembler) block() []octoToken {
+	o.expect("{")
+
+	tokens := make([]octoToken, 0, 16)
+
+	for depth := 1; ; {
+		if len(o.tokens) == 0 {
+			panic("{ without }")
+		}
+
+		t := o.peek(0)
+		o.next()
+
+		switch t.s {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth == 0 {
+				return tokens
+			}
+		}
+
+		tokens = append(tokens, t)
+	}
+}
+
+// Assemble a single statement.
+func (o *octoAssembler) statement(s string) {
+	switch s {
+	case ":":
+		o.label(o.next(), token{typ: TOKEN_LIT, val: len(o.ROM)})
+	case ":const":
+		name := o.next()
+		o.label(name, token{typ: TOKEN_LIT, val: o.value(o.next())})
+	case ":alias":
+		name := o.next()
+		o.label(name, token{typ: TOKEN_V, val: int(o.register(o.next()))})
+	case ":calc":
+		name := o.next()
+		o.label(name, token{typ: TOKEN_LIT, val: o.calc(o.block())})
+	case ":macro":
+		o.macro()
+	case ":byte":
+		if o.peek(0).s == "{" {
+			o.emit(byte(o.calc(o.block())))
+		} else {
+			o.emit(o.byteValue(o.next()))
+		}
+	case ":org":
+		o.org(o.value(o.next()))
+	case ":call":
+		o.emitAddress(0x2000, o.next())
+	case ":unpack":
+		o.unpack()
+	case ":breakpoint":
+		o.Breakpoints = append(o.Breakpoints, Breakpoint{Address: len(o.ROM), Reason: o.next()})
+	case "clear":
+		o.emit(0x00, 0xE0)
+	case "return", ";":
+		o.emit(0x00, 0xEE)
+	case "scroll-down":
+		o.super(0x00, 0xC0|byte(o.nibble(o.next())))
+	case "scroll-right":
+		o.super(0x00, 0xFB)
+	case "scroll-left":
+		o.super(0x00, 0xFC)
+	case "exit":
+		o.super(0x00, 0xFD)
+	case "lores":
+		o.super(0x00, 0xFE)
+	case "hires":
+		o.super(0x00, 0xFF)
+	case "jump":
+		o.emitAddress(0x1000, o.next())
+	case "jump0":
+		o.emitAddress(0xB000, o.next())
+	case "sprite":
+		x, y := o.register(o.next()), o.register(o.next())
+		o.emit(0xD0|x, y<<4|byte(o.nibble(o.next())))
+	case "bcd":
+		o.emit(0xF0|o.register(o.next()), 0x33)
+	case "save":
+		o.emit(0xF0|o.register(o.next()), 0x55)
+	case "load":
+		o.emit(0xF0|o.register(o.next()), 0x65)
+	case "saveflags":
+		o.super(0xF0|o.flags(o.next()), 0x75)
+	case "loadflags":
+		o.super(0xF0|o.flags(o.next()), 0x85)
+	case "audio":
+		o.emit(0xF0, 0x02)
+	case "delay":
+		o.expect(":=")
+		o.emit(0xF0|o.register(o.next()), 0x15)
+	case "buzzer":
+		o.expect(":=")
+		o.emit(0xF0|o.register(o.next()), 0x18)
+	case "pitch":
+		o.expect(":=")
+		o.emit(0xF0|o.register(o.next()), 0x3A)
+	case "i":
+		o.assignI()
+	case "if":
+		o.conditional()
+	case "else":
+		o.elseBlock()
+	case "end":
+		o.endBlock()
+	case "loop":
+		o.loops = append(o.loops, octoLoop{start: len(o.ROM)})
+	case "while":
+		o.while()
+	case "again":
+		o.again()
+	default:
+		if _, ok := o.isRegister(s); ok {
+			o.assignV(s)
+		} else if _, ok := o.macros[s]; ok {
+			o.expand(s)
+		} else if _, err := parseOctoNumber(s); err == nil {
+			o.emit(o.byteValue(s))
+		} else if strings.HasPrefix(s, ":") {
+			panic(fmt.Sprintf("unknown directive: %s", s))
+		} else {
+			o.emitAddress(0x2000, s)
+		}
+	}
+}
+
+// Define a label, constant, or alias.
+func (o *octoAssembler) label(name string, t token) {
+	if _, exists := o.Labels[name]; exists {
+		panic(fmt.Sprintf("duplicate label: %s", name))
+	}
+
+	o.Labels[name] = t
+}
+
+// Emit bytes to the ROM.
+func (o *octoAssembler) emit(b ...byte) {
+	if len(o.ROM)+len(b) > 0x1000 {
+		panic("program too large")
+	}
+
+	o.ROM = append(o.ROM, b...)
+}
+
+// Emit a SCHIP instruction.
+func (o *octoAssembler) super(b ...byte) {
+	o.Super = true
+	o.emit(b...)
+}
+
+// Emit an instruction with a 12-bit address operand, which may be a label
+// that isn't defined yet.
+func (o *octoAssembler) emitAddress(op uint, s string) {
+	address := 0
+
+	if n, err := parseOctoNumber(s); err == nil {
+		address = n
+	} else if t, ok := o.Labels[s]; ok && t.typ == TOKEN_LIT {
+		address = t.val.(int)
+	} else if ok {
+		panic(fmt.Sprintf("not an address: %s", s))
+	} else {
+		o.Unresolved[len(o.ROM)] = s
+	}
+
+	if address < 0 || address > 0xFFF {
+		panic(fmt.Sprintf("address out of range: %s", s))
+	}
+
+	op |= uint(address)
+
+	o.emit(byte(op>>8), byte(op))
+}
+
+// Patch the address of a jump that was emitted before its target was
+// known.
+func (o *octoAssembler) patch(at int) {
+	o.ROM[at] = 0x10 | byte(len(o.ROM)>>8)
+	o.ROM[at+1] = byte(len(o.ROM))
+}
+
+// Get the V register named by a token, if it is one.
+func (o *octoAssembler) isRegister(s string) (byte, bool) {
+	if len(s) == 2 && (s[0] == 'v' || s[0] == 'V') {
+		if n, err := strconv.ParseUint(s[1:], 16, 8); err == nil {
+			return byte(n), true
+		}
+	}
+
+	if t, ok := o.Labels[s]; ok && t.typ == TOKEN_V {
+		return byte(t.val.(int)), true
+	}
+
+	return 0, false
+}
+
+// Get the V register named by a token.
+func (o *octoAssembler) register(s string) byte {
+	if r, ok := o.isRegister(s); ok {
+		return r
+	}
+
+	panic(fmt.Sprintf("expected a register, found %s", s))
+}
+
+// Get the V register for saveflags and loadflags, which only has 8 flags.
+func (o *octoAssembler) flags(s string) byte {
+	if r := o.register(s); r < 8 {
+		return r
+	}
+
+	panic("only v0-v7 can be saved to flags")
+}
+
+// Parse an Octo number literal: decimal, 0x hex, or 0b binary.
+func parseOctoNumber(s string) (int, error) {
+	neg := strings.HasPrefix(s, "-")
+	digits := strings.TrimPrefix(s, "-")
+
+	var n int64
+	var err error
+
+	switch {
+	case strings.HasPrefix(digits, "0x"), strings.HasPrefix(digits, "0X"):
+		n, err = strconv.ParseInt(digits[2:], 16, 32)
+	case strings.HasPrefix(digits, "0b"), strings.HasPrefix(digits, "0B"):
+		n, err = strconv.ParseInt(digits[2:], 2, 32)
+	default:
+		n, err = strconv.ParseInt(digits, 10, 32)
+	}
+
+	if neg {
+		n = -n
+	}
+
+	return int(n), err
+}
+
+// Get the value of a number or constant.
+func (o *octoAssembler) value(s string) int {
+	if n, err := parseOctoNumber(s); err == nil {
+		return n
+	}
+
+	if t, ok := o.Labels[s]; ok && t.typ == TOKEN_LIT {
+		return t.val.(int)
+	}
+
+	panic(fmt.Sprintf("unknown constant: %s", s))
+}
+
+// Get the value of a byte, which may be signed.
+func (o *octoAssembler) byteValue(s string) byte {
+	n := o.value(s)
+
+	if n < -128 || n > 255 {
+		panic(fmt.Sprintf("byte out of range: %s", s))
+	}
+
+	return byte(n)
+}
+
+// Get the value of a nibble.
+func (o *octoAssembler) nibble(s string) int {
+	n := o.value(s)
+
+	if n < 0 || n > 15 {
+		panic(fmt.Sprintf("nibble out of range: %s", s))
+	}
+
+	return n
+}
+
+// Assemble an assignment to a V register.
+func (o *octoAssembler) assignV(s string) {
+	x := o.register(s)
+	op := o.next()
+	rhs := o.next()
+
+	y, isReg := o.isRegister(rhs)
+
+	switch {
+	case op == ":=" && isReg:
+		o.emit(0x80|x, y<<4)
+	case op == ":=" && rhs == "random":
+		o.emit(0xC0|x, o.byteValue(o.next()))
+	case op == ":=" && rhs == "key":
+		o.emit(0xF0|x, 0x0A)
+	case op == ":=" && rhs == "delay":
+		o.emit(0xF0|x, 0x07)
+	case op == ":=":
+		o.emit(0x60|x, o.byteValue(rhs))
+	case op == "+=" && isReg:
+		o.emit(0x80|x, y<<4|0x4)
+	case op == "+=":
+		o.emit(0x70|x, o.byteValue(rhs))
+	case op == "-=" && isReg:
+		o.emit(0x80|x, y<<4|0x5)
+	case op == "-=":
+		o.emit(0x70|x, -o.byteValue(rhs))
+	case op == "=-" && isReg:
+		o.emit(0x80|x, y<<4|0x7)
+	case op == "|=" && isReg:
+		o.emit(0x80|x, y<<4|0x1)
+	case op == "&=" && isReg:
+		o.emit(0x80|x, y<<4|0x2)
+	case op == "^=" && isReg:
+		o.emit(0x80|x, y<<4|0x3)
+	case op == ">>=" && isReg:
+		o.emit(0x80|x, y<<4|0x6)
+	case op == "<<=" && isReg:
+		o.emit(0x80|x, y<<4|0xE)
+	default:
+		panic(fmt.Sprintf("illegal operation: %s %s %s", s, op, rhs))
+	}
+}
+
+// Assemble an assignment to I.
+func (o *octoAssembler) assignI() {
+	switch op := o.next(); op {
+	case ":=":
+		switch rhs := o.next(); rhs {
+		case "hex":
+			o.emit(0xF0|o.register(o.next()), 0x29)
+		case "bighex":
+			o.super(0xF0|o.register(o.next()), 0x30)
+		default:
+			o.emitAddress(0xA000, rhs)
+		}
+	case "+=":
+		o.emit(0xF0|o.register(o.next()), 0x1E)
+	default:
+		panic(fmt.Sprintf("illegal operation: i %s", op))
+	}
+}
+
+// Assemble the instructions of a condition, which skip the following
+// instruction unless the condition holds, or - if negated - when it holds.
+func (o *octoAssembler) condition(negate bool) {
+	x := o.register(o.next())
+	op := o.next()
+
+	cmp, ok := octoNegations[op]
+	if !ok {
+		panic(fmt.Sprintf("unknown comparison: %s", op))
+	}
+
+	if !negate {
+		cmp = op
+	}
+
+	// key tests have no right hand side
+	switch cmp {
+	case "key":
+		o.emit(0xE0|x, 0xA1)
+		return
+	case "-key":
+		o.emit(0xE0|x, 0x9E)
+		return
+	}
+
+	rhs := o.next()
+	y, isReg := o.isRegister(rhs)
+
+	switch cmp {
+	case "==":
+		if isReg {
+			o.emit(0x90|x, y<<4)
+		} else {
+			o.emit(0x40|x, o.byteValue(rhs))
+		}
+	case "!=":
+		if isReg {
+			o.emit(0x50|x, y<<4)
+		} else {
+			o.emit(0x30|x, o.byteValue(rhs))
+		}
+	default:
+		if isReg {
+			o.emit(0x8F, y<<4)
+		} else {
+			o.emit(0x6F, o.byteValue(rhs))
+		}
+
+		// VF is set if VX >= the right hand side, or the other way
+		if cmp == "<" || cmp == ">=" {
+			o.emit(0x8F, x<<4|0x7)
+		} else {
+			o.emit(0x8F, x<<4|0x5)
+		}
+
+		// skip when the wrong one is set
+		if cmp == "<" || cmp == ">" {
+			o.emit(0x4F, 0x00)
+		} else {
+			o.emit(0x3F, 0x00)
+		}
+	}
+}
+
+// Assemble if ... then or if ... begin.
+func (o *octoAssembler) conditional() {
+	i := 0
+
+	// find out which kind of if it is
+	for i < len(o.tokens) && o.peek(i).s != "then" && o.peek(i).s != "begin" {
+		i++
+	}
+
+	if i >= len(o.tokens) {
+		panic("if without then or begin")
+	}
+
+	begin := o.peek(i).s == "begin"
+
+	o.condition(begin)
+	o.next()
+
+	// a block skips over the jump past it
+	if begin {
+		o.ifs = append(o.ifs, len(o.ROM))
+		o.emit(0x10, 0x00)
+	}
+}
+
+// Assemble the else of an if ... begin block.
+func (o *octoAssembler) elseBlock() {
+	if len(o.ifs) == 0 {
+		panic("else without begin")
+	}
+
+	at := o.ifs[len(o.ifs)-1]
+
+	// jump over the else block, then have the if jump into it
+	o.ifs[len(o.ifs)-1] = len(o.ROM)
+	o.emit(0x10, 0x00)
+	o.patch(at)
+}
+
+// Assemble the end of an if ... begin block.
+func (o *octoAssembler) endBlock() {
+	if len(o.ifs) == 0 {
+		panic("end without begin")
+	}
+
+	o.patch(o.ifs[len(o.ifs)-1])
+	o.ifs = o.ifs[:len(o.ifs)-1]
+}
+
+// Assemble a while, which leaves the loop unless the condition holds.
+func (o *octoAssembler) while() {
+	if len(o.loops) == 0 {
+		panic("while without loop")
+	}
+
+	o.condition(true)
+
+	loop := &o.loops[len(o.loops)-1]
+	loop.whiles = append(loop.whiles, len(o.ROM))
+
+	o.emit(0x10, 0x00)
+}
+
+// Assemble the again at the end of a loop.
+func (o *octoAssembler) again() {
+	if len(o.loops) == 0 {
+		panic("again without loop")
+	}
+
+	loop := o.loops[len(o.loops)-1]
+	o.loops = o.loops[:len(o.loops)-1]
+
+	o.emit(0x10|byte(loop.start>>8), byte(loop.start))
+
+	for _, at := range loop.whiles {
+		o.patch(at)
+	}
+}
+
+// Define a macro.
+func (o *octoAssembler) macro() {
+	name := o.next()
+	args := make([]string, 0, 4)
+
+	for len(o.tokens) > 0 && o.peek(0).s != "{" {
+		args = append(args, o.next())
+	}
+
+	if _, exists := o.macros[name]; exists {
+		panic(fmt.Sprintf("duplicate macro: %s", name))
+	}
+
+	o.macros[name] = octoMacro{args: args, body: o.block()}
+}
+
+// Expand a macro in place of its arguments.
+func (o *octoAssembler) expand(name string) {
+	m := o.macros[name]
+	line := o.line
+
+	if o.expansions++; o.expansions > 100000 {
+		panic(fmt.Sprintf("macro expands forever: %s", name))
+	}
+
+	// the tokens given for each argument
+	args := make(map[string]string, len(m.args))
+
+	for _, arg := range m.args {
+		args[arg] = o.next()
+	}
+
+	// push the body in reverse so it's scanned next
+	for i := len(m.body) - 1; i >= 0; i-- {
+		t := m.body[i]
+
+		if s, ok := args[t.s]; ok {
+			t.s = s
+		}
+
+		// errors in the body are reported where it's used
+		t.line = line
+
+		o.tokens = append(o.tokens, t)
+	}
+}
+
+// Move the address code is assembled to, padding with zeros.
+func (o *octoAssembler) org(address int) {
+	if address < len(o.ROM) || address > 0x1000 {
+		panic(fmt.Sprintf("can't move back or past the end of memory: %04X", address))
+	}
+
+	o.ROM = append(o.ROM, make([]byte, address-len(o.ROM))...)
+}
+
+// Assemble :unpack, which loads V0 and V1 with the high and low bytes of
+// an address, with a nibble in the high bits of V0.
+func (o *octoAssembler) unpack() {
+	n := o.nibble(o.next())
+	address := o.value(o.next())
+
+	o.emit(0x60, byte(n<<4|address>>8&0xF))
+	o.emit(0x61, byte(address))
+}
+
+// Evaluate a :calc expression. Like Octo, binary operators have no
+// precedence and are evaluated right to left.
+func (o *octoAssembler) calc(tokens []octoToken) int {
+	pos := 0
+
+	var expr func() float64
+	var term func() float64
+
+	next := func() string {
+		if pos >= len(tokens) {
+			panic("incomplete expression")
+		}
+
+		pos++
+		return tokens[pos-1].s
+	}
+
+	term = func() float64 {
+		switch s := next(); s {
+		case "(":
+			v := expr()
+
+			if next() != ")" {
+				panic("( without )")
+			}
+
+			return v
+		case "-":
+			return -term()
+		case "~":
+			return float64(^int(term()))
+		case "!":
+			if term() == 0 {
+				return 1
+			}
+			return 0
+		case "HERE":
+			return float64(len(o.ROM))
+		default:
+			return float64(o.value(s))
+		}
+	}
+
+	expr = func() float64 {
+		lhs := term()
+
+		if pos >= len(tokens) || tokens[pos].s == ")" {
+			return lhs
+		}
+
+		op := next()
+		rhs := expr()
+
+		switch op {
+		case "+":
+			return lhs + rhs
+		case "-":
+			return lhs - rhs
+		case "*":
+			return lhs * rhs
+		case "/":
+			if rhs == 0 {
+				panic("division by zero")
+			}
+			return lhs / rhs
+		case "%":
+			if int(rhs) == 0 {
+				panic("division by zero")
+			}
+			return float64(int(lhs) % int(rhs))
+		case "&":
+			return float64(int(lhs) & int(rhs))
+		case "|":
+			return float64(int(lhs) | int(rhs))
+		case "^":
+			return float64(int(lhs) ^ int(rhs))
+		case "<<":
+			return float64(int(lhs) << uint(rhs))
+		case ">>":
+			return float64(int(lhs) >> uint(rhs))
+		}
+
+		panic(fmt.Sprintf("unknown operator: %s", op))
+	}
+
+	v := expr()
+
+	if pos < len(tokens) {
+		panic(fmt.Sprintf("unexpected %s", tokens[pos].s))
+	}
+
+	return int(v)
+}
@@ -0,0 +1,132 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestAssembleOcto assembles Octo source using most of the language.
+func TestAssembleOcto(t *testing.T) {
+	source := `
+:const SPEED 2
+:alias x v3
+:calc HALF { 64 / 2 }
+:macro bump reg amount { reg += amount }
+
+: main
+	x := 0
+	bump x SPEED
+	loop
+		x += 1
+		while x != HALF
+		if x key then v0 := 1
+	again
+	if v0 > 5 begin
+		i := sprite
+	else
+		clear
+	end
+	draw
+	:unpack 0xA 0x345
+	:org 0x22A
+	jump main
+
+: draw
+	sprite v0 x 1
+	;
+
+: sprite
+	0b10000000 :byte { HALF - 1 } -1
+`
+
+	asm, err := AssembleOcto([]byte(source), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []byte{
+		0x63, 0x00, // x := 0
+		0x73, 0x02, // x += SPEED
+		0x73, 0x01, // x += 1
+		0x43, 0x20, // while x != HALF
+		0x12, 0x10,
+		0xE3, 0xA1, // if x key then
+		0x60, 0x01,
+		0x12, 0x04, // again
+		0x6F, 0x05, // if v0 > 5 begin
+		0x8F, 0x05,
+		0x3F, 0x00,
+		0x12, 0x1C,
+		0xA2, 0x30, // i := sprite
+		0x12, 0x1E, // else
+		0x00, 0xE0, // clear
+		0x22, 0x2C, // draw
+		0x60, 0xA3, // :unpack
+		0x61, 0x45,
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, // :org
+		0x12, 0x00, // jump main
+		0xD0, 0x31, // draw
+		0x00, 0xEE,
+		0x80, 0x1F, 0xFF, // sprite
+	}
+
+	if !bytes.Equal(asm.ROM, want) {
+		t.Fatalf("got % X\nwant % X", asm.ROM, want)
+	}
+
+	// labels and aliases are kept for the debugger
+	if s := asm.Symbols()[0x22C]; s != "draw" {
+		t.Errorf("draw is %q", s)
+	}
+
+	if s := asm.Aliases()[3]; s != "x" {
+		t.Errorf("v3 is %q", s)
+	}
+
+	if n := asm.Lines[0x204]; n != 11 {
+		t.Errorf("x += 1 is on line %d", n)
+	}
+}
+
+// TestAssembleOctoMain checks that a jump to main is added when the
+// program doesn't start with it.
+func TestAssembleOctoMain(t *testing.T) {
+	asm, err := AssembleOcto([]byte(": data 1 2\n: main jump main"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []byte{0x12, 0x04, 0x01, 0x02, 0x12, 0x04}; !bytes.Equal(asm.ROM, want) {
+		t.Fatalf("got % X, want % X", asm.ROM, want)
+	}
+
+	// errors are reported with the line
+	if _, err := AssembleOcto([]byte(": main\nv0 := 256"), false); err == nil || err.Error() != "line 2 - byte out of range: 256" {
+		t.Errorf("got error %v", err)
+	}
+
+	if _, err := AssembleOcto([]byte(": main\nloop"), false); err == nil {
+		t.Error("loop without again assembled")
+	}
+}
@@ -0,0 +1,491 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Profile counts how many times each instruction is executed, and
+// attributes every instruction to the subroutine (CALL target) it was
+// executed in, using the call stack.
+type Profile struct {
+	// Counts is how many times the instruction at each address executed.
+	Counts [0x1000]int64
+
+	// Cycles is how many instructions have been profiled.
+	Cycles int64
+
+	// Symbols names addresses in reports.
+	Symbols map[uint]string
+
+	// root is the call tree node for code not in any subroutine.
+	root *callNode
+
+	// stack is the path through the call tree to the current subroutine.
+	stack []*callNode
+}
+
+// callNode is a subroutine in the call tree, called from a call site.
+type callNode struct {
+	// target is the address of the subroutine.
+	target uint
+
+	// site is the address of the CALL instruction, unused by the root.
+	site uint
+
+	// calls is how many times the site called the subroutine.
+	calls int64
+
+	// self counts the instructions executed in the subroutine itself.
+	self map[uint]int64
+
+	// children are the subroutines called, by call site.
+	children map[uint]*callNode
+}
+
+// Subroutine is the profile of a single subroutine, combined over all
+// the places it was called from.
+type Subroutine struct {
+	// Address is the target of CALL instructions.
+	Address uint
+
+	// Name is the label of the subroutine, or its address.
+	Name string
+
+	// Calls is how many times the subroutine was called.
+	Calls int64
+
+	// Inclusive is how many instructions were executed in the subroutine
+	// and all the subroutines it called.
+	Inclusive int64
+
+	// Exclusive is how many instructions were executed in the subroutine
+	// itself.
+	Exclusive int64
+}
+
+// StartProfiling creates a new profile that every instruction executed
+// is added to until profiling is stopped.
+func (vm *CHIP_8) StartProfiling() *Profile {
+	p := &Profile{
+		Symbols: vm.Symbols,
+		root:    newCallNode(vm.Base, 0),
+	}
+
+	p.stack = []*callNode{p.root}
+
+	// subroutines already called are found through the return addresses
+	frames := vm.CallStack()
+
+	for i := len(frames) - 1; i >= 0; i-- {
+		top := p.stack[len(p.stack)-1]
+
+		p.stack = append(p.stack, top.child(frames[i].Site, frames[i].Callee))
+	}
+
+	vm.Profiler = p
+
+	return p
+}
+
+// StopProfiling returns the profile being collected and stops adding to it.
+func (vm *CHIP_8) StopProfiling() *Profile {
+	p := vm.Profiler
+
+	vm.Profiler = nil
+
+	return p
+}
+
+// Create a call tree node for a subroutine called from a site.
+func newCallNode(target, site uint) *callNode {
+	return &callNode{
+		target:   target,
+		site:     site,
+		self:     make(map[uint]int64),
+		children: make(map[uint]*callNode),
+	}
+}
+
+// Return the node for a subroutine called from a site, creating it if
+// this is the first call.
+func (n *callNode) child(site, target uint) *callNode {
+	c, ok := n.children[site]
+
+	if !ok || c.target != target {
+		c = newCallNode(target, site)
+
+		n.children[site] = c
+	}
+
+	return c
+}
+
+// Add an instruction just executed at pc to the profile, then follow any
+// subroutine called or returned from.
+func (p *Profile) sample(vm *CHIP_8, pc uint) {
+	pc &= 0xFFF
+
+	p.Counts[pc] += 1
+	p.Cycles += 1
+
+	// the instruction belongs to the subroutine it ran in
+	p.stack[len(p.stack)-1].self[pc] += 1
+
+	// returned from subroutines
+	for len(p.stack) > int(vm.SP)+1 {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+
+	// called a subroutine
+	for len(p.stack) < int(vm.SP)+1 {
+		c := p.stack[len(p.stack)-1].child(pc, vm.PC)
+		c.calls += 1
+
+		p.stack = append(p.stack, c)
+	}
+}
+
+// Return to the root of the call tree after a reset.
+func (p *Profile) reset() {
+	p.stack = p.stack[:1]
+}
+
+// Name returns the label at an address, or the address if there isn't one.
+func (p *Profile) Name(address uint) string {
+	if s, ok := p.Symbols[address]; ok {
+		return s
+	}
+
+	return fmt.Sprintf("#%04X", address)
+}
+
+// Location returns the nearest label at or before an address plus the
+// offset from it, or "" if there is no label before it.
+func (p *Profile) Location(address uint) string {
+	return location(p.Symbols, address)
+}
+
+// HotSpots returns the n most executed addresses, most executed first.
+func (p *Profile) HotSpots(n int) []uint {
+	hot := make([]uint, 0, len(p.Counts))
+
+	for address, count := range p.Counts {
+		if count > 0 {
+			hot = append(hot, uint(address))
+		}
+	}
+
+	sort.SliceStable(hot, func(i, j int) bool {
+		return p.Counts[hot[i]] > p.Counts[hot[j]]
+	})
+
+	if n >= 0 && n < len(hot) {
+		hot = hot[:n]
+	}
+
+	return hot
+}
+
+// Subroutines returns the profile of every subroutine called, with the
+// most inclusive cycles first. Code outside of any subroutine is
+// reported as the subroutine at the program's base address.
+func (p *Profile) Subroutines() []Subroutine {
+	subs := make(map[uint]*Subroutine)
+
+	// walk the call tree, returning the instructions under each node
+	var walk func(n *callNode, active map[uint]bool) int64
+
+	walk = func(n *callNode, active map[uint]bool) int64 {
+		s, ok := subs[n.target]
+		if !ok {
+			s = &Subroutine{Address: n.target, Name: p.Name(n.target)}
+			subs[n.target] = s
+		}
+
+		s.Calls += n.calls
+
+		// total the instructions executed here and in callees
+		total := int64(0)
+
+		for _, count := range n.self {
+			total += count
+		}
+
+		s.Exclusive += total
+
+		// recursive calls are already counted by the outermost call
+		outer := !active[n.target]
+		active[n.target] = true
+
+		for _, c := range n.children {
+			total += walk(c, active)
+		}
+
+		if outer {
+			s.Inclusive += total
+			delete(active, n.target)
+		}
+
+		return total
+	}
+
+	walk(p.root, make(map[uint]bool))
+
+	// sort by inclusive cycles, then by address
+	list := make([]Subroutine, 0, len(subs))
+
+	for _, s := range subs {
+		list = append(list, *s)
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Inclusive != list[j].Inclusive {
+			return list[i].Inclusive > list[j].Inclusive
+		}
+
+		return list[i].Address < list[j].Address
+	})
+
+	return list
+}
+
+// Report writes the n hottest addresses and subroutines as text.
+func (p *Profile) Report(w io.Writer, n int) error {
+	var b bytes.Buffer
+
+	// percent of all cycles profiled
+	percent := func(count int64) float64 {
+		if p.Cycles == 0 {
+			return 0
+		}
+
+		return float64(count) * 100 / float64(p.Cycles)
+	}
+
+	fmt.Fprintf(&b, "Profile of %d instructions\n", p.Cycles)
+	fmt.Fprintf(&b, "\nAddress     Count      %%  Location\n")
+
+	for _, address := range p.HotSpots(n) {
+		count := p.Counts[address]
+
+		fmt.Fprintf(&b, "#%04X   %9d %5.1f%%  %s\n", address, count, percent(count), p.Location(address))
+	}
+
+	fmt.Fprintf(&b, "\nSubroutine  Calls Inclusive      %% Exclusive      %%\n")
+
+	for i, s := range p.Subroutines() {
+		if n >= 0 && i >= n {
+			break
+		}
+
+		// keep the columns aligned
+		name := s.Name
+		if len(name) > 10 {
+			name = name[:10]
+		}
+
+		fmt.Fprintf(&b, "%-10s %6d %9d %5.1f%% %9d %5.1f%%\n", name, s.Calls, s.Inclusive, percent(s.Inclusive), s.Exclusive, percent(s.Exclusive))
+	}
+
+	_, err := w.Write(b.Bytes())
+
+	return err
+}
+
+// WritePprof writes the profile in the gzipped protocol buffer format
+// read by `go tool pprof`. Each subroutine is a function, and the call
+// stack of each sample is made of the CALL instructions that led to it.
+func (p *Profile) WritePprof(w io.Writer) error {
+	var out protobuf
+
+	// string table, the first must be empty
+	strs := map[string]uint64{"": 0}
+	table := []string{""}
+
+	str := func(s string) uint64 {
+		i, ok := strs[s]
+		if !ok {
+			i = uint64(len(table))
+			strs[s] = i
+			table = append(table, s)
+		}
+
+		return i
+	}
+
+	// a function for each subroutine, by address
+	funcs := make(map[uint]uint64)
+
+	function := func(target uint) uint64 {
+		id, ok := funcs[target]
+		if !ok {
+			var f protobuf
+
+			id = uint64(len(funcs) + 1)
+			funcs[target] = id
+
+			f.varint(1, id)
+			f.varint(2, str(p.Name(target)))
+			f.varint(3, str(p.Name(target)))
+			out.message(5, &f)
+		}
+
+		return id
+	}
+
+	// a location for each address within each subroutine
+	type key struct{ address, target uint }
+
+	locs := make(map[key]uint64)
+
+	location := func(address, target uint) uint64 {
+		id, ok := locs[key{address, target}]
+		if !ok {
+			var l, line protobuf
+
+			id = uint64(len(locs) + 1)
+			locs[key{address, target}] = id
+
+			line.varint(1, function(target))
+			line.varint(2, uint64(address))
+
+			l.varint(1, id)
+			l.varint(3, uint64(address))
+			l.message(4, &line)
+			out.message(4, &l)
+		}
+
+		return id
+	}
+
+	// the value of each sample is a count of instructions
+	var sampleType protobuf
+
+	sampleType.varint(1, str("instructions"))
+	sampleType.varint(2, str("count"))
+
+	out.message(1, &sampleType)
+	out.message(11, &sampleType)
+	out.varint(12, 1)
+
+	// a sample for each address in each node of the call tree
+	var walk func(n *callNode, callers []uint64)
+
+	walk = func(n *callNode, callers []uint64) {
+		addresses := make([]uint, 0, len(n.self))
+
+		for address := range n.self {
+			addresses = append(addresses, address)
+		}
+
+		sort.Slice(addresses, func(i, j int) bool { return addresses[i] < addresses[j] })
+
+		for _, address := range addresses {
+			var s protobuf
+
+			// leaf location first
+			stack := append([]uint64{location(address, n.target)}, callers...)
+
+			s.packed(1, stack)
+			s.packed(2, []uint64{uint64(n.self[address])})
+			out.message(2, &s)
+		}
+
+		// visit children in call site order
+		sites := make([]uint, 0, len(n.children))
+
+		for site := range n.children {
+			sites = append(sites, site)
+		}
+
+		sort.Slice(sites, func(i, j int) bool { return sites[i] < sites[j] })
+
+		for _, site := range sites {
+			walk(n.children[site], append([]uint64{location(site, n.target)}, callers...))
+		}
+	}
+
+	walk(p.root, nil)
+
+	// the string table is written last, once every string is known
+	for _, s := range table {
+		out.bytes(6, []byte(s))
+	}
+
+	gz := gzip.NewWriter(w)
+
+	if _, err := gz.Write(out.Bytes()); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// protobuf is a minimal protocol buffer encoder.
+type protobuf struct {
+	bytes.Buffer
+}
+
+// Write an unsigned varint.
+func (b *protobuf) uvarint(v uint64) {
+	for v >= 0x80 {
+		b.WriteByte(byte(v) | 0x80)
+		v >>= 7
+	}
+
+	b.WriteByte(byte(v))
+}
+
+// Write a varint field.
+func (b *protobuf) varint(field int, v uint64) {
+	b.uvarint(uint64(field<<3 | 0))
+	b.uvarint(v)
+}
+
+// Write a length delimited field.
+func (b *protobuf) bytes(field int, data []byte) {
+	b.uvarint(uint64(field<<3 | 2))
+	b.uvarint(uint64(len(data)))
+	b.Write(data)
+}
+
+// Write an embedded message field.
+func (b *protobuf) message(field int, m *protobuf) {
+	b.bytes(field, m.Bytes())
+}
+
+// Write a packed, repeated varint field.
+func (b *protobuf) packed(field int, vs []uint64) {
+	var p protobuf
+
+	for _, v := range vs {
+		p.uvarint(v)
+	}
+
+	b.bytes(field, p.Bytes())
+}
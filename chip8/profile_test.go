@@ -0,0 +1,295 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+// A recursive subroutine called with V0 = 2, so it is entered three times
+// before returning to loop forever.
+const recursion = `
+            ld          v0, 2
+            call        rec
+done        jp          done
+rec         se          v0, 0
+            jp          recurse
+            ret
+recurse     add         v0, #FF
+            call        rec
+            ret
+`
+
+// Assemble a program and load it.
+func loadSource(t *testing.T, source string) *CHIP_8 {
+	asm, err := Assemble([]byte(source), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := LoadAssembly(asm, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return vm
+}
+
+// Execute a number of instructions.
+func steps(t *testing.T, vm *CHIP_8, n int) {
+	for i := 0; i < n; i++ {
+		if err := vm.Step(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Check the subroutines of a profile against the expected calls and
+// inclusive and exclusive counts, keyed by address.
+func checkSubroutines(t *testing.T, p *Profile, want map[uint][3]int64) {
+	subs := p.Subroutines()
+
+	if len(subs) != len(want) {
+		t.Fatalf("expected %d subroutines, got %+v", len(want), subs)
+	}
+
+	for _, s := range subs {
+		w, ok := want[s.Address]
+		if !ok {
+			t.Fatalf("unexpected subroutine %+v", s)
+		}
+
+		if got := [3]int64{s.Calls, s.Inclusive, s.Exclusive}; got != w {
+			t.Errorf("%s: expected calls, inclusive, exclusive %v, got %v", s.Name, w, got)
+		}
+	}
+}
+
+// TestProfileRecursion checks recursive calls are only counted once in
+// the inclusive cycles of a subroutine.
+func TestProfileRecursion(t *testing.T) {
+	vm := loadSource(t, recursion)
+	p := vm.StartProfiling()
+
+	// 2 in the main program, 12 recursing, and 6 looping
+	steps(t, vm, 20)
+
+	if p.Cycles != 20 {
+		t.Fatalf("expected 20 cycles, got %d", p.Cycles)
+	}
+
+	checkSubroutines(t, p, map[uint][3]int64{
+		0x200: {0, 20, 8},
+		0x206: {3, 12, 12},
+	})
+}
+
+// TestProfileMidCall checks profiling started within nested calls finds
+// the subroutines already on the stack.
+func TestProfileMidCall(t *testing.T) {
+	vm := loadSource(t, recursion)
+
+	// enter the second call
+	steps(t, vm, 6)
+
+	if vm.SP != 2 || vm.PC != 0x206 {
+		t.Fatalf("expected to be in the second call, SP=%d PC=#%04X", vm.SP, vm.PC)
+	}
+
+	p := vm.StartProfiling()
+
+	// 7 in the second and third calls, 1 returning from the first, and
+	// 2 looping
+	steps(t, vm, 10)
+
+	checkSubroutines(t, p, map[uint][3]int64{
+		0x200: {0, 10, 2},
+		0x206: {1, 8, 8},
+	})
+}
+
+// A field of an encoded protocol buffer message.
+type protoField struct {
+	num   int
+	value uint64
+	data  []byte
+}
+
+// Read the varint and length delimited fields of a protocol buffer.
+func readProto(t *testing.T, b []byte) []protoField {
+	var fields []protoField
+
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad field key")
+		}
+
+		b = b[n:]
+
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad field value")
+		}
+
+		b = b[n:]
+
+		f := protoField{num: int(key >> 3), value: v}
+
+		switch key & 7 {
+		case 0:
+		case 2:
+			f.data, b = b[:v], b[v:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+
+		fields = append(fields, f)
+	}
+
+	return fields
+}
+
+// Read a packed, repeated varint field.
+func readPacked(t *testing.T, b []byte) []uint64 {
+	var vs []uint64
+
+	for len(b) > 0 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad packed value")
+		}
+
+		vs = append(vs, v)
+		b = b[n:]
+	}
+
+	return vs
+}
+
+// TestWritePprof decodes a written profile and checks that the samples
+// add up to the same subroutine totals as the profile.
+func TestWritePprof(t *testing.T) {
+	vm := loadSource(t, recursion)
+	p := vm.StartProfiling()
+
+	steps(t, vm, 20)
+
+	var b bytes.Buffer
+
+	if err := p.WritePprof(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err := gzip.NewReader(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		strs    []string
+		samples [][2][]uint64
+	)
+
+	names := make(map[uint64]uint64)
+	funcs := make(map[uint64]uint64)
+
+	for _, f := range readProto(t, data) {
+		switch f.num {
+		case 2:
+			var s [2][]uint64
+
+			for _, g := range readProto(t, f.data) {
+				s[g.num-1] = readPacked(t, g.data)
+			}
+
+			samples = append(samples, s)
+		case 4:
+			var id, function uint64
+
+			for _, g := range readProto(t, f.data) {
+				switch g.num {
+				case 1:
+					id = g.value
+				case 4:
+					function = readProto(t, g.data)[0].value
+				}
+			}
+
+			funcs[id] = function
+		case 5:
+			fields := readProto(t, f.data)
+			names[fields[0].value] = fields[1].value
+		case 6:
+			strs = append(strs, string(f.data))
+		}
+	}
+
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatal("expected the string table to start empty")
+	}
+
+	// the name of the function of a location
+	name := func(loc uint64) string {
+		return strs[names[funcs[loc]]]
+	}
+
+	total := int64(0)
+	inclusive := make(map[string]int64)
+	exclusive := make(map[string]int64)
+
+	for _, s := range samples {
+		n := int64(s[1][0])
+
+		total += n
+		exclusive[name(s[0][0])] += n
+
+		// recursive frames only count once
+		seen := make(map[string]bool)
+
+		for _, loc := range s[0] {
+			if !seen[name(loc)] {
+				seen[name(loc)] = true
+				inclusive[name(loc)] += n
+			}
+		}
+	}
+
+	if total != p.Cycles {
+		t.Fatalf("expected %d instructions sampled, got %d", p.Cycles, total)
+	}
+
+	for _, sub := range p.Subroutines() {
+		if inclusive[sub.Name] != sub.Inclusive || exclusive[sub.Name] != sub.Exclusive {
+			t.Errorf("%s: expected %d/%d, got %d/%d", sub.Name, sub.Inclusive, sub.Exclusive, inclusive[sub.Name], exclusive[sub.Name])
+		}
+	}
+}
@@ -0,0 +1,104 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Quirks are the behaviors that differ between CHIP-8 interpreters. The
+// zero value is the behavior this emulator has always had, which is the
+// platform "none".
+type Quirks struct {
+	// ShiftVY shifts VY into VX for 8XY6 and 8XYE instead of shifting VX.
+	ShiftVY bool
+
+	// LoadStoreI increments I by X+1 after FX55 and FX65.
+	LoadStoreI bool
+
+	// JumpVX jumps to XNN + VX for BXNN instead of NNN + V0.
+	JumpVX bool
+
+	// ResetVF clears VF after 8XY1, 8XY2, and 8XY3.
+	ResetVF bool
+}
+
+// Names of each quirk and the platforms that can be given to ParseQuirks.
+var (
+	quirkNames = []string{"shift", "loadstore", "jump", "vf"}
+
+	// platforms are common sets of quirks.
+	platforms = map[string]Quirks{
+		"none":   {},
+		"schip":  {JumpVX: true},
+		"cosmac": {ShiftVY: true, LoadStoreI: true, ResetVF: true},
+		"chip48": {JumpVX: true},
+		"xochip": {ShiftVY: true, LoadStoreI: true},
+	}
+)
+
+// ParseQuirks parses a platform name (none, schip, cosmac, chip48, xochip)
+// or a comma separated list of quirks (shift, loadstore, jump, vf). An
+// empty string is no quirks at all.
+func ParseQuirks(s string) (Quirks, error) {
+	var q Quirks
+
+	if p, ok := platforms[strings.ToLower(s)]; ok {
+		return p, nil
+	}
+
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "shift":
+			q.ShiftVY = true
+		case "loadstore":
+			q.LoadStoreI = true
+		case "jump":
+			q.JumpVX = true
+		case "vf":
+			q.ResetVF = true
+		default:
+			return q, fmt.Errorf("unknown quirk or platform: %s", name)
+		}
+	}
+
+	return q, nil
+}
+
+// String returns the comma separated list of quirks enabled.
+func (q Quirks) String() string {
+	var names []string
+
+	for i, on := range []bool{q.ShiftVY, q.LoadStoreI, q.JumpVX, q.ResetVF} {
+		if on {
+			names = append(names, quirkNames[i])
+		}
+	}
+
+	if names == nil {
+		return "none"
+	}
+
+	return strings.Join(names, ",")
+}
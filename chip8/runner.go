@@ -0,0 +1,299 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"sync"
+	"time"
+)
+
+// turboTime is how long turbo runs between checking for commands.
+const turboTime = 4 * time.Millisecond
+
+// Runner owns a CHIP-8 virtual machine and runs it on its own goroutine.
+// All changes to the virtual machine are sent to the runner as commands,
+// and its state is read back through immutable snapshots.
+type Runner struct {
+	// Events receives breakpoints hit and errors returned while running.
+	Events chan error
+
+	// vm is the virtual machine being run; only the runner goroutine
+	// may touch it.
+	vm *CHIP_8
+
+	// paused is true if emulation is paused (single stepping).
+	paused bool
+
+	// turbo is true if running as fast as possible.
+	turbo bool
+
+	// halt is the breakpoint or error that last paused the runner, or nil
+	// if it was paused by a command.
+	halt error
+
+	// commands are executed on the runner goroutine in order.
+	commands chan func(r *Runner)
+
+	// done is closed when the runner goroutine exits.
+	done chan struct{}
+
+	// lock guards the latest snapshot and the dirty lines not yet seen.
+	lock sync.Mutex
+
+	// snapshot is the most recently published state.
+	snapshot *Snapshot
+
+	// dirty are the scan lines changed since the last snapshot was taken.
+	dirty uint64
+}
+
+// NewRunner starts running a virtual machine on a new goroutine.
+func NewRunner(vm *CHIP_8) *Runner {
+	r := &Runner{
+		Events:   make(chan error, 64),
+		vm:       vm,
+		commands: make(chan func(r *Runner), 64),
+		done:     make(chan struct{}),
+	}
+
+	// make sure there's always a snapshot to read
+	r.publish()
+
+	go r.run()
+
+	return r
+}
+
+// Snapshot returns the latest published state of the virtual machine.
+// The Dirty mask of the snapshot has every scan line that changed since
+// the previous call, which acknowledges them, so only the screen should
+// be drawn from it. Everything else reads Latest.
+func (r *Runner) Snapshot() *Snapshot {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// the dirty lines have now been seen
+	r.dirty = 0
+
+	return r.snapshot
+}
+
+// Latest returns the latest published state of the virtual machine
+// without acknowledging its dirty scan lines, for readers other than the
+// one drawing the screen.
+func (r *Runner) Latest() *Snapshot {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.snapshot
+}
+
+// Stop the runner goroutine and wait for it to exit.
+func (r *Runner) Stop() {
+	close(r.commands)
+
+	<-r.done
+}
+
+// Send a command to be run on the runner goroutine, without waiting.
+func (r *Runner) Send(cmd func(vm *CHIP_8)) {
+	r.commands <- func(r *Runner) { cmd(r.vm) }
+}
+
+// Do runs a command on the runner goroutine and waits for it to finish.
+func (r *Runner) Do(cmd func(vm *CHIP_8)) {
+	done := make(chan struct{})
+
+	r.commands <- func(r *Runner) {
+		defer close(done)
+
+		cmd(r.vm)
+	}
+
+	<-done
+}
+
+// Load replaces the virtual machine being run. The runner is unpaused.
+func (r *Runner) Load(vm *CHIP_8) {
+	r.commands <- func(r *Runner) {
+		r.vm = vm
+		r.paused, r.halt = false, nil
+	}
+}
+
+// Reset the virtual machine, optionally pausing before the first
+// instruction is executed.
+func (r *Runner) Reset(pause bool) {
+	r.commands <- func(r *Runner) {
+		r.vm.Reset()
+		r.paused, r.halt = pause, nil
+	}
+}
+
+// PressKey presses a key on the CHIP-8 keypad.
+func (r *Runner) PressKey(key uint) {
+	r.Send(func(vm *CHIP_8) { vm.PressKey(key) })
+}
+
+// ReleaseKey releases a key on the CHIP-8 keypad.
+func (r *Runner) ReleaseKey(key uint) {
+	r.Send(func(vm *CHIP_8) { vm.ReleaseKey(key) })
+}
+
+// Pause or resume emulation.
+func (r *Runner) Pause(pause bool) {
+	r.commands <- func(r *Runner) { r.paused, r.halt = pause, nil }
+}
+
+// TogglePause pauses a running virtual machine or resumes a paused one.
+func (r *Runner) TogglePause() {
+	r.commands <- func(r *Runner) { r.paused, r.halt = !r.paused, nil }
+}
+
+// Halted returns true if the runner is paused, and the breakpoint or
+// error that paused it, if any.
+func (r *Runner) Halted() (paused bool, halt error) {
+	r.Do(func(*CHIP_8) { paused, halt = r.paused, r.halt })
+
+	return
+}
+
+// Turbo runs the virtual machine as fast as possible while on, instead
+// of at its speed.
+func (r *Runner) Turbo(on bool) {
+	r.commands <- func(r *Runner) { r.turbo = on }
+}
+
+// FrameAdvance runs a single frame (1/60 s) of emulated time while paused.
+func (r *Runner) FrameAdvance() {
+	r.whilePaused(func(vm *CHIP_8) error { return vm.RunFrames(1) })
+}
+
+// Step a single instruction while paused.
+func (r *Runner) Step() {
+	r.whilePaused(func(vm *CHIP_8) error { return vm.Step() })
+}
+
+// StepOut runs until the current subroutine returns while paused.
+func (r *Runner) StepOut() {
+	r.whilePaused(func(vm *CHIP_8) error { return vm.StepOut() })
+}
+
+// StepOver steps a single instruction while paused, but runs until a
+// subroutine called returns.
+func (r *Runner) StepOver() {
+	r.commands <- func(r *Runner) {
+		if r.paused {
+			r.halt = nil
+
+			if r.vm.StepOverBreakpoint() {
+				r.paused = false
+			} else {
+				r.report(r.vm.Step())
+			}
+		}
+	}
+}
+
+// Run a stepping command only if paused.
+func (r *Runner) whilePaused(step func(vm *CHIP_8) error) {
+	r.commands <- func(r *Runner) {
+		if r.paused {
+			r.halt = nil
+			r.report(step(r.vm))
+		}
+	}
+}
+
+// Run the virtual machine until the runner is stopped.
+func (r *Runner) run() {
+	defer close(r.done)
+
+	// emulation and publishing rates
+	clock := time.NewTicker(time.Millisecond)
+	frame := time.NewTicker(time.Second / 60)
+
+	defer clock.Stop()
+	defer frame.Stop()
+
+	for {
+		select {
+		case cmd, ok := <-r.commands:
+			if !ok {
+				return
+			}
+
+			cmd(r)
+
+			// commands are seen immediately
+			r.publish()
+		case <-clock.C:
+			if r.turbo && !r.paused {
+				r.report(r.vm.Turbo(turboTime))
+			} else {
+				r.report(r.vm.Process(r.paused))
+			}
+		case <-frame.C:
+			r.publish()
+		}
+	}
+}
+
+// Pause at breakpoints and faults, and send any error to the Events
+// channel.
+func (r *Runner) report(err error) {
+	if err == nil {
+		return
+	}
+
+	switch err.(type) {
+	case Breakpoint, Watchpoint, Divergence:
+		r.paused, r.halt = true, err
+	default:
+		if err != EndOfMovie {
+			r.vm.Playback = nil
+
+			// break on faults so they can be debugged
+			r.paused, r.halt = true, err
+		}
+	}
+
+	// drop events if nobody is listening
+	select {
+	case r.Events <- err:
+	default:
+	}
+}
+
+// Publish a new snapshot of the virtual machine's state.
+func (r *Runner) publish() {
+	s := newSnapshot(r.vm, r.paused)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// collect the lines changed until the snapshot is taken
+	r.dirty |= r.vm.Refresh()
+	s.Dirty = r.dirty
+
+	r.snapshot = s
+}
@@ -0,0 +1,264 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+// Snapshot is a read-only copy of the virtual machine's state published
+// by a runner. It shares nothing with the running virtual machine, so
+// reading it never races with emulation, and changing it has no effect.
+type Snapshot struct {
+	// Memory addressable by CHIP-8.
+	Memory [0x1000]byte
+
+	// Video memory. See CHIP_8.Video for its layout.
+	Video [0x440]byte
+
+	// Stack of return addresses.
+	Stack [16]uint
+
+	// SP is the stack pointer.
+	SP uint
+
+	// PC is the program counter.
+	PC uint
+
+	// Base is the starting address of the program.
+	Base uint
+
+	// The size of the ROM.
+	Size int
+
+	// I is the address register.
+	I uint
+
+	// V are the 16 virtual registers.
+	V [16]byte
+
+	// R are the 8, HP-RPL user flags.
+	R [8]byte
+
+	// Pattern is the XO-CHIP audio pattern buffer.
+	Pattern [16]byte
+
+	// Patterned is true once an audio pattern has been loaded.
+	Patterned bool
+
+	// Number of bytes per scan line.
+	Pitch int
+
+	// Dirty is a bit mask of the scan lines in Video that have changed
+	// since the previous snapshot was taken.
+	Dirty uint64
+
+	// Quirks are the interpreter behaviors being emulated.
+	Quirks Quirks
+
+	// Breakpoints set, by address.
+	Breakpoints map[int]Breakpoint
+
+	// Watchpoints set, by address.
+	Watchpoints map[uint]Access
+
+	// Symbols are the names of labeled addresses, if any.
+	Symbols map[uint]string
+
+	// Trace is a copy of the most recent instructions executed, if tracing.
+	Trace *Trace
+
+	// Coverage is a copy of how memory has been accessed, if tracking.
+	Coverage *Coverage
+
+	// Mirror is the state of the virtual machine run in lockstep, if any.
+	Mirror *Snapshot
+
+	// Recording is true if inputs are being recorded to a movie.
+	Recording bool
+
+	// Playing is true if a movie is being played back.
+	Playing bool
+
+	// Profiling is true if instructions executed are being profiled.
+	Profiling bool
+
+	// Paused is true if the runner was paused.
+	Paused bool
+
+	// vm is a private copy of the state used to answer queries.
+	vm CHIP_8
+}
+
+// Take a snapshot of a virtual machine that shares nothing mutable with it.
+func newSnapshot(vm *CHIP_8, paused bool) *Snapshot {
+	s := &Snapshot{
+		Memory:      vm.Memory,
+		Video:       vm.Video,
+		Stack:       vm.Stack,
+		SP:          vm.SP,
+		PC:          vm.PC,
+		Base:        vm.Base,
+		Size:        vm.Size,
+		I:           vm.I,
+		V:           vm.V,
+		R:           vm.R,
+		Pattern:     vm.Pattern,
+		Patterned:   vm.Patterned,
+		Pitch:       vm.Pitch,
+		Dirty:       vm.Dirty,
+		Quirks:      vm.Quirks,
+		Breakpoints: make(map[int]Breakpoint, len(vm.Breakpoints)),
+		Watchpoints: make(map[uint]Access, len(vm.Watchpoints)),
+		Symbols:     copyNames(vm.Symbols),
+		Recording:   vm.Recording != nil,
+		Playing:     vm.Playback != nil,
+		Profiling:   vm.Profiler != nil,
+		Paused:      paused,
+		vm:          *vm,
+	}
+
+	for address, b := range vm.Breakpoints {
+		s.Breakpoints[address] = b
+	}
+
+	for address, access := range vm.Watchpoints {
+		s.Watchpoints[address] = access
+	}
+
+	// coverage and the trace keep changing after publishing
+	if vm.Coverage != nil {
+		c := *vm.Coverage
+		s.Coverage = &c
+	}
+
+	if vm.Trace != nil {
+		t := *vm.Trace
+		s.Trace = &t
+	}
+
+	if vm.Mirror != nil {
+		s.Mirror = newSnapshot(vm.Mirror, paused)
+	}
+
+	// the private copy only keeps what queries read
+	s.vm.Breakpoints = s.Breakpoints
+	s.vm.Watchpoints = s.Watchpoints
+	s.vm.Symbols = s.Symbols
+	s.vm.Aliases = copyNames(vm.Aliases)
+	s.vm.W = nil
+	s.vm.watched = nil
+	s.vm.rng = nil
+	s.vm.cache = nil
+	s.vm.Recording = nil
+	s.vm.Playback = nil
+	s.vm.Profiler = nil
+	s.vm.Coverage = s.Coverage
+	s.vm.Trace = s.Trace
+	s.vm.Mirror = nil
+
+	return s
+}
+
+// Copy a map of names, which is nil if there are none.
+func copyNames(names map[uint]string) map[uint]string {
+	if names == nil {
+		return nil
+	}
+
+	c := make(map[uint]string, len(names))
+
+	for address, name := range names {
+		c[address] = name
+	}
+
+	return c
+}
+
+// GetResolution returns the width and height of the display.
+func (s *Snapshot) GetResolution() (int, int) {
+	return s.vm.GetResolution()
+}
+
+// GetDelayTimer returns the value of the delay timer register.
+func (s *Snapshot) GetDelayTimer() byte {
+	return s.vm.GetDelayTimer()
+}
+
+// GetSoundTimer returns the value of the sound timer register.
+func (s *Snapshot) GetSoundTimer() byte {
+	return s.vm.GetSoundTimer()
+}
+
+// Buzzing returns true while the sound timer is counting down.
+func (s *Snapshot) Buzzing() bool {
+	return s.vm.Buzzing()
+}
+
+// PatternRate returns the number of audio pattern samples played per
+// second.
+func (s *Snapshot) PatternRate() float64 {
+	return s.vm.PatternRate()
+}
+
+// Symbol returns the label at an address, or the address if there isn't
+// one.
+func (s *Snapshot) Symbol(address uint) string {
+	return s.vm.Symbol(address)
+}
+
+// Location returns the nearest label at or before an address plus the
+// offset from it.
+func (s *Snapshot) Location(address uint) string {
+	return s.vm.Location(address)
+}
+
+// CallStack returns the subroutine calls on the stack, innermost first.
+func (s *Snapshot) CallStack() []Frame {
+	return s.vm.CallStack()
+}
+
+// Disassemble the instruction at an address.
+func (s *Snapshot) Disassemble(address uint) string {
+	return s.vm.Disassemble(address)
+}
+
+// Sprite returns the sprite DXYN would draw from an address.
+func (s *Snapshot) Sprite(address uint, n byte) Sprite {
+	return s.vm.Sprite(address, n)
+}
+
+// NextDraw finds the next DXYN instruction that will be executed.
+func (s *Snapshot) NextDraw() (uint, bool) {
+	return s.vm.NextDraw()
+}
+
+// Eval evaluates a debugger expression against the snapshot.
+func (s *Snapshot) Eval(e *Expr) int {
+	return e.Eval(&s.vm)
+}
+
+// SaveROM writes the ROM file to disk.
+func (s *Snapshot) SaveROM(file string, includeInterpreter bool) error {
+	return s.vm.SaveROM(file, includeInterpreter)
+}
+
+// SaveSource writes the ROM to disk as source that assembles back into it.
+func (s *Snapshot) SaveSource(file string) error {
+	return s.vm.SaveSource(file)
+}
@@ -0,0 +1,518 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Syntax is the assembly language that disassembly is written in.
+type Syntax int
+
+// Syntaxes disassembly can be written in.
+const (
+	SYNTAX_C8 Syntax = iota
+	SYNTAX_OCTO
+)
+
+// Format is how disassembly is laid out.
+type Format int
+
+// Formats disassembly can be laid out in.
+const (
+	// FORMAT_SOURCE is source that assembles back into the same program.
+	FORMAT_SOURCE Format = iota
+
+	// FORMAT_LISTING is source with every line prefixed by its address
+	// and the bytes of its instruction.
+	FORMAT_LISTING
+)
+
+// Extensions are the instruction sets beyond CHIP-8 that are decoded as
+// instructions when disassembling. Opcodes from other sets are data.
+type Extensions struct {
+	// Super is the SCHIP instruction set.
+	Super bool
+
+	// Extended is the CHIP-8E instruction set.
+	Extended bool
+
+	// XOChip is the XO-CHIP audio instructions.
+	XOChip bool
+}
+
+// DisassemblyOptions control how a program is disassembled.
+type DisassemblyOptions struct {
+	Syntax     Syntax
+	Format     Format
+	Extensions Extensions
+}
+
+// AllExtensions decode every instruction the emulator runs.
+var AllExtensions = Extensions{Super: true, Extended: true, XOChip: true}
+
+// Kinds of labels generated for addresses, in order of preference.
+const (
+	LABEL_DATA = iota + 1
+	LABEL_CODE
+	LABEL_TABLE
+	LABEL_SUB
+	LABEL_START
+)
+
+// A program being disassembled.
+type disassembly struct {
+	code []byte
+	base uint
+	opts DisassemblyOptions
+
+	// inst is true where an instruction starts, and owned is true for
+	// every byte of an instruction.
+	inst  []bool
+	owned []bool
+
+	// sprite is true for bytes of data that are drawn.
+	sprite []bool
+
+	// labels are the kinds of labels wanted at addresses.
+	labels map[uint]int
+
+	// work is the addresses left to trace.
+	work []uint
+
+	// instruction sets used by the code
+	super    bool
+	extended bool
+
+	// out is the disassembly written so far.
+	out bytes.Buffer
+}
+
+// ParseExtensions parses a comma separated list of instruction sets
+// (super, extended, xochip), "all", or "none".
+func ParseExtensions(s string) (Extensions, error) {
+	var e Extensions
+
+	for _, name := range strings.Split(s, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "", "none":
+		case "all":
+			e = AllExtensions
+		case "super", "schip":
+			e.Super = true
+		case "extended", "chip8e":
+			e.Extended = true
+		case "xochip":
+			e.XOChip = true
+		default:
+			return e, fmt.Errorf("unknown instruction set: %s", name)
+		}
+	}
+
+	return e, nil
+}
+
+// Disassemble a whole program, loaded at a base address.
+//
+// Control flow is followed from the base address through jumps, calls,
+// and skips to find the code. The targets of JP V0 are assumed to be
+// jump tables with entries the same size as the first one. Everything
+// else is data, and data drawn after LD I is written as sprites.
+// Instructions that can't be written in the syntax are written as data,
+// so source always assembles back into the identical program.
+func Disassemble(code []byte, base uint, opts DisassemblyOptions) []byte {
+	d := &disassembly{
+		code:   code,
+		base:   base,
+		opts:   opts,
+		inst:   make([]bool, len(code)),
+		owned:  make([]bool, len(code)),
+		sprite: make([]bool, len(code)),
+		labels: map[uint]int{base: LABEL_START},
+		work:   []uint{base},
+	}
+
+	// follow every path through the code
+	for len(d.work) > 0 {
+		pc := d.work[len(d.work)-1]
+		d.work = d.work[:len(d.work)-1]
+
+		d.trace(pc)
+	}
+
+	d.write()
+
+	return d.out.Bytes()
+}
+
+// Fetch the instruction at an address, if the whole instruction is in
+// the program and is in an instruction set being decoded.
+func (d *disassembly) fetch(address uint) (instruction, bool) {
+	if address < d.base || address+1 >= d.base+uint(len(d.code)) {
+		return instruction{}, false
+	}
+
+	i := address - d.base
+	inst := disassemble(d.opcode(i))
+
+	if inst.mnemonic == "" || inst.mnemonic == "??" {
+		return inst, false
+	}
+
+	switch inst.set {
+	case SET_SUPER:
+		return inst, d.opts.Extensions.Super
+	case SET_EXTENDED:
+		return inst, d.opts.Extensions.Extended
+	case SET_XOCHIP:
+		return inst, d.opts.Extensions.XOChip
+	}
+
+	return inst, true
+}
+
+// Get the opcode at an offset into the program.
+func (d *disassembly) opcode(i uint) uint {
+	return uint(d.code[i])<<8 | uint(d.code[i+1])
+}
+
+// Add a label to an address, keeping the most important kind.
+func (d *disassembly) label(address uint, kind int) {
+	if d.labels[address] < kind {
+		d.labels[address] = kind
+	}
+}
+
+// Mark the instructions along a path through the code, starting at an
+// address, and queue the other paths that branch from it.
+func (d *disassembly) trace(pc uint) {
+	for {
+		inst, ok := d.fetch(pc)
+		if !ok {
+			return
+		}
+
+		// already traced, or overlapping another instruction
+		i := pc - d.base
+		if d.owned[i] || d.owned[i+1] {
+			return
+		}
+
+		d.inst[i] = true
+		d.owned[i] = true
+		d.owned[i+1] = true
+
+		// only exactly encoded instructions need the directive
+		if !inst.lossy {
+			d.super = d.super || inst.set == SET_SUPER
+			d.extended = d.extended || inst.set == SET_EXTENDED
+		}
+
+		switch inst.flow {
+		case FLOW_STOP:
+			return
+		case FLOW_JUMP:
+			d.label(inst.target, LABEL_CODE)
+			d.work = append(d.work, inst.target)
+			return
+		case FLOW_CALL:
+			d.label(inst.target, LABEL_SUB)
+			d.work = append(d.work, inst.target)
+		case FLOW_SKIP:
+			d.work = append(d.work, pc+4)
+		case FLOW_TABLE:
+			d.table(inst.target)
+			return
+		case FLOW_NEXT:
+			if inst.mnemonic == "LD" && inst.address >= 0 {
+				d.label(inst.target, LABEL_DATA)
+				d.drawn(pc+2, inst.target)
+			}
+		}
+
+		pc += 2
+	}
+}
+
+// Queue the entries of a jump table. The first entry is where JP V0
+// goes when V0 is zero. Entries that follow it are assumed to be the
+// same size and end the same way.
+func (d *disassembly) table(address uint) {
+	d.label(address, LABEL_TABLE)
+	d.work = append(d.work, address)
+
+	size, end := d.entry(address)
+	if size == 0 {
+		return
+	}
+
+	// V0 can't offset past 255 bytes
+	for offset := size; offset < 0x100; offset += size {
+		if n, last := d.entry(address + offset); n != size || last != end {
+			break
+		}
+
+		d.label(address+offset, LABEL_CODE)
+		d.work = append(d.work, address+offset)
+	}
+}
+
+// Find the size of a jump table entry at an address and the mnemonic of
+// the instruction that ends it, or 0 if it isn't code.
+func (d *disassembly) entry(address uint) (uint, string) {
+	for n := uint(0); n < 16; n++ {
+		inst, ok := d.fetch(address + n*2)
+		if !ok {
+			break
+		}
+
+		if inst.flow == FLOW_JUMP || inst.flow == FLOW_TABLE || inst.flow == FLOW_STOP {
+			return n*2 + 2, inst.mnemonic
+		}
+	}
+
+	return 0, ""
+}
+
+// Mark the data at an address as a sprite if the instructions after
+// setting I to it draw before changing I.
+func (d *disassembly) drawn(pc, address uint) {
+	for n := 0; n < 8; n, pc = n+1, pc+2 {
+		inst, ok := d.fetch(pc)
+		if !ok || (inst.flow != FLOW_NEXT && inst.flow != FLOW_SKIP) {
+			return
+		}
+
+		// I is changed before drawing
+		if len(inst.operands) > 0 && (inst.operands[0] == "I" || inst.operands[0] == "F" || inst.operands[0] == "HF") {
+			return
+		}
+
+		if inst.mnemonic != "DRW" {
+			continue
+		}
+
+		// the number of bytes drawn
+		size := uint(d.code[pc-d.base+1] & 0xF)
+		if size == 0 {
+			size = 32
+		}
+
+		for a := address; a < address+size; a++ {
+			if a >= d.base && a < d.base+uint(len(d.code)) {
+				d.sprite[a-d.base] = true
+			}
+		}
+
+		return
+	}
+}
+
+// Get the name of the label at an address, if the address is where a
+// line of source begins.
+func (d *disassembly) name(address uint) string {
+	kind, ok := d.labels[address]
+	if !ok || address < d.base || address > d.base+uint(len(d.code)) {
+		return ""
+	}
+
+	// labels in the middle of an instruction can't be written
+	if i := address - d.base; i < uint(len(d.code)) && d.owned[i] && !d.inst[i] {
+		return ""
+	}
+
+	switch kind {
+	case LABEL_START:
+		if d.opts.Syntax == SYNTAX_OCTO {
+			return "main"
+		}
+		return "start"
+	case LABEL_SUB:
+		return fmt.Sprintf("sub_%04x", address)
+	case LABEL_TABLE:
+		return fmt.Sprintf("table_%04x", address)
+	case LABEL_CODE:
+		return fmt.Sprintf("code_%04x", address)
+	}
+
+	return fmt.Sprintf("data_%04x", address)
+}
+
+// Write a line of disassembly. Listings prefix the line with its address
+// and the bytes of its instruction, if it has an address. Labels are
+// preceded by a blank line.
+func (d *disassembly) line(address int, label, s string) {
+	if label != "" {
+		d.out.WriteString("\n")
+
+		// octo labels are on a line of their own
+		if d.opts.Syntax == SYNTAX_OCTO {
+			d.text(-1, ": "+label)
+
+			if s == "" {
+				return
+			}
+
+			label = ""
+		}
+	}
+
+	if d.opts.Syntax == SYNTAX_OCTO {
+		d.text(address, "  "+s)
+	} else {
+		d.text(address, fmt.Sprintf("%-12s%s", label, s))
+	}
+}
+
+// Write a line of text, prefixed with its address in listings.
+func (d *disassembly) text(address int, s string) {
+	if d.opts.Format == FORMAT_LISTING {
+		if address < 0 {
+			d.out.WriteString("            ")
+		} else if i := address - int(d.base); d.inst[i] {
+			fmt.Fprintf(&d.out, "%04X  %04X  ", address, d.opcode(uint(i)))
+		} else {
+			fmt.Fprintf(&d.out, "%04X        ", address)
+		}
+	}
+
+	d.out.WriteString(strings.TrimRight(s, " "))
+	d.out.WriteString("\n")
+}
+
+// Write a comment line.
+func (d *disassembly) comment(s string) {
+	if d.opts.Syntax == SYNTAX_OCTO {
+		d.text(-1, "# "+s)
+	} else {
+		d.text(-1, "; "+s)
+	}
+}
+
+// Write an instruction at an offset into the program.
+func (d *disassembly) instruction(i int, label string) {
+	address := d.base + uint(i)
+	inst, _ := d.fetch(address)
+	op := d.opcode(uint(i))
+
+	// octo is written from the opcode
+	if d.opts.Syntax == SYNTAX_OCTO {
+		if s, ok := octo(op, d.name(inst.target)); ok {
+			d.line(int(address), label, s)
+		} else {
+			d.line(int(address), label, fmt.Sprintf("0x%02X 0x%02X # %s", d.code[i], d.code[i+1], strings.ToLower(inst.text(""))))
+		}
+
+		return
+	}
+
+	// the assembler can't encode some instructions
+	if inst.lossy || inst.set == SET_XOCHIP {
+		d.line(int(address), label, fmt.Sprintf("%-12s#%04x ; %s", "word", op, strings.ToLower(inst.text(""))))
+	} else {
+		d.line(int(address), label, fmt.Sprintf("%-12s%s", strings.ToLower(inst.mnemonic), strings.ToLower(inst.operandText(d.name(inst.target)))))
+	}
+}
+
+// Write bytes of data at an offset into the program.
+func (d *disassembly) data(i int, label string, row []byte) {
+	isOcto := d.opts.Syntax == SYNTAX_OCTO
+	literals := make([]string, len(row))
+
+	for n, b := range row {
+		switch {
+		case isOcto && d.sprite[i]:
+			literals[n] = fmt.Sprintf("0b%08b", b)
+		case isOcto:
+			literals[n] = fmt.Sprintf("0x%02X", b)
+		case d.sprite[i]:
+			literals[n] = strings.Replace(fmt.Sprintf("%%%08b", b), "0", ".", -1)
+		default:
+			literals[n] = fmt.Sprintf("#%02x", b)
+		}
+	}
+
+	if isOcto {
+		d.line(int(d.base)+i, label, strings.Join(literals, " "))
+	} else {
+		d.line(int(d.base)+i, label, fmt.Sprintf("%-12s%s", "byte", strings.Join(literals, ", ")))
+	}
+}
+
+// Write the disassembly.
+func (d *disassembly) write() {
+	if d.opts.Syntax == SYNTAX_OCTO {
+		d.comment(fmt.Sprintf("disassembled from %d bytes at 0x%04X", len(d.code), d.base))
+	} else {
+		d.comment(fmt.Sprintf("disassembled from %d bytes at #%04x", len(d.code), d.base))
+
+		if d.super {
+			d.line(-1, "", "super")
+		}
+
+		if d.extended {
+			d.line(-1, "", "extended")
+		}
+	}
+
+	for i := 0; i < len(d.code); {
+		label := d.name(d.base + uint(i))
+
+		// instructions
+		if d.inst[i] {
+			d.instruction(i, label)
+
+			i += 2
+			continue
+		}
+
+		// sprites are written one byte per line
+		if d.sprite[i] {
+			d.data(i, label, d.code[i:i+1])
+
+			i++
+			continue
+		}
+
+		// other data is in rows of up to 8 bytes
+		n := i
+
+		for n < len(d.code) && n-i < 8 {
+			if d.inst[n] || d.sprite[n] || (n > i && d.name(d.base+uint(n)) != "") {
+				break
+			}
+
+			n++
+		}
+
+		d.data(i, label, d.code[i:n])
+
+		i = n
+	}
+
+	// a label just past the end of the program
+	if label := d.name(d.base + uint(len(d.code))); label != "" {
+		d.line(-1, label, "")
+	}
+}
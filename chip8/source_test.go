@@ -0,0 +1,134 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestDisassembleROM disassembles every example ROM and program, which
+// must assemble back into the identical ROM.
+func TestDisassembleROM(t *testing.T) {
+	roms, _ := filepath.Glob("../games/roms/*")
+	super, _ := filepath.Glob("../games/roms/super/*")
+
+	for _, file := range append(roms, super...) {
+		rom, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		roundTrip(t, file, rom, false)
+	}
+
+	sources, _ := filepath.Glob("../games/sources/*.c8")
+
+	for _, file := range sources {
+		source, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// assembled for the ETI-660 to test another base address
+		for _, eti := range []bool{false, true} {
+			asm, err := Assemble(source, eti)
+			if err != nil {
+				t.Fatalf("%s: %s", file, err)
+			}
+
+			roundTrip(t, file, asm.ROM, eti)
+		}
+	}
+}
+
+// Fail if a ROM doesn't assemble back into itself after disassembly, in
+// either syntax.
+func roundTrip(t *testing.T, name string, rom []byte, eti bool) {
+	base := uint(0x200)
+	if eti {
+		base = 0x600
+	}
+
+	for syntax, assemble := range map[Syntax]func([]byte, bool) (*Assembly, error){
+		SYNTAX_C8:   Assemble,
+		SYNTAX_OCTO: AssembleOcto,
+	} {
+		source := Disassemble(rom, base, DisassemblyOptions{Syntax: syntax, Extensions: AllExtensions})
+
+		asm, err := assemble(source, eti)
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", name, err, source)
+		}
+
+		if !bytes.Equal(asm.ROM, rom) {
+			t.Fatalf("%s: reassembled ROM differs\n%s", name, source)
+		}
+	}
+}
+
+// TestDisassembleOptions checks the syntaxes, formats, and instruction
+// sets of disassembly.
+func TestDisassembleOptions(t *testing.T) {
+	rom := []byte{
+		0x00, 0xFF, // HIGH
+		0xA2, 0x08, // LD I, sprite
+		0xD0, 0x11, // DRW V0, V1, 1
+		0x12, 0x00, // JP start
+		0x80, // sprite
+	}
+
+	tests := []struct {
+		opts  DisassemblyOptions
+		lines []string
+	}{
+		{
+			DisassemblyOptions{Extensions: AllExtensions},
+			[]string{"            super", "start       high", "            ld          i, data_0208", "data_0208   byte        %1......."},
+		},
+		{
+			DisassemblyOptions{Syntax: SYNTAX_OCTO, Extensions: AllExtensions},
+			[]string{": main", "  hires", "  i := data_0208", "  sprite v0 v1 1", "  jump main", "  0b10000000"},
+		},
+		{
+			DisassemblyOptions{Format: FORMAT_LISTING, Extensions: AllExtensions},
+			[]string{"0202  A208              ld          i, data_0208", "0208        data_0208   byte        %1......."},
+		},
+		{
+			DisassemblyOptions{},
+			[]string{"start       byte        #00, #ff, #a2, #08, #d0, #11, #12, #00", "            byte        #80"},
+		},
+	}
+
+	for _, test := range tests {
+		source := string(Disassemble(rom, 0x200, test.opts))
+
+		for _, line := range test.lines {
+			if !strings.Contains(source, line+"\n") {
+				t.Errorf("missing %q in\n%s", line, source)
+			}
+		}
+	}
+}
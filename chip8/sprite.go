@@ -0,0 +1,90 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+// Sprite is the image DXYN draws from memory.
+type Sprite struct {
+	// Address is where the sprite is in memory.
+	Address uint
+
+	// Width and Height are the size of the sprite in pixels.
+	Width, Height int
+
+	// Rows are the pixels of each row, the leftmost pixel in bit 15.
+	Rows []uint16
+}
+
+// Sprite returns the sprite DXYN would draw from an address. When N is 0
+// the sprite is 16x16 (two bytes per row) in high resolution, and 8x16
+// (only the left byte of each row) in low resolution.
+func (vm *CHIP_8) Sprite(address uint, n byte) Sprite {
+	s := Sprite{Address: address & 0xFFF, Width: 8, Height: int(n)}
+
+	if n == 0 {
+		s.Height = 16
+
+		if vm.Pitch == 16 {
+			s.Width = 16
+		}
+	}
+
+	for r := uint(0); r < uint(s.Height); r++ {
+		if n > 0 {
+			s.Rows = append(s.Rows, uint16(vm.Memory[(address+r)&0xFFF])<<8)
+		} else {
+			row := uint16(vm.Memory[(address+r*2)&0xFFF]) << 8
+
+			// the right half is only drawn in high resolution
+			if s.Width == 16 {
+				row |= uint16(vm.Memory[(address+r*2+1)&0xFFF])
+			}
+
+			s.Rows = append(s.Rows, row)
+		}
+	}
+
+	return s
+}
+
+// NextDraw finds the next DXYN instruction that will be executed, starting
+// at the PC and following jumps, but not calls or skips. Returns false if
+// there isn't one within a few instructions.
+func (vm *CHIP_8) NextDraw() (uint, bool) {
+	pc := vm.PC & 0xFFF
+
+	for i := 0; i < 32; i++ {
+		inst := uint(vm.Memory[pc])<<8 | uint(vm.Memory[(pc+1)&0xFFF])
+
+		switch {
+		case inst&0xF000 == 0xD000:
+			return pc, true
+		case inst&0xF000 == 0x1000:
+			pc = inst & 0xFFF
+		case inst == 0x00EE, inst == 0x00FD, inst&0xF000 == 0xB000:
+			return 0, false
+		default:
+			pc = (pc + 2) & 0xFFF
+		}
+	}
+
+	return 0, false
+}
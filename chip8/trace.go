@@ -0,0 +1,69 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package chip8
+
+// Trace keeps the addresses of the most recent instructions executed.
+type Trace struct {
+	// PC is the address of every instruction traced, indexed by the step
+	// it was traced on modulo its length. Only the most recent are kept.
+	PC [1024]uint
+
+	// Steps is how many instructions have been traced.
+	Steps int64
+}
+
+// StartTrace begins tracing every instruction executed.
+func (vm *CHIP_8) StartTrace() *Trace {
+	vm.Trace = &Trace{}
+
+	return vm.Trace
+}
+
+// StopTrace stops tracing and returns the trace.
+func (vm *CHIP_8) StopTrace() *Trace {
+	t := vm.Trace
+
+	vm.Trace = nil
+
+	return t
+}
+
+// Since returns the addresses of the instructions traced since a step,
+// oldest first, and how many instructions after the step are no longer
+// kept.
+func (t *Trace) Since(step int64) (pcs []uint, dropped int64) {
+	if oldest := t.Steps - int64(len(t.PC)); step < oldest {
+		step, dropped = oldest, oldest-step
+	}
+
+	for ; step < t.Steps; step++ {
+		pcs = append(pcs, t.PC[step%int64(len(t.PC))])
+	}
+
+	return pcs, dropped
+}
+
+// Add an instruction executed to the trace.
+func (t *Trace) record(pc uint) {
+	t.PC[t.Steps%int64(len(t.PC))] = pc
+	t.Steps += 1
+}
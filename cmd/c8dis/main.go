@@ -0,0 +1,143 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+)
+
+// main disassembles each ROM given on the command line (or standard input
+// if none are) and writes the listings to standard output.
+func main() {
+	var opts chip8.DisassemblyOptions
+
+	eti := flag.Bool("eti", false, "Load ROMs at 0x600 for ETI-660.")
+	syntax := flag.String("syntax", "c8", "Syntax: c8 or octo.")
+	format := flag.String("format", "source", "Format: source or listing.")
+	platform := flag.String("platform", "all", "Instruction sets: all, none, or a comma separated list of super, extended, xochip.")
+	flag.Parse()
+
+	switch strings.ToLower(*syntax) {
+	case "c8":
+		opts.Syntax = chip8.SYNTAX_C8
+	case "octo":
+		opts.Syntax = chip8.SYNTAX_OCTO
+	default:
+		fail(fmt.Errorf("unknown syntax: %s", *syntax))
+	}
+
+	switch strings.ToLower(*format) {
+	case "source":
+		opts.Format = chip8.FORMAT_SOURCE
+	case "listing":
+		opts.Format = chip8.FORMAT_LISTING
+	default:
+		fail(fmt.Errorf("unknown format: %s", *format))
+	}
+
+	ext, err := chip8.ParseExtensions(*platform)
+	if err != nil {
+		fail(err)
+	}
+
+	opts.Extensions = ext
+
+	// read the ROM from stdin if no files are given
+	if flag.NArg() == 0 {
+		rom, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			fail(err)
+		}
+
+		os.Stdout.Write(chip8.Disassemble(rom, base(*eti), opts))
+		return
+	}
+
+	for i, file := range flag.Args() {
+		rom, err := load(file, *eti)
+		if err != nil {
+			fail(err)
+		}
+
+		// separate the listings of each file
+		if flag.NArg() > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+
+			if opts.Syntax == chip8.SYNTAX_OCTO {
+				fmt.Println("#", filepath.Base(file))
+			} else {
+				fmt.Println(";", filepath.Base(file))
+			}
+		}
+
+		os.Stdout.Write(chip8.Disassemble(rom, base(*eti), opts))
+	}
+}
+
+// load reads a ROM file, or assembles a C8 or Octo source file into a ROM.
+func load(file string, eti bool) ([]byte, error) {
+	program, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	assemble := chip8.Assemble
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".c8", ".chip8":
+	case ".8o":
+		assemble = chip8.AssembleOcto
+	default:
+		return program, nil
+	}
+
+	asm, err := assemble(program, eti)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+
+	return asm.ROM, nil
+}
+
+// base returns the address ROMs are loaded at.
+func base(eti bool) uint {
+	if eti {
+		return 0x600
+	}
+
+	return 0x200
+}
+
+// fail writes an error and exits.
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
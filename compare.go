@@ -0,0 +1,139 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"encoding/binary"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	// Quirks are the interpreter behaviors emulated for every ROM.
+	Quirks chip8.Quirks
+
+	// Comparing is true if ROMs are run side by side with CompareQuirks.
+	Comparing bool
+
+	// CompareQuirks are the behaviors of the VM run in lockstep.
+	CompareQuirks chip8.Quirks
+
+	// MirrorScreen is the SDL streaming texture for the compared VM.
+	MirrorScreen *sdl.Texture
+)
+
+// applyQuirks sets the quirks of a newly loaded VM and starts comparing.
+func applyQuirks(vm *chip8.CHIP_8) {
+	vm.Quirks = Quirks
+
+	if Comparing {
+		vm.Compare(CompareQuirks)
+	}
+}
+
+// createMirrorScreen creates the texture for the compared VM or panics.
+func createMirrorScreen() {
+	var err error
+
+	// same format as the screen
+	format := sdl.PIXELFORMAT_RGB888
+	access := sdl.TEXTUREACCESS_STREAMING
+
+	// create a streaming texture for the display
+	MirrorScreen, err = Renderer.CreateTexture(uint32(format), access, 128, 64)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// updateMirror uploads the entire video memory of the compared VM.
+func updateMirror() {
+	m := VM.Mirror
+	w, h := m.GetResolution()
+
+	// unfiltered, so just write all the pixels
+	pixels, pitch, err := MirrorScreen.Lock(&sdl.Rect{W: int32(w), H: int32(h)})
+	if err != nil {
+		panic(err)
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := y*w + x
+			c := Background
+
+			if m.Video[p>>3]&(0x80>>uint(p&7)) != 0 {
+				c = Foreground
+			}
+
+			binary.LittleEndian.PutUint32(pixels[y*pitch+x*4:], c)
+		}
+	}
+
+	MirrorScreen.Unlock()
+}
+
+// drawComparison draws both screens side by side in the screen panel.
+func drawComparison() {
+	updateMirror()
+
+	// left is the VM, right is the mirror
+	for i, vm := range []*chip8.Snapshot{VM, VM.Mirror} {
+		vw, vh := vm.GetResolution()
+
+		// source area of the screen texture
+		src := sdl.Rect{
+			W: int32(vw),
+			H: int32(vh),
+		}
+
+		// half size, centered vertically
+		dst := sdl.Rect{
+			X: int32(10 + i*192),
+			Y: 58,
+			W: 192,
+			H: 96,
+		}
+
+		if i == 0 {
+			Renderer.Copy(Screen, &src, &dst)
+		} else {
+			Renderer.Copy(MirrorScreen, &src, &dst)
+		}
+	}
+
+	// label each screen with its quirks
+	drawText(VM.Quirks.String(), 12, 46)
+	drawText(VM.Mirror.Quirks.String(), 204, 46)
+}
+
+// logDivergence logs the differences between the VM and its mirror.
+func logDivergence(d chip8.Divergence) {
+	Debug.Log()
+	Debug.Log(d.Error())
+	Debug.Log(VM.Disassemble(d.Address))
+
+	for _, diff := range d.Diffs {
+		Debug.Log(diff)
+	}
+}
@@ -0,0 +1,636 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	// ConsoleActive is true while a command is being typed.
+	ConsoleActive bool
+
+	// ConsoleLine is the command being typed.
+	ConsoleLine string
+
+	// ConsoleHistory are the commands entered, oldest first.
+	ConsoleHistory []string
+
+	// HistoryPos is the command in the history being shown, or the length
+	// of the history when typing a new command.
+	HistoryPos int
+
+	// TraceStep is the step of the next traced instruction to log.
+	TraceStep int64
+)
+
+// toggleConsole starts or stops typing a command.
+func toggleConsole() {
+	ConsoleActive = !ConsoleActive
+
+	if ConsoleActive {
+		sdl.StartTextInput()
+	} else {
+		sdl.StopTextInput()
+	}
+}
+
+// consoleKey handles a key pressed while typing a command.
+func consoleKey(ev *sdl.KeyboardEvent) {
+	switch ev.Keysym.Scancode {
+	case sdl.SCANCODE_ESCAPE, sdl.SCANCODE_GRAVE:
+		toggleConsole()
+	case sdl.SCANCODE_RETURN, sdl.SCANCODE_KP_ENTER:
+		enterCommand()
+	case sdl.SCANCODE_BACKSPACE:
+		if n := len(ConsoleLine); n > 0 {
+			ConsoleLine = ConsoleLine[:n-1]
+		}
+	case sdl.SCANCODE_UP:
+		recall(HistoryPos - 1)
+	case sdl.SCANCODE_DOWN:
+		recall(HistoryPos + 1)
+	case sdl.SCANCODE_TAB:
+		complete()
+	case sdl.SCANCODE_PAGEUP:
+		Debug.ScrollUp()
+	case sdl.SCANCODE_PAGEDOWN:
+		Debug.ScrollDown(15)
+	}
+}
+
+// consoleText adds text typed to the command.
+func consoleText(text string) {
+	for _, c := range text {
+		if c >= 32 && c < 127 && c != '`' {
+			ConsoleLine += string(c)
+		}
+	}
+}
+
+// recall shows a command from the history.
+func recall(n int) {
+	if n < 0 || n > len(ConsoleHistory) {
+		return
+	}
+
+	if HistoryPos = n; n == len(ConsoleHistory) {
+		ConsoleLine = ""
+	} else {
+		ConsoleLine = ConsoleHistory[n]
+	}
+}
+
+// complete the label (or command) being typed, as much as is common to
+// every label it could be. If it could be more than one, they are logged.
+func complete() {
+	start := strings.LastIndexFunc(ConsoleLine, func(c rune) bool {
+		return !(c == '_' || c == '.' || (c >= '0' && c <= '9') || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z'))
+	}) + 1
+
+	prefix := strings.ToUpper(ConsoleLine[start:])
+	words := make([]string, 0, len(VM.Symbols))
+
+	// the first word is a command
+	if start == 0 {
+		words = append(words, "break", "clear", "disasm", "goto", "help", "mem", "print", "set", "sprite", "trace", "unwatch", "watch", "x")
+	} else {
+		for _, label := range VM.Symbols {
+			words = append(words, label)
+		}
+	}
+
+	// find every word that could be being typed
+	matches := make([]string, 0, len(words))
+
+	for _, w := range words {
+		if strings.HasPrefix(strings.ToUpper(w), prefix) {
+			matches = append(matches, w)
+		}
+	}
+
+	if len(matches) == 0 {
+		return
+	}
+
+	sort.Strings(matches)
+
+	// find what is common to all of them
+	common := matches[0]
+
+	for _, m := range matches[1:] {
+		for !strings.HasPrefix(strings.ToUpper(m), strings.ToUpper(common)) {
+			common = common[:len(common)-1]
+		}
+	}
+
+	if len(common) > len(prefix) {
+		ConsoleLine = ConsoleLine[:start] + common
+	} else if len(matches) > 1 {
+		Debug.Logln(strings.Join(matches, " "))
+	}
+
+	// a single command is followed by its arguments
+	if len(matches) == 1 && start == 0 {
+		ConsoleLine += " "
+	}
+}
+
+// enterCommand runs the command typed, logging any error, and adds it to
+// the history.
+func enterCommand() {
+	line := strings.TrimSpace(ConsoleLine)
+
+	ConsoleLine = ""
+
+	if line == "" {
+		return
+	}
+
+	// don't repeat the same command back to back in the history
+	if n := len(ConsoleHistory); n == 0 || ConsoleHistory[n-1] != line {
+		ConsoleHistory = append(ConsoleHistory, line)
+	}
+
+	HistoryPos = len(ConsoleHistory)
+
+	// echo the command
+	Debug.Logln(">", line)
+
+	if err := runCommand(line); err != nil {
+		Debug.Log(err.Error())
+	}
+
+	// show the results right away
+	Debug.End()
+}
+
+// runCommand parses and runs a console command.
+func runCommand(line string) error {
+	args := strings.Fields(line)
+	rest := strings.TrimSpace(line[len(args[0]):])
+
+	switch strings.ToLower(args[0]) {
+	case "help", "?":
+		consoleHelp()
+	case "print", "p":
+		return printExpr(rest)
+	case "break", "b":
+		return setBreakpoint(rest)
+	case "clear":
+		return clearBreakpoint(rest)
+	case "watch":
+		return watch(args[1:], true)
+	case "unwatch":
+		return watch(args[1:], false)
+	case "x":
+		return examine(args[1:])
+	case "set":
+		return setRegister(args[1:])
+	case "mem", "m":
+		return viewMemory(rest)
+	case "sprite":
+		return spriteCommand(args[1:])
+	case "disasm", "d":
+		return disassemble(args[1:])
+	case "goto":
+		return jump(rest)
+	case "trace":
+		return trace(args[1:])
+	default:
+		return fmt.Errorf("Unknown command %s; type help for a list", args[0])
+	}
+
+	return nil
+}
+
+// consoleHelp logs all the console commands.
+func consoleHelp() {
+	Debug.Log("Command                | Description")
+	Debug.Log("-----------------------+-----------------------------")
+	Debug.Log("print <expr>           | Show the value of <expr>")
+	Debug.Log("break                  | List breakpoints")
+	Debug.Log("break <addr> [if expr] | Break at <addr> [when expr]")
+	Debug.Log("clear <addr>           | Remove breakpoint at <addr>")
+	Debug.Log("watch                  | List watchpoints")
+	Debug.Log("watch <addr> [r|w|rw]  | Break after <addr> is used")
+	Debug.Log("unwatch <addr>         | Stop watching <addr>")
+	Debug.Log("x <addr> [n]           | Dump n bytes of memory")
+	Debug.Log("set <reg> <expr>       | Set V0-VF, I, PC, DT, ST")
+	Debug.Log("set [<addr>] <expr>    | Set a byte of memory")
+	Debug.Log("mem <addr>             | Show <addr> in memory panel")
+	Debug.Log("sprite [<addr> [n]]    | Show sprite (at I) n high")
+	Debug.Log("disasm <addr> [n]      | Disassemble n instructions")
+	Debug.Log("goto <addr>            | Continue from <addr>")
+	Debug.Log("trace on|off           | Log every instruction run")
+	Debug.Log("")
+	Debug.Log("Expressions use C operators, registers, labels, and")
+	Debug.Log("[addr] to read memory. TAB completes labels.")
+}
+
+// parseExpr parses an expression using the labels of the assembled source.
+func parseExpr(s string) (*chip8.Expr, error) {
+	if s == "" {
+		return nil, errors.New("Missing expression")
+	}
+
+	return chip8.ParseExpr(s, Source)
+}
+
+// evalExpr parses an expression and evaluates it with the VM's state.
+func evalExpr(s string) (int, error) {
+	e, err := parseExpr(s)
+	if err != nil {
+		return 0, err
+	}
+
+	return VM.Eval(e), nil
+}
+
+// evalAddress parses an expression and evaluates it as an address.
+func evalAddress(s string) (uint, error) {
+	v, err := evalExpr(s)
+
+	return uint(v) & 0xFFF, err
+}
+
+// printExpr logs the value of an expression.
+func printExpr(s string) error {
+	v, err := evalExpr(s)
+	if err != nil {
+		return err
+	}
+
+	Debug.Log(fmt.Sprintf("%s = #%04X (%d)", s, uint16(v), v))
+
+	return nil
+}
+
+// setBreakpoint sets a breakpoint at an address, with an optional
+// condition following "if", or lists all the breakpoints.
+func setBreakpoint(s string) error {
+	if s == "" {
+		listBreakpoints()
+		return nil
+	}
+
+	b := chip8.Breakpoint{Reason: "User break"}
+
+	// split the address from the condition
+	if i := strings.Index(strings.ToLower(s+" "), " if "); i >= 0 {
+		cond, err := parseExpr(strings.TrimSpace(s[i+3:]))
+		if err != nil {
+			return err
+		}
+
+		b.Condition, b.Reason, s = cond, "if "+cond.String(), s[:i]
+	}
+
+	address, err := evalAddress(s)
+	if err != nil {
+		return err
+	}
+
+	b.Address = int(address)
+
+	if b.Address < 0x200 {
+		return errors.New("Breakpoints must be at #0200 or above")
+	}
+
+	Runner.Do(func(vm *chip8.CHIP_8) { vm.SetBreakpoint(b) })
+
+	Debug.Log(fmt.Sprintf("Breakpoint at %s", VM.Location(address)))
+
+	return nil
+}
+
+// listBreakpoints logs every breakpoint, in address order.
+func listBreakpoints() {
+	addresses := make([]int, 0, len(VM.Breakpoints))
+
+	for address := range VM.Breakpoints {
+		addresses = append(addresses, address)
+	}
+
+	sort.Ints(addresses)
+
+	for _, address := range addresses {
+		Debug.Log(fmt.Sprintf("%04X %s - %s", address, VM.Location(uint(address)), VM.Breakpoints[address].Reason))
+	}
+
+	if len(addresses) == 0 {
+		Debug.Log("No breakpoints")
+	}
+}
+
+// clearBreakpoint removes the breakpoint at an address.
+func clearBreakpoint(s string) error {
+	address, err := evalAddress(s)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := VM.Breakpoints[int(address)]; !ok {
+		return fmt.Errorf("No breakpoint at %s", VM.Location(address))
+	}
+
+	Runner.Do(func(vm *chip8.CHIP_8) { vm.RemoveBreakpoint(int(address)) })
+
+	return nil
+}
+
+// watch sets or removes a watchpoint at an address, or lists all the
+// watchpoints.
+func watch(args []string, set bool) error {
+	if len(args) == 0 {
+		listWatchpoints()
+		return nil
+	}
+
+	address, err := evalAddress(args[0])
+	if err != nil {
+		return err
+	}
+
+	// writes are watched unless told otherwise
+	access := chip8.ACCESS_WRITE
+
+	if !set {
+		access = chip8.ACCESS_READ | chip8.ACCESS_WRITE
+	} else if len(args) > 1 {
+		switch strings.ToLower(args[1]) {
+		case "r":
+			access = chip8.ACCESS_READ
+		case "w":
+			access = chip8.ACCESS_WRITE
+		case "rw", "wr":
+			access = chip8.ACCESS_READ | chip8.ACCESS_WRITE
+		default:
+			return fmt.Errorf("Unknown access %s; use r, w, or rw", args[1])
+		}
+	}
+
+	Runner.Do(func(vm *chip8.CHIP_8) {
+		if set {
+			vm.SetWatchpoint(address, access)
+		} else {
+			vm.RemoveWatchpoint(address, access)
+		}
+	})
+
+	return nil
+}
+
+// listWatchpoints logs every watchpoint, in address order.
+func listWatchpoints() {
+	addresses := make([]int, 0, len(VM.Watchpoints))
+
+	for address := range VM.Watchpoints {
+		addresses = append(addresses, int(address))
+	}
+
+	sort.Ints(addresses)
+
+	for _, address := range addresses {
+		Debug.Log(fmt.Sprintf("%04X %s - %s", address, VM.Location(uint(address)), VM.Watchpoints[uint(address)]))
+	}
+
+	if len(addresses) == 0 {
+		Debug.Log("No watchpoints")
+	}
+}
+
+// splitCount splits an optional count from the end of arguments that
+// begin with an address. A trailing number is only the count when the
+// address doesn't parse with it, so "V0 + 1" is an address, while "V0 1"
+// is V0 and a count of 1.
+func splitCount(args []string, count int) (string, int, error) {
+	if len(args) == 0 {
+		return "", 0, errors.New("Missing address")
+	}
+
+	s := strings.Join(args, " ")
+
+	if len(args) > 1 {
+		if _, err := parseExpr(s); err != nil {
+			last := args[len(args)-1]
+
+			// otherwise the error is in the address
+			if n, err := strconv.Atoi(last); err == nil {
+				if n <= 0 {
+					return "", 0, fmt.Errorf("Invalid count %s", last)
+				}
+
+				return strings.Join(args[:len(args)-1], " "), n, nil
+			}
+		}
+	}
+
+	return s, count, nil
+}
+
+// examine logs bytes of memory at an address.
+func examine(args []string) error {
+	s, n, err := splitCount(args, 48)
+	if err != nil {
+		return err
+	}
+
+	address, err := evalAddress(s)
+	if err != nil {
+		return err
+	}
+
+	logMemory(address, n)
+
+	return nil
+}
+
+// setRegister sets a register or a byte of memory to the value of an
+// expression.
+func setRegister(args []string) error {
+	if len(args) < 2 {
+		return errors.New("Usage: set <reg> <expr>")
+	}
+
+	v, err := evalExpr(strings.Join(args[1:], " "))
+	if err != nil {
+		return err
+	}
+
+	name := strings.ToUpper(args[0])
+
+	// a byte of memory
+	if strings.HasPrefix(name, "[") && strings.HasSuffix(name, "]") {
+		address, err := evalAddress(name[1 : len(name)-1])
+		if err != nil {
+			return err
+		}
+
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.Poke(address, byte(v)) })
+
+		return nil
+	}
+
+	// the V registers
+	if len(name) == 2 && name[0] == 'V' {
+		if x, err := strconv.ParseUint(name[1:], 16, 4); err == nil {
+			Runner.Do(func(vm *chip8.CHIP_8) { vm.V[x] = byte(v) })
+
+			return nil
+		}
+	}
+
+	switch name {
+	case "I":
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.I = uint(v) & 0xFFFF })
+	case "PC":
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.PC = uint(v) & 0xFFF })
+	case "DT":
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.SetDelayTimer(byte(v)) })
+	case "ST":
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.SetSoundTimer(byte(v)) })
+	default:
+		return fmt.Errorf("Unknown register %s", args[0])
+	}
+
+	return nil
+}
+
+// disassemble logs instructions starting at an address, with the labels
+// of any that have one.
+func disassemble(args []string) error {
+	s, n, err := splitCount(args, 8)
+	if err != nil {
+		return err
+	}
+
+	address, err := evalAddress(s)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < n; i++ {
+		a := (address + uint(i*2)) & 0xFFF
+
+		if label, ok := VM.Symbols[a]; ok {
+			Debug.Log(label)
+		}
+
+		Debug.Log(" " + VM.Disassemble(a))
+	}
+
+	return nil
+}
+
+// viewMemory shows an address in the memory panel.
+func viewMemory(s string) error {
+	address, err := evalAddress(s)
+	if err != nil {
+		return err
+	}
+
+	showMemory(address)
+
+	return nil
+}
+
+// jump continues execution from an address.
+func jump(s string) error {
+	address, err := evalAddress(s)
+	if err != nil {
+		return err
+	}
+
+	Runner.Do(func(vm *chip8.CHIP_8) { vm.PC = address })
+
+	return nil
+}
+
+// trace starts or stops logging every instruction executed.
+func trace(args []string) error {
+	if len(args) != 1 {
+		return errors.New("Usage: trace on|off")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.StartTrace() })
+		TraceStep = 0
+	case "off":
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.StopTrace() })
+	default:
+		return errors.New("Usage: trace on|off")
+	}
+
+	return nil
+}
+
+// logTrace logs the instructions traced since the last frame.
+func logTrace() {
+	if VM.Trace == nil {
+		return
+	}
+
+	// a new trace started
+	if TraceStep > VM.Trace.Steps {
+		TraceStep = 0
+	}
+
+	pcs, dropped := VM.Trace.Since(TraceStep)
+
+	if dropped > 0 {
+		Debug.Log(fmt.Sprintf("... %d instructions not traced", dropped))
+	}
+
+	for _, pc := range pcs {
+		Debug.Log(VM.Disassemble(pc))
+	}
+
+	TraceStep = VM.Trace.Steps
+}
+
+// drawConsole shows the command being typed.
+func drawConsole() {
+	x, y := 12, 382
+
+	if !ConsoleActive {
+		drawText("Press ` to type a debugger command", x, y)
+		return
+	}
+
+	line := "> " + ConsoleLine + "_"
+
+	// show the end of the line if it doesn't fit
+	if len(line) > 84 {
+		line = line[len(line)-84:]
+	}
+
+	drawText(line, x, y)
+}
@@ -0,0 +1,197 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	// CoverageFile is where the coverage listing is written, if any.
+	CoverageFile string
+
+	// ShowHeatmap is true if the memory heatmap is shown over the screen.
+	ShowHeatmap bool
+
+	// Heatmap is the texture with a pixel for every byte of memory.
+	Heatmap *sdl.Texture
+)
+
+// createHeatmap creates the texture the heatmap is drawn to.
+func createHeatmap() {
+	var err error
+
+	// same format as the screen
+	format := sdl.PIXELFORMAT_RGB888
+	access := sdl.TEXTUREACCESS_STREAMING
+
+	// one pixel per byte of memory
+	Heatmap, err = Renderer.CreateTexture(uint32(format), access, 64, 64)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// toggleHeatmap shows or hides the memory heatmap.
+func toggleHeatmap() {
+	if ShowHeatmap = !ShowHeatmap; ShowHeatmap {
+		ShowMemory, ShowSprite = false, false
+	}
+}
+
+// updateHeatmap uploads the coverage of every byte of memory. Executed
+// bytes are red, read bytes green, and written bytes blue, brighter the
+// more they are used. Unused bytes of the program are gray.
+func updateHeatmap() {
+	c := VM.Coverage
+
+	pixels, pitch, err := Heatmap.Lock(nil)
+	if err != nil {
+		panic(err)
+	}
+
+	// scale the brightness to the most used byte
+	most := uint32(1)
+
+	for _, hits := range c.Hits {
+		if hits > most {
+			most = hits
+		}
+	}
+
+	for a := range c.Access {
+		color := Background
+
+		switch {
+		case uint(a) == VM.PC || uint(a) == VM.PC+1:
+			color = 0xFFFFFF
+		case c.Access[a] != 0:
+			s := 0.25 + 0.75*math.Log1p(float64(c.Hits[a]))/math.Log1p(float64(most))
+			v := uint32(255 * s)
+
+			// each kind of access is a color channel
+			color = 0
+
+			if c.Access[a]&chip8.ACCESS_EXEC != 0 {
+				color |= v << 16
+			}
+
+			if c.Access[a]&chip8.ACCESS_READ != 0 {
+				color |= v << 8
+			}
+
+			if c.Access[a]&chip8.ACCESS_WRITE != 0 {
+				color |= v
+			}
+		case uint(a) >= VM.Base && uint(a) < VM.Base+uint(VM.Size):
+			color = 0x404850
+		}
+
+		binary.LittleEndian.PutUint32(pixels[(a>>6)*pitch+(a&63)*4:], color)
+	}
+
+	Heatmap.Unlock()
+}
+
+// drawHeatmap draws the memory heatmap and a summary of the coverage of
+// the program in place of the screen.
+func drawHeatmap() {
+	if VM.Coverage == nil {
+		return
+	}
+
+	updateHeatmap()
+
+	// 64 bytes per row, 3x3 pixels per byte
+	Renderer.Copy(Heatmap, nil, &sdl.Rect{X: 10, Y: 10, W: 192, H: 192})
+
+	// summary of how the program was used
+	c := VM.Coverage
+	start, end := VM.Base, VM.Base+uint(VM.Size)
+
+	drawText("MEMORY COVERAGE", 214, 14)
+	drawText(fmt.Sprintf("%5d EXECUTED", c.Count(start, end, chip8.ACCESS_EXEC)), 214, 34)
+	drawText(fmt.Sprintf("%5d READ", c.Count(start, end, chip8.ACCESS_READ)), 214, 44)
+	drawText(fmt.Sprintf("%5d WRITTEN", c.Count(start, end, chip8.ACCESS_WRITE)), 214, 54)
+	drawText(fmt.Sprintf("%5d UNUSED", c.Count(start, end, 0)), 214, 64)
+
+	// legend
+	legend := []struct {
+		label   string
+		r, g, b uint8
+	}{
+		{"EXECUTE", 255, 0, 0},
+		{"READ", 0, 255, 0},
+		{"WRITE", 0, 0, 255},
+		{"UNUSED", 64, 72, 80},
+	}
+
+	for i, l := range legend {
+		y := int32(90 + i*12)
+
+		Renderer.SetDrawColor(l.r, l.g, l.b, 255)
+		Renderer.FillRect(&sdl.Rect{X: 214, Y: y, W: 7, H: 7})
+
+		drawText(l.label, 226, int(y))
+	}
+}
+
+// saveCoverage writes the coverage listing of the loaded ROM if a file
+// was given on the command line.
+func saveCoverage() {
+	var b bytes.Buffer
+	var err error
+
+	if CoverageFile == "" || File == "" || Runner == nil {
+		return
+	}
+
+	// the listing needs the memory of the runner's VM
+	Runner.Do(func(vm *chip8.CHIP_8) {
+		if vm.Coverage != nil {
+			err = vm.Coverage.Listing(&b, vm)
+		}
+	})
+
+	// nothing was tracked
+	if err == nil && b.Len() == 0 {
+		return
+	}
+
+	if err == nil {
+		err = ioutil.WriteFile(CoverageFile, b.Bytes(), 0666)
+	}
+
+	if err != nil {
+		Debug.Logln(err.Error())
+	} else {
+		Debug.Logln("Coverage saved to", filepath.Base(CoverageFile))
+	}
+}
@@ -0,0 +1,211 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"fmt"
+)
+
+// Filter is a flicker reduction filter applied between video memory and
+// the screen texture.
+type Filter int
+
+// Display filters that can be selected at runtime.
+const (
+	FILTER_NONE Filter = iota
+	FILTER_BLEND
+	FILTER_PHOSPHOR
+	FILTER_OR
+)
+
+var (
+	// DisplayFilter is the active flicker reduction filter.
+	DisplayFilter = FILTER_NONE
+
+	// BlendFrames is the number of frames averaged together when blending.
+	BlendFrames = 3
+
+	// Persistence is the fraction of brightness a phosphor keeps each frame.
+	Persistence = 0.6
+
+	// Shades is the brightness (0..1) of every pixel on the screen.
+	Shades [128 * 64]float64
+
+	// frames is a ring buffer of the most recent frames of video memory.
+	frames [8][0x400]byte
+
+	// current is the index of the most recent frame in the ring buffer.
+	current int
+
+	// framePitch is the pitch of video memory the ring buffer was filled at.
+	framePitch int
+
+	// reshade is true if every pixel needs to be shaded again.
+	reshade bool
+)
+
+// String returns the name of the filter.
+func (f Filter) String() string {
+	switch f {
+	case FILTER_BLEND:
+		return fmt.Sprintf("blend %d frames", BlendFrames)
+	case FILTER_PHOSPHOR:
+		return fmt.Sprintf("phosphor %.0f%% persistence", Persistence*100)
+	case FILTER_OR:
+		return "or last 2 frames"
+	}
+
+	return "none"
+}
+
+// ParseFilter returns the filter with a given name.
+func ParseFilter(name string) (Filter, error) {
+	switch name {
+	case "none", "":
+		return FILTER_NONE, nil
+	case "blend":
+		return FILTER_BLEND, nil
+	case "phosphor":
+		return FILTER_PHOSPHOR, nil
+	case "or":
+		return FILTER_OR, nil
+	}
+
+	return FILTER_NONE, fmt.Errorf("unknown display filter: %s", name)
+}
+
+// nextFilter cycles to the next display filter.
+func nextFilter() {
+	DisplayFilter = (DisplayFilter + 1) % (FILTER_OR + 1)
+
+	// every pixel needs to be reshaded
+	reshade = true
+
+	// log the filter now in use
+	Debug.Logln("Display filter:", DisplayFilter.String())
+}
+
+// lit returns true if pixel p is on in a frame of video memory.
+func lit(video []byte, p int) bool {
+	return video[p>>3]&(0x80>>uint(p&7)) != 0
+}
+
+// filterScreen pushes the current video memory into the frame history,
+// updates the shade of every pixel, and returns the mask of scan lines
+// that have changed and need to be uploaded to the screen texture.
+func filterScreen(dirty uint64) uint64 {
+	w, h := VM.GetResolution()
+
+	// the filter changed since the last frame
+	if reshade {
+		dirty, reshade = ^uint64(0), false
+	}
+
+	// the pitch (in bits) is the width, calculate shift
+	shift := uint(6 + (w >> 7))
+
+	// history from a different resolution is meaningless
+	if framePitch != VM.Pitch {
+		frames = [8][0x400]byte{}
+		framePitch = VM.Pitch
+	}
+
+	// push the current frame into the ring buffer
+	current = (current + 1) % len(frames)
+	copy(frames[current][:], VM.Video[:0x400])
+
+	// without a filter, only modified scan lines need shading
+	if DisplayFilter == FILTER_NONE {
+		for p := 0; p < w*h; p++ {
+			if dirty&(1<<uint(p>>shift)) != 0 {
+				if lit(VM.Video[:], p) {
+					Shades[p] = 1
+				} else {
+					Shades[p] = 0
+				}
+			}
+		}
+
+		return dirty
+	}
+
+	// number of frames to blend, clamped to the history available
+	n := BlendFrames
+	if n < 1 {
+		n = 1
+	} else if n > len(frames) {
+		n = len(frames)
+	}
+
+	// previous frame in the ring buffer
+	prev := frames[(current+len(frames)-1)%len(frames)][:]
+
+	// scan lines whose shades have changed
+	changed := dirty
+
+	for p := 0; p < w*h; p++ {
+		var v float64
+
+		switch DisplayFilter {
+		case FILTER_BLEND:
+			for i := 0; i < n; i++ {
+				if lit(frames[(current+len(frames)-i)%len(frames)][:], p) {
+					v += 1 / float64(n)
+				}
+			}
+		case FILTER_PHOSPHOR:
+			if lit(VM.Video[:], p) {
+				v = 1
+			} else if v = Shades[p] * Persistence; v < 1.0/64 {
+				v = 0
+			}
+		case FILTER_OR:
+			if lit(VM.Video[:], p) || lit(prev, p) {
+				v = 1
+			}
+		}
+
+		// track which scan lines need uploaded
+		if v != Shades[p] {
+			Shades[p] = v
+			changed |= 1 << uint(p>>shift)
+		}
+	}
+
+	return changed
+}
+
+// shade returns the RGB888 color between Background and Foreground.
+func shade(s float64) uint32 {
+	c := uint32(0)
+
+	// interpolate each color channel
+	for i := uint(0); i < 24; i += 8 {
+		bg := float64(Background >> i & 0xFF)
+		fg := float64(Foreground >> i & 0xFF)
+
+		// mix the channel and pack it
+		c |= uint32(bg+(fg-bg)*s) << i
+	}
+
+	return c
+}
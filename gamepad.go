@@ -0,0 +1,190 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Gamepad is an open SDL game controller.
+type Gamepad struct {
+	*sdl.GameController
+
+	// stick is which d-pad buttons the left stick is emulating.
+	stick map[sdl.GameControllerButton]bool
+}
+
+var (
+	// Gamepads are all the open game controllers by joystick instance.
+	Gamepads = map[sdl.JoystickID]*Gamepad{}
+
+	// PadMap of game controller buttons to CHIP-8 keys. This is the
+	// built-in default, which is overridden by bindings in KeyConfig.
+	PadMap = map[sdl.GameControllerButton]uint{
+		sdl.CONTROLLER_BUTTON_DPAD_UP:    0x5,
+		sdl.CONTROLLER_BUTTON_DPAD_LEFT:  0x7,
+		sdl.CONTROLLER_BUTTON_DPAD_DOWN:  0x8,
+		sdl.CONTROLLER_BUTTON_DPAD_RIGHT: 0x9,
+		sdl.CONTROLLER_BUTTON_A:          0x6,
+		sdl.CONTROLLER_BUTTON_B:          0x4,
+		sdl.CONTROLLER_BUTTON_X:          0x1,
+		sdl.CONTROLLER_BUTTON_Y:          0x2,
+	}
+
+	// StickDeadZone is how far the left stick must be pushed before it
+	// acts as the d-pad.
+	StickDeadZone int16 = 16384
+
+	// defaultPadMap is the built-in game controller binding.
+	defaultPadMap = copyPadmap(PadMap)
+)
+
+// copyPadmap returns a copy of a game controller binding.
+func copyPadmap(m map[sdl.GameControllerButton]uint) map[sdl.GameControllerButton]uint {
+	c := make(map[sdl.GameControllerButton]uint, len(m))
+
+	for button, key := range m {
+		c[button] = key
+	}
+
+	return c
+}
+
+// bindPadButton maps a button to a CHIP-8 key, replacing any buttons that
+// were previously bound to the same key.
+func bindPadButton(m map[sdl.GameControllerButton]uint, button sdl.GameControllerButton, key uint) {
+	for b, k := range m {
+		if k == key {
+			delete(m, b)
+		}
+	}
+
+	m[button] = key
+}
+
+// overlayPadmap replaces the bindings of every CHIP-8 key bound in src.
+func overlayPadmap(dst, src map[sdl.GameControllerButton]uint) {
+	for _, key := range src {
+		for b, k := range dst {
+			if k == key {
+				delete(dst, b)
+			}
+		}
+	}
+
+	// add all the new bindings
+	for button, key := range src {
+		dst[button] = key
+	}
+}
+
+// openGamepads opens every game controller already plugged in.
+func openGamepads() {
+	for i := 0; i < sdl.NumJoysticks(); i++ {
+		openGamepad(i)
+	}
+}
+
+// openGamepad opens the game controller at a device index.
+func openGamepad(index int) {
+	if !sdl.IsGameController(index) {
+		return
+	}
+
+	if c := sdl.GameControllerOpen(index); c != nil {
+		id := c.Joystick().InstanceID()
+
+		// the same controller may be reported more than once
+		if _, ok := Gamepads[id]; ok {
+			c.Close()
+			return
+		}
+
+		Gamepads[id] = &Gamepad{
+			GameController: c,
+			stick:          make(map[sdl.GameControllerButton]bool),
+		}
+
+		Debug.Logln("Connected", c.Name())
+	}
+}
+
+// closeGamepad closes a game controller that was unplugged.
+func closeGamepad(id sdl.JoystickID) {
+	if pad, ok := Gamepads[id]; ok {
+		Debug.Logln("Disconnected", pad.Name())
+
+		pad.Close()
+		delete(Gamepads, id)
+
+		// don't leave any keys held down
+		for _, key := range PadMap {
+			releaseKey(key)
+		}
+	}
+}
+
+// padButton presses or releases the CHIP-8 key bound to a button.
+func padButton(button sdl.GameControllerButton, down bool) {
+	if Rebinding >= 0 {
+		if down {
+			capturePadButton(button)
+		}
+	} else if key, ok := PadMap[button]; ok {
+		if down {
+			pressKey(key)
+		} else {
+			releaseKey(key)
+		}
+	}
+}
+
+// padStick converts left stick motion into d-pad button presses.
+func padStick(id sdl.JoystickID, axis sdl.GameControllerAxis, value int16) {
+	pad, ok := Gamepads[id]
+	if !ok {
+		return
+	}
+
+	// which pair of d-pad buttons the axis emulates
+	var neg, pos sdl.GameControllerButton
+
+	switch axis {
+	case sdl.CONTROLLER_AXIS_LEFTX:
+		neg, pos = sdl.CONTROLLER_BUTTON_DPAD_LEFT, sdl.CONTROLLER_BUTTON_DPAD_RIGHT
+	case sdl.CONTROLLER_AXIS_LEFTY:
+		neg, pos = sdl.CONTROLLER_BUTTON_DPAD_UP, sdl.CONTROLLER_BUTTON_DPAD_DOWN
+	default:
+		return
+	}
+
+	// press or release each direction only when it changes
+	for button, down := range map[sdl.GameControllerButton]bool{
+		neg: value < -StickDeadZone,
+		pos: value > StickDeadZone,
+	} {
+		if pad.stick[button] != down {
+			pad.stick[button] = down
+			padButton(button, down)
+		}
+	}
+}
@@ -1,9 +1,10 @@
 module github.com/massung/CHIP-8/emulator
 
-go 1.15
+go 1.18
 
 require (
-	github.com/massung/CHIP-8 v0.0.0-20200107021611-547a44545938
 	github.com/sqweek/dialog v0.0.0-20200911184034-8a3d98e8211d
 	github.com/veandco/go-sdl2 v0.4.4
 )
+
+require github.com/TheTitanrain/w32 v0.0.0-20180517000239-4f5cfb03fabf // indirect
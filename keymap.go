@@ -0,0 +1,403 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	// KeyConfig is the file key bindings are loaded from and saved to.
+	KeyConfig = "keymap.cfg"
+
+	// Keymaps are the key bindings loaded from KeyConfig. The bindings
+	// for all ROMs are under "", all others are overrides for the ROM
+	// with that (case-insensitive) file name.
+	Keymaps = map[string]map[sdl.Scancode]uint{}
+
+	// Padmaps are the game controller bindings loaded from KeyConfig,
+	// sectioned the same way as Keymaps.
+	Padmaps = map[string]map[sdl.GameControllerButton]uint{}
+
+	// Rebinding is the index into KeypadOrder of the CHIP-8 key waiting
+	// for a key press to bind to it, or -1 when not rebinding.
+	Rebinding = -1
+
+	// RebindSection is the section of Keymaps being rebound.
+	RebindSection string
+
+	// KeypadOrder is the order the CHIP-8 keys are laid out on the pad.
+	KeypadOrder = []uint{
+		0x1, 0x2, 0x3, 0xC,
+		0x4, 0x5, 0x6, 0xD,
+		0x7, 0x8, 0x9, 0xE,
+		0xA, 0x0, 0xB, 0xF,
+	}
+
+	// defaultKeyMap is the built-in key binding.
+	defaultKeyMap = copyKeymap(KeyMap)
+)
+
+// copyKeymap returns a copy of a key binding.
+func copyKeymap(m map[sdl.Scancode]uint) map[sdl.Scancode]uint {
+	c := make(map[sdl.Scancode]uint, len(m))
+
+	for code, key := range m {
+		c[code] = key
+	}
+
+	return c
+}
+
+// bindKey maps a scancode to a CHIP-8 key, replacing any scancodes that
+// were previously bound to the same key.
+func bindKey(m map[sdl.Scancode]uint, code sdl.Scancode, key uint) {
+	for c, k := range m {
+		if k == key {
+			delete(m, c)
+		}
+	}
+
+	m[code] = key
+}
+
+// loadKeymaps parses a key binding file. Each line binds an SDL key name
+// to a CHIP-8 key (e.g. `Up = 5`) or, when prefixed with `Pad `, an SDL
+// game controller button name (e.g. `Pad dpup = 5`). `[ROM]` begins a
+// section of bindings for a single ROM file. Comments begin with ';' or
+// '#'.
+func loadKeymaps(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	keymaps := map[string]map[sdl.Scancode]uint{}
+	padmaps := map[string]map[sdl.GameControllerButton]uint{}
+	section := ""
+
+	// scan each line of the file
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+
+		// skip blank lines and comments
+		if s == "" || s[0] == ';' || s[0] == '#' {
+			continue
+		}
+
+		// start of a new ROM section
+		if s[0] == '[' && s[len(s)-1] == ']' {
+			section = strings.ToUpper(strings.TrimSpace(s[1 : len(s)-1]))
+			continue
+		}
+
+		// the key name may contain (or be) '=', so split at the last one
+		i := strings.LastIndexByte(s, '=')
+		if i < 0 {
+			return fmt.Errorf("%s line %d - expected key = value", file, line)
+		}
+
+		name := strings.TrimSpace(s[:i])
+
+		// parse the CHIP-8 key as a hex digit
+		key, err := strconv.ParseUint(strings.TrimSpace(s[i+1:]), 16, 8)
+		if err != nil || key > 0xF {
+			return fmt.Errorf("%s line %d - invalid CHIP-8 key", file, line)
+		}
+
+		// game controller button binding
+		if strings.HasPrefix(strings.ToUpper(name), "PAD ") {
+			button := sdl.GameControllerGetButtonFromString(strings.TrimSpace(name[4:]))
+
+			if button == sdl.CONTROLLER_BUTTON_INVALID {
+				return fmt.Errorf("%s line %d - unknown button: %s", file, line, name[4:])
+			}
+
+			if padmaps[section] == nil {
+				padmaps[section] = map[sdl.GameControllerButton]uint{}
+			}
+
+			padmaps[section][button] = uint(key)
+			continue
+		}
+
+		code := sdl.GetScancodeFromName(name)
+
+		if code == sdl.SCANCODE_UNKNOWN {
+			return fmt.Errorf("%s line %d - unknown key: %s", file, line, name)
+		}
+
+		if keymaps[section] == nil {
+			keymaps[section] = map[sdl.Scancode]uint{}
+		}
+
+		keymaps[section][code] = uint(key)
+	}
+
+	Keymaps = keymaps
+	Padmaps = padmaps
+
+	return nil
+}
+
+// saveKeymaps writes all the key bindings back to a file.
+func saveKeymaps(file string) error {
+	var b bytes.Buffer
+
+	b.WriteString("; CHIP-8 key bindings: <SDL key name> = <CHIP-8 key>\n")
+	b.WriteString("; Bindings under [ROM] only apply to that ROM file.\n")
+
+	// write the global section first, then each ROM sorted
+	sections := make([]string, 0, len(Keymaps))
+
+	for section := range Keymaps {
+		if _, ok := Padmaps[section]; section != "" && !ok {
+			sections = append(sections, section)
+		}
+	}
+
+	for section := range Padmaps {
+		if section != "" {
+			sections = append(sections, section)
+		}
+	}
+
+	sort.Strings(sections)
+
+	for _, section := range append([]string{""}, sections...) {
+		m := Keymaps[section]
+
+		if section != "" {
+			fmt.Fprintf(&b, "\n[%s]\n", section)
+		}
+
+		// sort the scancodes so the file doesn't churn
+		codes := make([]int, 0, len(m))
+
+		for code := range m {
+			codes = append(codes, int(code))
+		}
+
+		sort.Ints(codes)
+
+		// write in keypad order so the file is easy to read
+		for _, key := range KeypadOrder {
+			for _, code := range codes {
+				if m[sdl.Scancode(code)] == key {
+					fmt.Fprintf(&b, "%s = %X\n", sdl.GetScancodeName(sdl.Scancode(code)), key)
+				}
+			}
+		}
+
+		// game controller buttons, in button order
+		for button := sdl.CONTROLLER_BUTTON_A; button < sdl.CONTROLLER_BUTTON_MAX; button++ {
+			if key, ok := Padmaps[section][button]; ok {
+				fmt.Fprintf(&b, "Pad %s = %X\n", sdl.GameControllerGetStringForButton(button), key)
+			}
+		}
+	}
+
+	return ioutil.WriteFile(file, b.Bytes(), 0666)
+}
+
+// romSection returns the Keymaps section name for a ROM file.
+func romSection(file string) string {
+	if file == "" {
+		return ""
+	}
+
+	return strings.ToUpper(filepath.Base(file))
+}
+
+// applyKeymap sets KeyMap and PadMap to the global bindings with any
+// overrides for a ROM file applied on top.
+func applyKeymap(file string) {
+	KeyMap = copyKeymap(defaultKeyMap)
+	PadMap = copyPadmap(defaultPadMap)
+
+	// global bindings replace the built-in ones
+	overlayKeymap(KeyMap, Keymaps[""])
+	overlayPadmap(PadMap, Padmaps[""])
+
+	// then the bindings for just this ROM
+	if file != "" {
+		overlayKeymap(KeyMap, Keymaps[romSection(file)])
+		overlayPadmap(PadMap, Padmaps[romSection(file)])
+	}
+}
+
+// overlayKeymap replaces the bindings of every CHIP-8 key bound in src.
+func overlayKeymap(dst, src map[sdl.Scancode]uint) {
+	for _, key := range src {
+		for c, k := range dst {
+			if k == key {
+				delete(dst, c)
+			}
+		}
+	}
+
+	// add all the new bindings
+	for code, key := range src {
+		dst[code] = key
+	}
+}
+
+// startRebind opens the dialog that binds each CHIP-8 key in turn to the
+// next key pressed. If global is false and a ROM is loaded, the bindings
+// are saved for that ROM only.
+func startRebind(global bool) {
+	RebindSection = ""
+
+	if !global {
+		RebindSection = romSection(File)
+	}
+
+	// start with the first key on the pad
+	Rebinding = 0
+
+	// describe what's being rebound
+	if RebindSection == "" {
+		Debug.Logln("Rebinding keys for all ROMs; ESC to cancel")
+	} else {
+		Debug.Logln("Rebinding keys for", RebindSection+"; ESC to cancel")
+	}
+
+	// no keys should be left held down
+	Runner.Send(func(vm *chip8.CHIP_8) { vm.Keys = [16]bool{} })
+}
+
+// captureKey binds the key pressed while the rebind dialog is open to the
+// current CHIP-8 key and advances to the next one.
+func captureKey(code sdl.Scancode) {
+	if code == sdl.SCANCODE_ESCAPE {
+		Debug.Log("Key binding cancelled")
+
+		// restore the bindings in use before the dialog was opened
+		Rebinding = -1
+		applyKeymap(File)
+
+		return
+	}
+
+	bindKey(KeyMap, code, KeypadOrder[Rebinding])
+
+	nextRebind()
+}
+
+// capturePadButton binds the game controller button pressed while the
+// rebind dialog is open to the current CHIP-8 key.
+func capturePadButton(button sdl.GameControllerButton) {
+	bindPadButton(PadMap, button, KeypadOrder[Rebinding])
+
+	nextRebind()
+}
+
+// nextRebind advances the rebind dialog to the next key on the pad, and
+// saves all the bindings once every key has been bound.
+func nextRebind() {
+	if Rebinding++; Rebinding < len(KeypadOrder) {
+		return
+	}
+
+	// done, save all 16 bindings
+	Rebinding = -1
+	Keymaps[RebindSection] = copyKeymap(KeyMap)
+	Padmaps[RebindSection] = copyPadmap(PadMap)
+
+	if err := saveKeymaps(KeyConfig); err != nil {
+		Debug.Log(err.Error())
+	} else {
+		Debug.Log("Key bindings saved to", KeyConfig)
+	}
+}
+
+// drawRebind draws the rebind dialog over the screen: the CHIP-8 keypad
+// with the keyboard key bound to each, highlighting the one to press.
+func drawRebind() {
+	Renderer.SetDrawColor(32, 42, 53, 255)
+	Renderer.FillRect(&sdl.Rect{X: 10, Y: 10, W: 384, H: 192})
+
+	drawText("PRESS A KEY OR BUTTON FOR EACH CHIP-8 KEY", 58, 16)
+
+	for i, key := range KeypadOrder {
+		x := int32(26 + (i&3)*90)
+		y := int32(34 + (i>>2)*40)
+
+		// highlight the key waiting to be bound
+		if i == Rebinding {
+			Renderer.SetDrawColor(176, 32, 57, 255)
+			Renderer.FillRect(&sdl.Rect{X: x, Y: y, W: 84, H: 34})
+		}
+
+		frame(x, y, 84, 34)
+
+		// find the keyboard key bound to it
+		name := "-"
+		for code, k := range KeyMap {
+			if k == key {
+				name = strings.ToUpper(sdl.GetScancodeName(code))
+			}
+		}
+
+		if len(name) > 11 {
+			name = name[:11]
+		}
+
+		// find the game controller button bound to it
+		button := ""
+		for b, k := range PadMap {
+			if k == key {
+				button = strings.ToUpper(sdl.GameControllerGetStringForButton(b))
+			}
+		}
+
+		if len(button) > 8 {
+			button = button[:8]
+		}
+
+		drawText(fmt.Sprintf("%X", key), int(x)+4, int(y)+4)
+		drawText(button, int(x)+80-len(button)*7, int(y)+4)
+		drawText(name, int(x)+4, int(y)+22)
+	}
+}
+
+// initKeymaps loads the key binding file, if there is one.
+func initKeymaps() {
+	if err := loadKeymaps(KeyConfig); err != nil && !os.IsNotExist(err) {
+		Debug.Logln(err.Error())
+	}
+
+	applyKeymap(File)
+}
@@ -30,21 +30,24 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"math"
-	"math/rand"
+	"net"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"time"
 
-	"github.com/massung/CHIP-8/chip8"
+	"github.com/massung/CHIP-8/emulator/chip8"
 	"github.com/sqweek/dialog"
 	"github.com/veandco/go-sdl2/sdl"
 )
 
 var (
-	// VM is the CHIP-8 virtual machine.
-	VM *chip8.CHIP_8
+	// Runner runs the CHIP-8 virtual machine on its own goroutine.
+	Runner *chip8.Runner
+
+	// VM is the latest snapshot of the CHIP-8 virtual machine's state.
+	VM *chip8.Snapshot
 
 	// Window is the global SDL window.
 	Window *sdl.Window
@@ -52,9 +55,15 @@ var (
 	// Renderer is the global SDL renderer.
 	Renderer *sdl.Renderer
 
-	// Screen is the global SDL render target for the VM's video memory.
+	// Screen is the global SDL streaming texture for the VM's video memory.
 	Screen *sdl.Texture
 
+	// Background is the RGB888 color of an unlit pixel.
+	Background uint32 = 0x8F9185
+
+	// Foreground is the RGB888 color of a lit pixel.
+	Foreground uint32 = 0x111D2B
+
 	// Font is a fixed-width, bitmap font.
 	Font *sdl.Texture
 
@@ -64,12 +73,15 @@ var (
 	// ETI is true if ROM starts at 0x600 instead of 0x200.
 	ETI bool
 
-	// Paused is true if emulation is paused (single stepping).
-	Paused bool
-
 	// File is the currently opened ROM/C8.
 	File string
 
+	// Source is the assembly of the opened C8 file, or nil for a ROM.
+	Source *chip8.Assembly
+
+	// Commands are run on the main goroutine, sent from others.
+	Commands = make(chan func(), 16)
+
 	// Address is the current start address for disassembled instructions.
 	Address uint
 
@@ -79,7 +91,11 @@ var (
 	// ObtainedSpec is the spec opened for the device.
 	ObtainedSpec *sdl.AudioSpec
 
-	// KeyMap of modern keyboard keys to CHIP-8 keys.
+	// Muted is true if the buzzer is silenced.
+	Muted bool
+
+	// KeyMap of modern keyboard keys to CHIP-8 keys. This is the built-in
+	// default, which is overridden by bindings in KeyConfig.
 	KeyMap = map[sdl.Scancode]uint{
 		sdl.SCANCODE_X: 0x0,
 		sdl.SCANCODE_1: 0x1,
@@ -128,10 +144,6 @@ func init() {
 }
 
 func main() {
-	if err := sdl.Init(sdl.INIT_VIDEO | sdl.INIT_AUDIO); err != nil {
-		panic(err)
-	}
-
 	// create a new debug log
 	Debug = NewLog()
 
@@ -139,13 +151,77 @@ func main() {
 	Debug.Log("CHIP-8, Copyright 2017 by Jeffrey Massung")
 	Debug.Log("All rights reserved")
 
-	// initialize random number generation for VM
-	rand.Seed(time.Now().UTC().UnixNano())
-
 	// parse the command line
 	flag.BoolVar(&ETI, "eti", false, "Start ROM at 0x600 for ETI-660.")
+	flag.IntVar(&BlendFrames, "blend", BlendFrames, "Number of frames to blend (1-8).")
+	flag.Float64Var(&Persistence, "persistence", Persistence, "Phosphor brightness kept each frame (0-1).")
+	filter := flag.String("filter", "none", "Display filter: none, blend, phosphor, or.")
+	wave := flag.String("wave", "square", "Buzzer waveform: square, triangle.")
+	flag.Float64Var(&Tone, "tone", Tone, "Buzzer pitch in Hz.")
+	flag.Float64Var(&Volume, "volume", Volume, "Buzzer volume (0-1).")
+	flag.BoolVar(&Muted, "mute", false, "Start with the buzzer muted.")
+	flag.StringVar(&KeyConfig, "keys", KeyConfig, "Key binding file.")
+	flag.StringVar(&MovieFile, "record", "", "Record all inputs to a movie file.")
+	play := flag.String("play", "", "Play back a movie file.")
+	quirks := flag.String("quirks", "none", "Quirks: a platform or comma separated list.")
+	compare := flag.String("compare", "", "Run a second VM with these quirks and compare.")
+	headless := flag.Bool("headless", false, "Play back a movie without a window and print the result.")
+	flag.StringVar(&ProfileFile, "profile", "", "Profile the ROM and write a report to a file.")
+	flag.StringVar(&PprofFile, "pprof", "", "Profile the ROM and write a pprof profile to a file.")
+	flag.StringVar(&CoverageFile, "coverage", "", "Write a listing of the ROM annotated with coverage to a file.")
+	gdb := flag.Int("gdb", 0, "Serve the GDB remote protocol on a local TCP port.")
+	dap := flag.Int("dap", 0, "Serve the Debug Adapter Protocol on a local TCP port.")
 	flag.Parse()
 
+	// play back a movie without SDL and exit
+	if *headless {
+		if err := runHeadless(flag.Arg(0), *play); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if err := sdl.Init(sdl.INIT_VIDEO | sdl.INIT_AUDIO | sdl.INIT_GAMECONTROLLER); err != nil {
+		panic(err)
+	}
+
+	// load key bindings before any ROM
+	initKeymaps()
+
+	// pick the buzzer waveform
+	if w, err := ParseWaveform(*wave); err != nil {
+		Debug.Logln(err.Error())
+	} else {
+		Wave = w
+	}
+
+	// pick the initial display filter
+	if f, err := ParseFilter(*filter); err != nil {
+		Debug.Logln(err.Error())
+	} else {
+		DisplayFilter = f
+	}
+
+	// parse the quirks emulated
+	if q, err := chip8.ParseQuirks(*quirks); err != nil {
+		Debug.Logln(err.Error())
+	} else {
+		Quirks = q
+	}
+
+	// parse the quirks to compare against
+	if *compare != "" {
+		if q, err := chip8.ParseQuirks(*compare); err != nil {
+			Debug.Logln(err.Error())
+		} else {
+			Comparing, CompareQuirks = true, q
+
+			Debug.Logln("Comparing quirks", Quirks.String(), "with", q.String())
+		}
+	}
+
 	// if launching in ETI mode, note that
 	if ETI {
 		Debug.Logln("Running in ETI-660 mode")
@@ -158,19 +234,39 @@ func main() {
 		unload()
 	}
 
+	// start playing back a movie
+	if *play != "" {
+		playMovie(*play)
+	}
+
+	// let debuggers connect
+	if *gdb != 0 {
+		serveGDB(*gdb)
+	}
+
+	// let editors connect
+	if *dap != 0 {
+		serveDAP(*dap)
+	}
+
 	// create the main window, renderer, and screen or panic
 	createWindow()
 	loadFont()
 	initAudio()
+	openGamepads()
 
-	// set processor speed and refresh rate
-	clock := time.NewTicker(time.Millisecond)
+	// set refresh rate
 	video := time.NewTicker(time.Second / 60)
 	sound := time.NewTicker(time.Second / 60)
 
 	// notify that the main loop has started
 	Debug.Logln("Starting program; press 'H' for help")
 
+	// save any recording, profile, and coverage when the window is closed
+	defer stopRecording()
+	defer stopProfiling()
+	defer saveCoverage()
+
 	// loop until window closed or user quit
 	for processEvents() {
 		select {
@@ -178,19 +274,10 @@ func main() {
 			updateSound()
 		case <-video.C:
 			redraw()
-		case <-clock.C:
-			res := VM.Process(Paused)
-
-			switch breakpoint := res.(type) {
-			case chip8.Breakpoint:
-				if !breakpoint.Once {
-					Debug.Log()
-					Debug.Log(res.Error())
-				}
-
-				// break the emulation
-				Paused = true
-			}
+		case err := <-Runner.Events:
+			logEvent(err)
+		case cmd := <-Commands:
+			cmd()
 		}
 	}
 }
@@ -203,7 +290,7 @@ func createWindow() {
 	flags := sdl.WINDOW_OPENGL
 
 	// create the window and renderer
-	Window, Renderer, err = sdl.CreateWindowAndRenderer(614, 380, uint32(flags))
+	Window, Renderer, err = sdl.CreateWindowAndRenderer(614, 400, uint32(flags))
 	if err != nil {
 		panic(err)
 	}
@@ -216,13 +303,19 @@ func createWindow() {
 
 	// desired screen format and access
 	format := sdl.PIXELFORMAT_RGB888
-	access := sdl.TEXTUREACCESS_TARGET
+	access := sdl.TEXTUREACCESS_STREAMING
 
-	// create a render target for the display
+	// create a streaming texture for the display
 	Screen, err = Renderer.CreateTexture(uint32(format), access, 128, 64)
 	if err != nil {
 		panic(err)
 	}
+
+	// the compared VM has its own screen
+	createMirrorScreen()
+
+	// and the memory heatmap
+	createHeatmap()
 }
 
 // setIcon unzips the Icon data and sets it on the window.
@@ -246,54 +339,6 @@ func setIcon() {
 	}
 }
 
-// initAudio initializes an audio device for the CHIP-8 virtual machine.
-func initAudio() {
-	var err error
-
-	// the desired audio specification
-	desiredSpec := &sdl.AudioSpec{
-		Freq:     64 * 60,
-		Format:   sdl.AUDIO_F32LSB,
-		Channels: 1,
-		Samples:  64,
-	}
-
-	ObtainedSpec = &sdl.AudioSpec{}
-
-	// open the device and start playing it
-	if sdl.GetNumAudioDevices(false) > 0 {
-		if AudioDevice, err = sdl.OpenAudioDevice("", false, desiredSpec, ObtainedSpec, sdl.AUDIO_ALLOW_ANY_CHANGE); err != nil {
-			panic(err)
-		}
-
-		sdl.PauseAudioDevice(AudioDevice, false)
-	}
-}
-
-func updateSound() {
-	if AudioDevice != 0 {
-		sample := make([]byte, 4)
-
-		// set the sample sample bytes
-		if time.Now().UnixNano() < VM.ST {
-			binary.LittleEndian.PutUint32(sample, math.Float32bits(1.0))
-		}
-
-		// N channels, each channel has S samples (4 bytes each)
-		n := int(ObtainedSpec.Channels) * int(ObtainedSpec.Samples) * 4
-		data := make([]byte, n)
-
-		// 128 samples per 1/60 of a second
-		for i := 0; i < n; i += 4 {
-			copy(data[i:], sample)
-		}
-
-		if err := sdl.QueueAudio(AudioDevice, data); err != nil {
-			println(err)
-		}
-	}
-}
-
 // loadFont loads the bitmap surface with font on it.
 func loadFont() {
 	var surface *sdl.Surface
@@ -323,14 +368,51 @@ func processEvents() bool {
 			return false
 		case *sdl.DropEvent:
 			load(ev.File)
+		case *sdl.ControllerDeviceEvent:
+			if ev.Type == sdl.CONTROLLERDEVICEADDED {
+				openGamepad(int(ev.Which))
+			} else if ev.Type == sdl.CONTROLLERDEVICEREMOVED {
+				closeGamepad(ev.Which)
+			}
+		case *sdl.MouseButtonEvent:
+			if ev.Type == sdl.MOUSEBUTTONDOWN && ev.Button == sdl.BUTTON_LEFT {
+				clickCallStack(ev.X, ev.Y)
+				clickMemory(ev.X, ev.Y)
+			}
+		case *sdl.ControllerButtonEvent:
+			padButton(sdl.GameControllerButton(ev.Button), ev.Type == sdl.CONTROLLERBUTTONDOWN)
+		case *sdl.ControllerAxisEvent:
+			padStick(ev.Which, sdl.GameControllerAxis(ev.Axis), ev.Value)
+		case *sdl.TextInputEvent:
+			if ConsoleActive {
+				consoleText(ev.GetText())
+			}
 		case *sdl.KeyboardEvent:
-			if ev.Type == sdl.KEYUP {
+			if Rebinding >= 0 {
+				if ev.Type == sdl.KEYDOWN && ev.Repeat == 0 {
+					captureKey(ev.Keysym.Scancode)
+				}
+			} else if ConsoleActive && ev.Type == sdl.KEYDOWN {
+				consoleKey(ev)
+			} else if ev.Type == sdl.KEYUP {
 				if key, ok := KeyMap[ev.Keysym.Scancode]; ev.Type == sdl.KEYUP && ok {
-					VM.ReleaseKey(key)
+					releaseKey(key)
+				} else if ev.Keysym.Scancode == sdl.SCANCODE_TAB {
+					Runner.Turbo(false)
 				}
 			} else {
+				// the memory panel takes navigation and hex keys
+				if ShowMemory && memoryKey(ev.Keysym.Scancode) {
+					continue
+				}
+
+				// the sprite viewer takes navigation keys
+				if ShowSprite && spriteKey(ev.Keysym.Scancode) {
+					continue
+				}
+
 				if key, ok := KeyMap[ev.Keysym.Scancode]; ok {
-					VM.PressKey(key)
+					pressKey(key)
 				} else {
 					switch ev.Keysym.Scancode {
 					case sdl.SCANCODE_ESCAPE:
@@ -355,36 +437,60 @@ func processEvents() bool {
 						save()
 					case sdl.SCANCODE_H:
 						help()
+					case sdl.SCANCODE_GRAVE:
+						toggleConsole()
+					case sdl.SCANCODE_M:
+						toggleMute()
+					case sdl.SCANCODE_K:
+						startRebind(ev.Keysym.Mod&sdl.KMOD_SHIFT != 0)
+					case sdl.SCANCODE_P:
+						toggleProfiling()
+					case sdl.SCANCODE_U:
+						toggleHeatmap()
+					case sdl.SCANCODE_O:
+						toggleMemory()
+					case sdl.SCANCODE_G:
+						toggleSprite()
+					case sdl.SCANCODE_T:
+						toggleCallStack()
+					case sdl.SCANCODE_LEFT:
+						selectFrame(SelectedFrame - 1)
+					case sdl.SCANCODE_RIGHT:
+						selectFrame(SelectedFrame + 1)
 					case sdl.SCANCODE_LEFTBRACKET:
-						VM.DecSpeed()
+						if !VM.Playing {
+							Runner.Send(func(vm *chip8.CHIP_8) { vm.DecSpeed() })
+						}
 					case sdl.SCANCODE_RIGHTBRACKET:
-						VM.IncSpeed()
+						if !VM.Playing {
+							Runner.Send(func(vm *chip8.CHIP_8) { vm.IncSpeed() })
+						}
+					case sdl.SCANCODE_TAB:
+						if ev.Repeat == 0 {
+							Runner.Turbo(true)
+						}
+					case sdl.SCANCODE_PERIOD:
+						Runner.FrameAdvance()
 					case sdl.SCANCODE_F5, sdl.SCANCODE_SPACE:
-						Paused = !Paused
+						Runner.TogglePause()
 					case sdl.SCANCODE_F6, sdl.SCANCODE_F10:
-						if Paused {
-							if VM.StepOverBreakpoint() {
-								Paused = false
-							} else {
-								VM.Step()
-							}
-						}
+						Runner.StepOver()
 					case sdl.SCANCODE_F7, sdl.SCANCODE_F11:
-						if Paused {
-							if ev.Keysym.Mod&sdl.KMOD_SHIFT != 0 {
-								VM.StepOut()
-							} else {
-								VM.Step()
-							}
+						if ev.Keysym.Mod&sdl.KMOD_SHIFT != 0 {
+							Runner.StepOut()
+						} else {
+							Runner.Step()
 						}
 					case sdl.SCANCODE_F8:
-						if Paused {
+						if VM.Paused {
 							dumpMemory()
 						}
 					case sdl.SCANCODE_F9:
-						if Paused {
-							VM.ToggleBreakpoint()
+						if VM.Paused {
+							Runner.Send(func(vm *chip8.CHIP_8) { vm.ToggleBreakpoint() })
 						}
+					case sdl.SCANCODE_F12:
+						nextFilter()
 					}
 				}
 			}
@@ -400,16 +506,28 @@ func help() {
 	Debug.Log("------------+-------------------------------------")
 	Debug.Log("BACK        | Reboot (CTRL to break on reset)")
 	Debug.Log("[ / ]       | Deacrease/increase speed")
+	Debug.Log("TAB         | Turbo (hold)")
 	Debug.Log("HOME / END  | Scroll log")
 	Debug.Log("PGUP / PGDN | Scroll log")
 	Debug.Log("F2          | Reload ROM/C8 assember")
 	Debug.Log("F3          | Open ROM/C8 assembler")
-	Debug.Log("F4          | Save ROM")
+	Debug.Log("F4          | Save ROM (or .c8/.8o source)")
+	Debug.Log("M           | Mute/unmute buzzer")
+	Debug.Log("K           | Rebind keys for ROM (SHIFT for all)")
+	Debug.Log("P           | Start/stop profiling")
+	Debug.Log("U           | Show/hide memory heatmap")
+	Debug.Log("O           | Show/hide memory panel")
+	Debug.Log("G           | Show/hide sprite viewer")
+	Debug.Log("T           | Show/hide call stack")
+	Debug.Log("LEFT / RIGHT| Select call stack frame")
 	Debug.Log("F5          | Pause/break")
 	Debug.Log("F6 / F10    | Step over")
 	Debug.Log("F7 / F11    | Step into (SHIFT to step out)")
 	Debug.Log("F8          | Debug memory")
 	Debug.Log("F9          | Toggle breakpoint")
+	Debug.Log(".           | Advance one frame (while paused)")
+	Debug.Log("F12         | Cycle display filter")
+	Debug.Log("`           | Type a debugger command (help)")
 }
 
 // save launches a dialog allowing the user to save the current ROM.
@@ -419,6 +537,8 @@ func save() error {
 	dlg.Filter("All Files", "*")
 	dlg.Filter("Binary Files", "bin")
 	dlg.Filter("ROM Files", "rom")
+	dlg.Filter("C8 Assembler Files", "c8", "chip8")
+	dlg.Filter("Octo Files", "8o")
 
 	// pick a file to save to
 	file, err := dlg.Save()
@@ -426,7 +546,14 @@ func save() error {
 	if err != nil {
 		Debug.Logln(err.Error())
 	} else {
-		err = VM.SaveROM(file, false)
+		ext := strings.ToLower(filepath.Ext(file))
+
+		// assembler files are saved as disassembled source
+		if ext == ".c8" || ext == ".chip8" || ext == ".8o" {
+			err = VM.SaveSource(file)
+		} else {
+			err = VM.SaveROM(file, false)
+		}
 
 		if err == nil {
 			Debug.Logln("ROM saved to", filepath.Base(file))
@@ -445,6 +572,7 @@ func open() error {
 	// types of files to load
 	dlg.Filter("All Files", "*")
 	dlg.Filter("C8 Assembler Files", "c8", "chip8")
+	dlg.Filter("Octo Files", "8o")
 	dlg.Filter("ROMs", "rom", "")
 
 	// try and load it
@@ -459,7 +587,10 @@ func open() error {
 
 // load a ROM/C8 file.
 func load(file string) error {
-	var err error
+	// finish recording, profiling, and covering the previous ROM
+	stopRecording()
+	stopProfiling()
+	saveCoverage()
 
 	// log what is being loaded
 	Debug.Logln("Loading", filepath.Base(file))
@@ -467,14 +598,31 @@ func load(file string) error {
 	// save the (attempted) loaded file
 	File = file
 
+	// use the key bindings for this file
+	applyKeymap(file)
+
 	// attempt to assemble/load the file
-	if VM, err = chip8.LoadFile(file, ETI); err != nil {
+	vm, asm, err := chip8.LoadProgram(file, ETI)
+	if err != nil {
 		Debug.Log(err.Error())
 
 		// load a dummy ROM so something is there
-		VM, _ = chip8.LoadROM(chip8.Dummy, false)
+		vm, _ = chip8.LoadROM(chip8.Dummy, false)
+		Source = nil
+		run(vm)
 	} else {
-		Debug.Log(fmt.Sprint(VM.Size), "bytes")
+		Debug.Log(fmt.Sprint(vm.Size), "bytes")
+
+		// keep the source for mapping addresses to lines
+		Source = asm
+
+		// record and profile everything from the start
+		run(vm)
+		startRecording()
+
+		if ProfileFile != "" || PprofFile != "" {
+			startProfiling()
+		}
 	}
 
 	return err
@@ -486,107 +634,217 @@ func unload() {
 		Debug.Logln("Unloading ROM")
 	}
 
-	// create the new VM with the boot ROM
-	VM, _ = chip8.LoadROM(chip8.Boot, false)
+	// finish recording, profiling, and covering the previous ROM
+	stopRecording()
+	stopProfiling()
+	saveCoverage()
 
-	// no longer paused
-	Paused = false
+	// create the new VM with the boot ROM
+	vm, _ := chip8.LoadROM(chip8.Boot, false)
+	run(vm)
 
 	// clear the loaded file
-	File = ""
+	File, Source = "", nil
+
+	// back to the global key bindings
+	applyKeymap(File)
+}
+
+// run a newly loaded virtual machine, starting the runner if needed.
+func run(vm *chip8.CHIP_8) {
+	applyQuirks(vm)
+
+	// always track coverage for the heatmap
+	vm.StartCoverage()
+
+	if Runner == nil {
+		Runner = chip8.NewRunner(vm)
+	} else {
+		Runner.Load(vm)
+	}
+
+	// wait for the new VM to be running
+	Runner.Do(func(*chip8.CHIP_8) {})
+
+	// read the new state, leaving its changed lines for the next redraw
+	VM = Runner.Latest()
+}
+
+// serveGDB listens for GDB connections on a local port.
+func serveGDB(port int) {
+	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		Debug.Logln(err.Error())
+		return
+	}
+
+	Debug.Logln("GDB server listening on", l.Addr().String())
+
+	go chip8.ServeGDB(l, Runner)
+}
+
+// serveDAP listens for debug adapter clients on a local port.
+func serveDAP(port int) {
+	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		Debug.Logln(err.Error())
+		return
+	}
+
+	Debug.Logln("DAP server listening on", l.Addr().String())
+
+	go chip8.ServeDAP(l, Runner, launch)
+}
+
+// launch a program for a debug adapter client, loading it on the main
+// goroutine, and return its assembly.
+func launch(file string) (*chip8.Assembly, error) {
+	var asm *chip8.Assembly
+
+	// wait for the program to be loaded
+	done := make(chan error)
+
+	Commands <- func() {
+		err := load(file)
+		asm = Source
+
+		done <- err
+	}
+
+	err := <-done
+
+	return asm, err
 }
 
 // reboot the emulator, restarting the loaded virtual machine ROM.
 func reboot(breakOnReset bool) {
-	Paused = breakOnReset
+	if VM.Playing {
+		Debug.Logln("Movie playback stopped")
+	}
+
+	// resetting would desynchronize a movie being played back
+	Runner.Send(func(vm *chip8.CHIP_8) { vm.Playback = nil })
 
 	// reset registers and memory
-	VM.Reset()
+	Runner.Reset(breakOnReset)
 }
 
-// dumpMemory shows the next 48 bytes at the I register.
+// logEvent logs a breakpoint or error sent by the runner.
+func logEvent(err error) {
+	switch e := err.(type) {
+	case chip8.Divergence:
+		logDivergence(e)
+	case chip8.Breakpoint:
+		if !e.Once {
+			Debug.Log()
+			Debug.Log(err.Error())
+		}
+	default:
+		if err == chip8.EndOfMovie {
+			Debug.Logln("Movie playback finished")
+		} else {
+			Debug.Logln(err.Error())
+		}
+	}
+}
+
+// dumpMemory shows the next 72 bytes at the I register.
 func dumpMemory() {
 	Debug.Logln("Memory dump at I...")
 
-	// starting address
-	a := int(VM.I)
-
-	// 12 bytes will be written here
-	s := make([]string, 20)
-
-	// show 6 lines of 12 bytes each
-	for line := 0; line < 6; line++ {
-		n := a + line*12
+	logMemory(VM.I, 72)
+}
 
-		// memory address
-		s[0] = fmt.Sprintf(" %04X -", n)
+// logMemory logs n bytes of memory from an address, 12 bytes per line.
+func logMemory(a uint, n int) {
+	for line := 0; line < n; line += 12 {
+		s := []string{fmt.Sprintf(" %04X -", (a+uint(line))&0xFFF)}
 
-		// fill in the 12-byte row
-		for i := 0; i < 12; i++ {
-			if n+i < 0x10000 {
-				s[i+1] = fmt.Sprintf("%02X", VM.Memory[n+i])
-			} else {
-				s[i+1] = ""
-			}
+		// fill in the row, wrapping around memory
+		for i := line; i < line+12 && i < n; i++ {
+			s = append(s, fmt.Sprintf("%02X", VM.Memory[(a+uint(i))&0xFFF]))
 		}
 
 		Debug.Log(s...)
 	}
 }
 
-// updateScreen with the CHIP-8 video memory.
+// updateScreen filters the CHIP-8 video memory and uploads the scan lines
+// that have changed since the last frame to the screen texture.
 func updateScreen() {
-	if err := Renderer.SetRenderTarget(Screen); err != nil {
-		panic(err)
-	}
-
-	// the background color for the screen
-	Renderer.SetDrawColor(143, 145, 133, 255)
-	Renderer.Clear()
-
-	// set the pixel color
-	Renderer.SetDrawColor(17, 29, 43, 255)
-
-	// redraw only the dimensions of the video
 	w, h := VM.GetResolution()
 
 	// the pitch (in bits) is the width, calculate shift
 	shift := uint(6 + (w >> 7))
 
-	// draw all the pixels
-	for p := 0; p < w*h; p++ {
-		if VM.Video[p>>3]&(0x80>>uint(p&7)) != 0 {
-			x := int32(p & (w - 1))
-			y := int32(p >> shift)
+	// get - and acknowledge - the modified scan lines, then filter them
+	dirty := filterScreen(VM.Dirty)
 
-			// render the pixel to the screen
-			Renderer.DrawPoint(x, y)
+	// find runs of consecutive modified scan lines
+	for y := 0; y < h; y++ {
+		if dirty&(1<<uint(y)) == 0 {
+			continue
+		}
+
+		// find the end of this run
+		n := 1
+		for y+n < h && dirty&(1<<uint(y+n)) != 0 {
+			n++
+		}
+
+		// lock only the rows being updated
+		pixels, pitch, err := Screen.Lock(&sdl.Rect{Y: int32(y), W: int32(w), H: int32(n)})
+		if err != nil {
+			panic(err)
 		}
-	}
 
-	// restore the render target
-	Renderer.SetRenderTarget(nil)
+		// write every pixel in the locked rows
+		for p := y << shift; p < (y+n)<<shift; p++ {
+			i := (p>>shift-y)*pitch + (p&(w-1))*4
+
+			// RGB888 is packed 32-bit
+			binary.LittleEndian.PutUint32(pixels[i:], shade(Shades[p]))
+		}
+
+		Screen.Unlock()
+
+		// skip past the run
+		y += n
+	}
 }
 
 // clear the renderer, redraw everything, and present.
 func redraw() {
+	VM = Runner.Snapshot()
+
+	// log instructions executed since the last frame
+	logTrace()
+
+	// upload changed scan lines
 	updateScreen()
 
 	// clear the renderer
 	Renderer.SetDrawColor(32, 42, 53, 255)
 	Renderer.Clear()
 
-	// frame the screen, instructions, log, and registers
+	// frame the screen, instructions, log, registers, and console
 	frame(8, 8, 386, 194)
 	frame(8, 208, 386, 164)
 	frame(402, 8, 204, 194)
 	frame(402, 208, 204, 164)
+	frame(8, 378, 598, 14)
 
-	// draw the screen, log, instructions, and registers
+	// draw the screen, log, instructions, registers, and console
 	drawScreen()
 	drawLog()
 	drawInstructions()
 	drawRegisters()
+	drawConsole()
+
+	// the key binding dialog covers the screen
+	if Rebinding >= 0 {
+		drawRebind()
+	}
 
 	// show it
 	Renderer.Present()
@@ -594,6 +852,26 @@ func redraw() {
 
 // copyScreen to the render target at a given location.
 func drawScreen() {
+	if ShowMemory {
+		drawMemory()
+		return
+	}
+
+	if ShowSprite {
+		drawSprite()
+		return
+	}
+
+	if ShowHeatmap {
+		drawHeatmap()
+		return
+	}
+
+	if VM.Mirror != nil {
+		drawComparison()
+		return
+	}
+
 	vw, vh := VM.GetResolution()
 
 	// source area of the screen target
@@ -655,19 +933,60 @@ func drawLog() {
 	}
 }
 
+// instructionRow is a line of the instruction view: either the label of
+// the instruction that follows or a disassembled instruction.
+type instructionRow struct {
+	address uint
+	label   string
+}
+
+// instructionRows returns the rows of the instruction view starting at
+// an address, with labeled instructions preceded by their label.
+func instructionRows(address uint) []instructionRow {
+	rows := make([]instructionRow, 0, 19)
+
+	for a := address; len(rows) < 19; a += 2 {
+		if label, ok := VM.Symbols[a]; ok && len(rows) < 18 {
+			rows = append(rows, instructionRow{address: a, label: label + ":"})
+		}
+
+		rows = append(rows, instructionRow{address: a})
+	}
+
+	return rows
+}
+
 // drawInstructions shows the disassembled code and current instruction.
 func drawInstructions() {
 	x, y := 406, 12
 
+	// the PC or the call of the selected stack frame
+	pc := framePC()
+
 	// determine if the address window needs to move
-	if Address <= VM.PC-38 || Address >= VM.PC-2 || (Address&1) != (VM.PC&1) {
-		Address = VM.PC - 2
+	if Address <= pc-38 || Address >= pc-2 || (Address&1) != (pc&1) {
+		Address = pc - 2
+	}
+
+	rows := instructionRows(Address)
+
+	// labels may push the PC off the bottom
+	if last := rows[len(rows)-1]; last.address <= pc {
+		Address = pc - 2
+		rows = instructionRows(Address)
 	}
 
 	// show the disassembled instructions
-	for i := 0; i < 38; i += 2 {
-		if Address+uint(i) == VM.PC {
-			if Paused {
+	for i, row := range rows {
+		if row.label != "" {
+			drawText(row.label, x, y+i*10)
+			continue
+		}
+
+		if row.address == pc {
+			if pc != VM.PC {
+				Renderer.SetDrawColor(57, 140, 90, 255)
+			} else if VM.Paused {
 				Renderer.SetDrawColor(176, 32, 57, 255)
 			} else {
 				Renderer.SetDrawColor(57, 102, 176, 255)
@@ -676,20 +995,20 @@ func drawInstructions() {
 			// highlight the current instruction
 			Renderer.FillRect(&sdl.Rect{
 				X: int32(x - 2),
-				Y: int32(y+i*5) - 1,
+				Y: int32(y+i*10) - 1,
 				W: 202,
 				H: 10,
 			})
 		}
 
-		drawText(VM.Disassemble(Address+uint(i)), x, y+i*5)
+		drawText(VM.Disassemble(row.address), x, y+i*10)
 
 		// is there a breakpoint on this instruction?
-		if _, exists := VM.Breakpoints[int(Address)+i]; exists {
+		if _, exists := VM.Breakpoints[int(row.address)]; exists {
 			Renderer.SetDrawColor(255, 0, 0, 255)
 			Renderer.DrawRect(&sdl.Rect{
 				X: int32(x - 2),
-				Y: int32(y+i*5) - 1,
+				Y: int32(y+i*10) - 1,
 				W: 202,
 				H: 10,
 			})
@@ -701,6 +1020,12 @@ func drawInstructions() {
 func drawRegisters() {
 	x, y := 406, 212
 
+	// the call stack takes the place of the registers
+	if ShowCallStack {
+		drawCallStack()
+		return
+	}
+
 	for i := 0; i < 16; i++ {
 		drawText(fmt.Sprintf("V%X = #%02X", i, VM.V[i]), x, y+i*10)
 	}
@@ -0,0 +1,254 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+// Rows of 8 bytes shown in the memory panel.
+const memoryRows = 18
+
+var (
+	// ShowMemory is true if the memory panel is shown over the screen.
+	ShowMemory bool
+
+	// MemoryTop is the address of the first row shown in the memory panel.
+	MemoryTop uint
+
+	// MemoryCursor is the address of the byte selected for editing.
+	MemoryCursor uint
+
+	// EditNibble is the high nibble typed while editing a byte, or -1 if
+	// one hasn't been typed yet.
+	EditNibble = -1
+
+	// LastMemory is the memory seen the last frame, to find what changed.
+	LastMemory [0x1000]byte
+
+	// Changed is how bright the highlight of each recently changed byte of
+	// memory is, fading every frame.
+	Changed [0x1000]uint8
+
+	// HexKeys are the keys typed to edit bytes of memory.
+	HexKeys = map[sdl.Scancode]int{
+		sdl.SCANCODE_0: 0x0,
+		sdl.SCANCODE_1: 0x1,
+		sdl.SCANCODE_2: 0x2,
+		sdl.SCANCODE_3: 0x3,
+		sdl.SCANCODE_4: 0x4,
+		sdl.SCANCODE_5: 0x5,
+		sdl.SCANCODE_6: 0x6,
+		sdl.SCANCODE_7: 0x7,
+		sdl.SCANCODE_8: 0x8,
+		sdl.SCANCODE_9: 0x9,
+		sdl.SCANCODE_A: 0xA,
+		sdl.SCANCODE_B: 0xB,
+		sdl.SCANCODE_C: 0xC,
+		sdl.SCANCODE_D: 0xD,
+		sdl.SCANCODE_E: 0xE,
+		sdl.SCANCODE_F: 0xF,
+	}
+)
+
+// toggleMemory shows or hides the memory panel, which starts at I.
+func toggleMemory() {
+	if ShowMemory = !ShowMemory; ShowMemory {
+		ShowHeatmap, ShowSprite = false, false
+		LastMemory = VM.Memory
+		Changed = [0x1000]uint8{}
+
+		moveCursor(VM.I)
+	}
+}
+
+// showMemory shows the memory panel with an address selected.
+func showMemory(address uint) {
+	if !ShowMemory {
+		toggleMemory()
+	}
+
+	moveCursor(address)
+}
+
+// moveCursor selects a byte of memory, scrolling the panel to show it.
+func moveCursor(address uint) {
+	MemoryCursor = address & 0xFFF
+	EditNibble = -1
+
+	// keep the cursor in view
+	if MemoryCursor < MemoryTop {
+		MemoryTop = MemoryCursor &^ 7
+	}
+
+	if MemoryCursor >= MemoryTop+memoryRows*8 {
+		MemoryTop = (MemoryCursor &^ 7) - (memoryRows-1)*8
+	}
+}
+
+// memoryKey handles a key pressed while the memory panel is shown and
+// returns true if it was used. Hex digits only edit memory while paused;
+// otherwise they are the CHIP-8 keys.
+func memoryKey(code sdl.Scancode) bool {
+	if n, ok := HexKeys[code]; ok && VM.Paused {
+		editMemory(n)
+		return true
+	}
+
+	switch code {
+	case sdl.SCANCODE_LEFT:
+		moveCursor(MemoryCursor - 1)
+	case sdl.SCANCODE_RIGHT:
+		moveCursor(MemoryCursor + 1)
+	case sdl.SCANCODE_UP:
+		moveCursor(MemoryCursor - 8)
+	case sdl.SCANCODE_DOWN:
+		moveCursor(MemoryCursor + 8)
+	case sdl.SCANCODE_PAGEUP:
+		moveCursor(MemoryCursor - memoryRows*8)
+	case sdl.SCANCODE_PAGEDOWN:
+		moveCursor(MemoryCursor + memoryRows*8)
+	case sdl.SCANCODE_HOME:
+		moveCursor(VM.I)
+	case sdl.SCANCODE_END:
+		moveCursor(VM.PC)
+	default:
+		return false
+	}
+
+	return true
+}
+
+// editMemory types a hex digit into the byte at the cursor. Once both
+// nibbles are typed, the byte is written and the cursor advances.
+func editMemory(n int) {
+	if EditNibble < 0 {
+		EditNibble = n
+		return
+	}
+
+	address, b := MemoryCursor, byte(EditNibble<<4|n)
+
+	// writing invalidates any instructions decoded from it
+	Runner.Do(func(vm *chip8.CHIP_8) { vm.Poke(address, b) })
+
+	moveCursor(address + 1)
+}
+
+// clickMemory selects the byte clicked on in the memory panel.
+func clickMemory(x, y int32) {
+	if !ShowMemory || x < 56 || y < 12 || y >= 12+memoryRows*10 {
+		return
+	}
+
+	// each byte is 3 characters wide
+	if col := (x - 56) / 21; col < 8 {
+		moveCursor(MemoryTop + uint(y-12)/10*8 + uint(col))
+	}
+}
+
+// updateChanged finds the bytes of memory changed since the last frame
+// and fades the highlights of those changed before.
+func updateChanged() {
+	for i, b := range VM.Memory {
+		if b != LastMemory[i] {
+			Changed[i] = 255
+		} else if Changed[i] > 0 {
+			Changed[i] -= 5
+		}
+	}
+
+	LastMemory = VM.Memory
+}
+
+// drawMemory shows rows of memory as hex and ASCII, with the byte at I
+// highlighted blue, recently changed bytes red, and the cursor boxed.
+func drawMemory() {
+	x, y := 14, 14
+
+	updateChanged()
+
+	for row := 0; row < memoryRows; row++ {
+		address := (MemoryTop + uint(row*8)) & 0xFFF
+
+		hex, ascii := fmt.Sprintf("%04X ", address), ""
+
+		for i := uint(0); i < 8; i++ {
+			a := (address + i) & 0xFFF
+			b := VM.Memory[a]
+
+			// left edge of the byte
+			bx := int32(x + 42 + int(i)*21)
+			by := int32(y + row*10)
+
+			// highlight I and recently changed bytes
+			if a == VM.I&0xFFF {
+				Renderer.SetDrawColor(57, 102, 176, 255)
+				Renderer.FillRect(&sdl.Rect{X: bx - 1, Y: by - 1, W: 16, H: 9})
+			} else if Changed[a] > 0 {
+				Renderer.SetDrawColor(uint8(int(Changed[a])*176/255), 32, 57, 255)
+				Renderer.FillRect(&sdl.Rect{X: bx - 1, Y: by - 1, W: 16, H: 9})
+			}
+
+			// box the cursor
+			if a == MemoryCursor {
+				Renderer.SetDrawColor(255, 255, 255, 255)
+				Renderer.DrawRect(&sdl.Rect{X: bx - 2, Y: by - 2, W: 18, H: 11})
+			}
+
+			// show the nibble being typed
+			if a == MemoryCursor && EditNibble >= 0 {
+				hex += fmt.Sprintf(" %X_", EditNibble)
+			} else {
+				hex += fmt.Sprintf(" %02X", b)
+			}
+
+			if b >= 32 && b < 127 {
+				ascii += string(rune(b))
+			} else {
+				ascii += "."
+			}
+		}
+
+		drawText(hex, x, y+row*10)
+		drawText(ascii, x+224, y+row*10)
+	}
+
+	// details of the byte at the cursor
+	b := VM.Memory[MemoryCursor]
+
+	drawText(fmt.Sprintf("#%04X", MemoryCursor), 310, 14)
+	drawText(clip(VM.Location(MemoryCursor), 11), 310, 24)
+	drawText(fmt.Sprintf("= #%02X", b), 310, 44)
+	drawText(fmt.Sprintf("= %d", b), 310, 54)
+	drawText(fmt.Sprintf("= %%%08b", b), 310, 64)
+
+	if VM.Paused {
+		drawText("0-F EDIT", 310, 174)
+	} else {
+		drawText("PAUSE TO", 310, 174)
+		drawText("EDIT", 310, 184)
+	}
+}
@@ -0,0 +1,183 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+)
+
+var (
+	// MovieFile is where inputs are recorded to, if recording.
+	MovieFile string
+)
+
+// pressKey presses a CHIP-8 key, unless a movie is supplying the inputs.
+func pressKey(key uint) {
+	Runner.Send(func(vm *chip8.CHIP_8) {
+		if vm.Playback == nil {
+			vm.PressKey(key)
+		}
+	})
+}
+
+// releaseKey releases a CHIP-8 key, unless a movie is supplying the inputs.
+func releaseKey(key uint) {
+	Runner.Send(func(vm *chip8.CHIP_8) {
+		if vm.Playback == nil {
+			vm.ReleaseKey(key)
+		}
+	})
+}
+
+// startRecording begins recording the loaded ROM if a movie file was
+// given on the command line.
+func startRecording() {
+	if MovieFile != "" {
+		Runner.Do(func(vm *chip8.CHIP_8) { vm.Record() })
+
+		Debug.Log("Recording to", filepath.Base(MovieFile))
+	}
+}
+
+// stopRecording ends the current recording and saves it.
+func stopRecording() {
+	var m *chip8.Movie
+
+	if Runner == nil {
+		return
+	}
+
+	// recording must be stopped by the runner
+	Runner.Do(func(vm *chip8.CHIP_8) {
+		if vm.Recording != nil {
+			m = vm.StopRecording()
+		}
+	})
+
+	if m == nil {
+		return
+	}
+
+	if err := m.Save(MovieFile); err != nil {
+		Debug.Logln(err.Error())
+	} else {
+		Debug.Logln("Movie saved to", filepath.Base(MovieFile))
+	}
+}
+
+// playMovie starts playing back a movie into the loaded ROM.
+func playMovie(file string) error {
+	m, err := chip8.LoadMovie(file)
+
+	if err == nil {
+		Runner.Do(func(vm *chip8.CHIP_8) { err = vm.Play(m) })
+	}
+
+	if err != nil {
+		Debug.Logln(err.Error())
+	} else {
+		Debug.Logln("Playing", filepath.Base(file))
+	}
+
+	return err
+}
+
+// runHeadless plays back an entire movie into a ROM without SDL, then
+// prints the final video memory and registers.
+func runHeadless(file, movie string) error {
+	vm, err := chip8.LoadFile(file, ETI)
+	if err != nil {
+		return err
+	}
+
+	m, err := chip8.LoadMovie(movie)
+	if err != nil {
+		return err
+	}
+
+	// profile and cover the entire movie
+	if ProfileFile != "" || PprofFile != "" {
+		vm.StartProfiling()
+	}
+
+	if CoverageFile != "" {
+		vm.StartCoverage()
+	}
+
+	if err = m.Replay(vm); err != nil {
+		return err
+	}
+
+	if p := vm.StopProfiling(); p != nil {
+		if err = saveProfile(p); err != nil {
+			return err
+		}
+	}
+
+	if c := vm.StopCoverage(); c != nil {
+		var b bytes.Buffer
+
+		if err = c.Listing(&b, vm); err == nil {
+			err = ioutil.WriteFile(CoverageFile, b.Bytes(), 0666)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("%s after %d frames (%d instructions)\n", filepath.Base(file), vm.Frame(), vm.Steps)
+
+	// show the final screen
+	w, h := vm.GetResolution()
+
+	for y := 0; y < h; y++ {
+		var line strings.Builder
+
+		for x := 0; x < w; x++ {
+			p := y*w + x
+
+			if vm.Video[p>>3]&(0x80>>uint(p&7)) != 0 {
+				line.WriteByte('#')
+			} else {
+				line.WriteByte('.')
+			}
+		}
+
+		fmt.Println(line.String())
+	}
+
+	// show the final registers
+	for i, v := range vm.V {
+		fmt.Printf("V%X=%02X ", i, v)
+	}
+
+	fmt.Printf("\nI=%04X PC=%04X SP=%02X DT=%02X ST=%02X\n", vm.I, vm.PC, vm.SP, vm.GetDelayTimer(), vm.GetSoundTimer())
+
+	return nil
+}
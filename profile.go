@@ -0,0 +1,125 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/massung/CHIP-8/emulator/chip8"
+)
+
+var (
+	// ProfileFile is where the text profile report is written, if any.
+	ProfileFile string
+
+	// PprofFile is where the pprof profile is written, if any.
+	PprofFile string
+)
+
+// startProfiling begins profiling the loaded ROM.
+func startProfiling() {
+	Runner.Do(func(vm *chip8.CHIP_8) { vm.StartProfiling() })
+
+	Debug.Log("Profiling; press 'P' to stop")
+}
+
+// stopProfiling ends profiling, logs the hot spots, and writes the
+// profile to the files given on the command line.
+func stopProfiling() {
+	var p *chip8.Profile
+
+	if Runner == nil {
+		return
+	}
+
+	// profiling must be stopped by the runner
+	Runner.Do(func(vm *chip8.CHIP_8) { p = vm.StopProfiling() })
+
+	if p == nil {
+		return
+	}
+
+	// log a short report
+	var b bytes.Buffer
+
+	p.Report(&b, 5)
+
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+
+	Debug.Logln(lines[0])
+
+	for _, line := range lines[1:] {
+		Debug.Log(line)
+	}
+
+	if err := saveProfile(p); err != nil {
+		Debug.Logln(err.Error())
+	}
+}
+
+// toggleProfiling starts or stops profiling.
+func toggleProfiling() {
+	if VM.Profiling {
+		stopProfiling()
+	} else {
+		startProfiling()
+	}
+}
+
+// saveProfile writes a profile to the report and pprof files, if set.
+func saveProfile(p *chip8.Profile) error {
+	if ProfileFile != "" {
+		if err := writeFile(ProfileFile, func(f *os.File) error { return p.Report(f, 20) }); err != nil {
+			return err
+		}
+
+		Debug.Log("Profile saved to", filepath.Base(ProfileFile))
+	}
+
+	if PprofFile != "" {
+		if err := writeFile(PprofFile, func(f *os.File) error { return p.WritePprof(f) }); err != nil {
+			return err
+		}
+
+		Debug.Log("Profile saved to", filepath.Base(PprofFile))
+	}
+
+	return nil
+}
+
+// writeFile creates a file and writes it with a function.
+func writeFile(file string, write func(f *os.File) error) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+
+	if err = write(f); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
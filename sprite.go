@@ -0,0 +1,221 @@
+/* Copyright (c) 2017 Jeffrey Massung
+ *
+ * This software is provided 'as-is', without any express or implied
+ * warranty.  In no event will the authors be held liable for any damages
+ * arising from the use of this software.
+ *
+ * Permission is granted to anyone to use this software for any purpose,
+ * including commercial applications, and to alter it and redistribute it
+ * freely, subject to the following restrictions:
+ *
+ * 1. The origin of this software must not be misrepresented; you must not
+ *    claim that you wrote the original software. If you use this software
+ *    in a product, an acknowledgment in the product documentation would be
+ *    appreciated but is not required.
+ *
+ * 2. Altered source versions must be plainly marked as such, and must not be
+ *    misrepresented as being the original software.
+ *
+ * 3. This notice may not be removed or altered from any source distribution.
+ */
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/veandco/go-sdl2/sdl"
+)
+
+var (
+	// ShowSprite is true if the sprite viewer is shown over the screen.
+	ShowSprite bool
+
+	// SpriteAddress is the address of the sprite shown, unless following
+	// the I register.
+	SpriteAddress uint
+
+	// SpriteFollowI is true if the sprite shown is at the I register.
+	SpriteFollowI = true
+
+	// SpriteHeight is N of the sprite shown (0 is a 16x16 sprite).
+	SpriteHeight byte = 5
+)
+
+// toggleSprite shows or hides the sprite viewer.
+func toggleSprite() {
+	if ShowSprite = !ShowSprite; ShowSprite {
+		ShowHeatmap, ShowMemory = false, false
+	}
+}
+
+// showSprite shows the sprite viewer with the sprite at an address.
+func showSprite(address uint) {
+	if !ShowSprite {
+		toggleSprite()
+	}
+
+	SpriteAddress, SpriteFollowI = address&0xFFF, false
+}
+
+// spriteAddress returns the address of the sprite shown.
+func spriteAddress() uint {
+	if SpriteFollowI {
+		return VM.I & 0xFFF
+	}
+
+	return SpriteAddress
+}
+
+// spriteKey handles a key pressed while the sprite viewer is shown and
+// returns true if it was used.
+func spriteKey(code sdl.Scancode) bool {
+	size := uint(SpriteHeight)
+
+	// bytes in a 16x16 sprite
+	if size == 0 {
+		size = 32
+	}
+
+	switch code {
+	case sdl.SCANCODE_UP:
+		SpriteHeight = (SpriteHeight + 1) & 0xF
+	case sdl.SCANCODE_DOWN:
+		SpriteHeight = (SpriteHeight - 1) & 0xF
+	case sdl.SCANCODE_LEFT:
+		showSprite(spriteAddress() - 1)
+	case sdl.SCANCODE_RIGHT:
+		showSprite(spriteAddress() + 1)
+	case sdl.SCANCODE_PAGEUP:
+		showSprite(spriteAddress() - size)
+	case sdl.SCANCODE_PAGEDOWN:
+		showSprite(spriteAddress() + size)
+	case sdl.SCANCODE_HOME:
+		SpriteFollowI = true
+	default:
+		return false
+	}
+
+	return true
+}
+
+// drawSprite shows a copy of the screen with the sprite the next DXYN will
+// draw highlighted at its coordinates, and an enlarged sprite from memory.
+func drawSprite() {
+	w, h := VM.GetResolution()
+
+	// the screen at 2x (high resolution) or 4x (low resolution)
+	scale := int32(256 / w)
+
+	Renderer.Copy(Screen, &sdl.Rect{W: int32(w), H: int32(h)}, &sdl.Rect{X: 14, Y: 14, W: 256, H: 128})
+
+	// highlight the sprite that will be drawn next
+	if pc, ok := VM.NextDraw(); ok {
+		inst := uint(VM.Memory[pc])<<8 | uint(VM.Memory[(pc+1)&0xFFF])
+		x, y := int32(VM.V[inst>>8&0xF]), int32(VM.V[inst>>4&0xF])
+
+		s := VM.Sprite(VM.I, byte(inst&0xF))
+
+		Renderer.SetDrawColor(176, 32, 57, 255)
+
+		// set pixels of the sprite, clipped to the screen
+		for r, row := range s.Rows {
+			for c := 0; c < s.Width; c++ {
+				px, py := x+int32(c), y+int32(r)
+
+				if row&(0x8000>>uint(c)) != 0 && px < int32(w) && py < int32(h) {
+					Renderer.FillRect(&sdl.Rect{X: 14 + px*scale, Y: 14 + py*scale, W: scale, H: scale})
+				}
+			}
+		}
+
+		// outline where it will be drawn, clipped to the screen
+		sw, sh := int32(s.Width), int32(s.Height)
+
+		if x+sw > int32(w) {
+			sw = int32(w) - x
+		}
+
+		if y+sh > int32(h) {
+			sh = int32(h) - y
+		}
+
+		if sw > 0 && sh > 0 {
+			Renderer.SetDrawColor(255, 255, 255, 255)
+			Renderer.DrawRect(&sdl.Rect{
+				X: 14 + x*scale - 1,
+				Y: 14 + y*scale - 1,
+				W: sw*scale + 2,
+				H: sh*scale + 2,
+			})
+		}
+
+		drawText("NEXT "+VM.Disassemble(pc), 14, 154)
+		drawText(clip(fmt.Sprintf("AT %d,%d FROM %s", x, y, VM.Location(VM.I&0xFFF)), 36), 14, 164)
+	} else {
+		drawText("NO DRW AHEAD", 14, 154)
+	}
+
+	// the sprite shown enlarged, 6x6 pixels per pixel
+	s := VM.Sprite(spriteAddress(), SpriteHeight)
+
+	for r, row := range s.Rows {
+		for c := 0; c < s.Width; c++ {
+			if row&(0x8000>>uint(c)) != 0 {
+				Renderer.SetDrawColor(uint8(Foreground>>16), uint8(Foreground>>8), uint8(Foreground), 255)
+			} else {
+				Renderer.SetDrawColor(uint8(Background>>16), uint8(Background>>8), uint8(Background), 255)
+			}
+
+			Renderer.FillRect(&sdl.Rect{X: int32(286 + c*6), Y: int32(14 + r*6), W: 5, H: 5})
+		}
+	}
+
+	// where the sprite is and its size
+	where := fmt.Sprintf("#%04X", s.Address)
+
+	if SpriteFollowI {
+		where += " (I)"
+	}
+
+	drawText(where, 286, 120)
+	drawText(clip(VM.Location(s.Address), 15), 286, 130)
+	drawText(fmt.Sprintf("%dX%d", s.Width, s.Height), 286, 140)
+	drawText("UP/DN  HEIGHT", 286, 164)
+	drawText("LT/RT  MOVE", 286, 174)
+	drawText("HOME   AT I", 286, 184)
+}
+
+// spriteCommand shows a sprite at an address, with an optional height, in
+// the sprite viewer, or the sprite at I with no address.
+func spriteCommand(args []string) error {
+	if len(args) == 0 {
+		if !ShowSprite {
+			toggleSprite()
+		}
+
+		SpriteFollowI = true
+		return nil
+	}
+
+	s, n, err := splitCount(args, int(SpriteHeight))
+	if err != nil {
+		return err
+	}
+
+	// a height of 16 is the same as 0
+	if n > 16 {
+		return fmt.Errorf("Invalid height %d", n)
+	}
+
+	address, err := evalAddress(s)
+	if err != nil {
+		return err
+	}
+
+	SpriteHeight = byte(n & 0xF)
+
+	showSprite(address)
+
+	return nil
+}